@@ -21,14 +21,21 @@ package main
 import (
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"time"
 
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
 
+	"github.com/LINBIT/drbdtop/pkg/alert"
+	"github.com/LINBIT/drbdtop/pkg/audit"
 	"github.com/LINBIT/drbdtop/pkg/collect"
+	"github.com/LINBIT/drbdtop/pkg/config"
 	"github.com/LINBIT/drbdtop/pkg/display"
+	"github.com/LINBIT/drbdtop/pkg/drbdadm"
+	"github.com/LINBIT/drbdtop/pkg/exporter"
 	"github.com/LINBIT/drbdtop/pkg/resource"
+	"github.com/LINBIT/drbdtop/pkg/server"
 )
 
 // Version defines the version of the program and gets set via ldflags
@@ -45,9 +52,58 @@ func main() {
 	interval := app.Flag(
 		"interval", "Time to wait between updating DRBD status, minimum 400ms. Valid units are 'ns', 'us' (or 'µs'), 'ms', 's', 'm', 'h'.").Short('i').Default("1s").String()
 	tui := app.Flag(
-		"tui", "Set the TUI (text/interactive)").Short('t').Default("interactive").String()
+		"tui", "Set the TUI (text/interactive/json)").Short('t').Default("interactive").String()
+	once := app.Flag(
+		"once", "Print a single snapshot and exit (json TUI only)").Bool()
 	expert := app.Flag(
 		"expert", "Enable expert mode (e.g., does not print for confirmation)").Short('e').Bool()
+	mode := app.Flag(
+		"mode", "Commands the interactive TUI offers: none (read-only), the ones that neither take anything down nor destroy data (operator) or all (admin). Defaults to the mode in the configuration, admin otherwise.").Enum(drbdadm.Modes()...)
+	configPath := app.Flag(
+		"config", "Path to a JSON file containing defaults, e.g. {\"mode\": \"operator\"}.").Default(config.DefaultPath).String()
+	sortBy := app.Flag(
+		"sort", "Order of the resources in the interactive TUI, the most interesting first.").Default("danger").Enum(display.SortKeys()...)
+	sortReverse := app.Flag(
+		"sort-reverse", "Reverse the --sort order.").Bool()
+	preview := app.Flag(
+		"preview", "Show a dry run of every command in the interactive TUI and ask for confirmation before running it.").Bool()
+	auditLog := app.Flag(
+		"audit-log", "Append every command run, declined or rejected in the interactive TUI to a file as JSON.").PlaceHolder("/path/to/file").String()
+	auditSyslog := app.Flag(
+		"audit-syslog", "Log every command run, declined or rejected in the interactive TUI to syslog.").Bool()
+	scoring := app.Flag(
+		"scoring", "Path to a JSON file overriding the danger scores of states and metrics.").PlaceHolder("/path/to/file").String()
+	alerts := app.Flag(
+		"alerts", "Path to a JSON file containing alert rules.").PlaceHolder("/path/to/file").String()
+	poll := app.Flag(
+		"poll", "Poll 'drbdsetup events2 --now' every interval instead of following the event stream.").Bool()
+	record := app.Flag(
+		"record", "Append everything collected to a file for a later --replay.").PlaceHolder("/path/to/file").String()
+	replay := app.Flag(
+		"replay", "Replay a file written by --record with its original timing.").PlaceHolder("/path/to/file").String()
+	replaySpeed := app.Flag(
+		"replay-speed", "Initial speed of the --replay.").Default("1").Float64()
+	connect := app.Flag(
+		"connect", "Show the status streamed by 'drbdtop serve' at an address like unix:/run/drbdtop.sock or [tcp:]host:port. The interactive TUI runs no commands then.").PlaceHolder("ADDRESS").String()
+	statsWindow := app.Flag(
+		"stats-window", "Time span the min/max/avg and percentiles of pending, unacked and out-of-sync data are calculated over.").Default("15m").Duration()
+
+	app.Command("top", "Show the status of DRBD resources (default).").Default()
+	exporterCmd := app.Command("exporter", "Export the status of DRBD resources in the Prometheus text format via HTTP.")
+	listen := exporterCmd.Flag(
+		"listen", "Address to listen on for metric requests.").Default(":9942").String()
+	serveCmd := app.Command("serve", "Collect the status of DRBD resources and stream it to 'drbdtop --connect' clients.")
+	serveListen := serveCmd.Flag(
+		"listen", "Address to listen on, unix:/path/to/socket or [tcp:]host:port. Unix sockets can be connected to by all users, TCP addresses by everyone who can reach them, there is no authentication.").Default("unix:/run/drbdtop.sock").String()
+	clusterCmd := app.Command("cluster", "Show the status of DRBD resources on several nodes side by side.")
+	nodes := clusterCmd.Arg(
+		"nodes", "Nodes to collect from, as their host name in the DRBD configuration or as name=address.").Required().Strings()
+	transport := clusterCmd.Flag(
+		"transport", "How to reach the nodes (ssh/command).").Default("ssh").Enum("ssh", "command")
+	sshOptions := clusterCmd.Flag(
+		"ssh-option", "Option passed to ssh, may be repeated.").Strings()
+	nodeCommand := clusterCmd.Flag(
+		"node-command", "Shell command run locally per node by the command transport. '{node}' is replaced by the node's address, the drbdsetup arguments are passed as \"$@\".").PlaceHolder("COMMAND").String()
 
 	// Prints the version.
 	app.Version(Version)
@@ -56,7 +112,7 @@ func main() {
 	app.VersionFlag.Short('v')
 	app.HelpFlag.Short('h')
 
-	kingpin.MustParse(app.Parse(os.Args[1:]))
+	cmd := kingpin.MustParse(app.Parse(os.Args[1:]))
 
 	errors := make(chan error, 100)
 
@@ -70,21 +126,165 @@ func main() {
 		duration = time.Millisecond * 400
 	}
 
+	if *statsWindow <= 0 {
+		log.Fatalf("Invalid statistics window %s", *statsWindow)
+	}
+	resource.SetStatsWindow(*statsWindow)
+
+	if *scoring != "" {
+		profile, err := resource.LoadScoringProfile(*scoring)
+		if err != nil {
+			log.Fatal(err)
+		}
+		resource.SetScoringProfile(profile)
+	}
+
+	if cmd == clusterCmd.FullCommand() {
+		var cluster collect.ClusterPoll
+		var names []string
+		for _, n := range *nodes {
+			node, err := collect.ParseNode(n)
+			if err != nil {
+				log.Fatal(err)
+			}
+			cluster.Nodes = append(cluster.Nodes, node)
+			names = append(names, node.Name)
+		}
+		if *transport == "command" {
+			if *nodeCommand == "" {
+				log.Fatal("The command transport requires --node-command")
+			}
+			cluster.Transport = collect.CommandTransport{Template: *nodeCommand}
+		} else {
+			cluster.Transport = collect.SSHTransport{Options: *sshOptions}
+		}
+		cluster.Interval = duration
+
+		events := make(chan resource.Event, 5)
+		go cluster.Collect(events, errors)
+
+		if *tui == "interactive" {
+			display := display.NewClusterTUI(duration, names)
+			display.SetVersion(Version)
+			display.Display(events, errors)
+		} else if *tui == "text" {
+			display := display.NewClusterPrinter(duration, names)
+			display.Display(events, errors)
+		} else {
+			log.Fatalf("The cluster view does not support the %s TUI", *tui)
+		}
+		return
+	}
+
+	var recorder *collect.Recorder
+	if *record != "" {
+		f, err := os.OpenFile(*record, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		recorder, err = collect.NewRecorder(f)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	var input collect.Collector
+	var player *collect.Replay
+	live := false
 
-	if *file != "" {
+	if *replay != "" {
+		// Recordings contain the prunes of the session, keep the duration
+		// so that they are applied.
+		player = collect.NewReplay(*replay, *replaySpeed)
+		input = player
+	} else if *connect != "" {
+		input = collect.Remote{Address: *connect, Interval: duration}
+	} else if *file != "" {
 		duration = 0 // Set duration to zero to prevent pruning.
 		input = collect.FileCollector{Path: file}
+	} else if *poll {
+		input = collect.Events2Poll{Interval: duration, Recorder: recorder}
+		live = true
 	} else {
-		input = collect.Events2Poll{Interval: duration}
+		input = collect.Events2Stream{Interval: duration, Recorder: recorder}
+		live = true
 	}
 
 	events := make(chan resource.Event, 5)
 	go input.Collect(events, errors)
+	if live {
+		go collect.KernelLog{}.Collect(events, errors)
+	}
+
+	if *alerts != "" {
+		engine, err := alert.LoadEngine(duration, *alerts)
+		if err != nil {
+			log.Fatal(err)
+		}
+		watched := events
+		events = make(chan resource.Event, 5)
+		go engine.Forward(watched, events, errors)
+	}
+
+	if cmd == serveCmd.FullCommand() {
+		l, err := server.Listen(*serveListen)
+		if err != nil {
+			log.Fatal(err)
+		}
+		srv := server.New()
+		go srv.Run(events, errors)
+		log.Fatal(srv.Serve(l))
+	}
+
+	if cmd == exporterCmd.FullCommand() {
+		exp := exporter.New(duration)
+		go exp.Run(events, errors)
+		http.Handle("/metrics", exp)
+		log.Fatal(http.ListenAndServe(*listen, nil))
+	}
 
 	if *tui == "interactive" {
+		conf, err := config.Load(*configPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *mode == "" {
+			*mode = conf.Mode
+		}
+		if *mode == "" {
+			*mode = drbdadm.Admin.String()
+		}
+		permissions, err := drbdadm.ParseMode(*mode)
+		if err != nil {
+			log.Fatalf("Couldn't set the mode: %v", err)
+		}
+		// Commands run on this host, which makes no sense for resources
+		// that are replayed, read from a file or streamed from another node.
+		if !live && permissions != drbdadm.ReadOnly {
+			permissions = drbdadm.ReadOnly
+			errors <- fmt.Errorf("not showing live local resources, switching to %s mode", permissions)
+		}
+		auditlog, err := audit.New(*auditLog, *auditSyslog)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer auditlog.Close()
 		display := display.NewFancyTUI(duration, *expert)
 		display.SetVersion(Version)
+		display.SetMode(permissions)
+		if err := display.SetSort(*sortBy, *sortReverse); err != nil {
+			log.Fatal(err)
+		}
+		display.SetPreview(*preview)
+		display.SetAuditLog(auditlog)
+		display.SetSSHHosts(conf.SSHHosts)
+		if player != nil {
+			display.SetPlayer(player)
+		}
+		display.Display(events, errors)
+	} else if *tui == "json" {
+		display := display.NewJSONPrinter(duration, *once)
 		display.Display(events, errors)
 	} else {
 		display := display.NewUglyPrinter(duration)
@@ -0,0 +1,391 @@
+/*
+ *drbdtop - statistics for DRBD
+ *Copyright © 2017 Hayley Swimelar and Roland Kammerer
+ *
+ *This program is free software; you can redistribute it and/or modify
+ *it under the terms of the GNU General Public License as published by
+ *the Free Software Foundation; either version 2 of the License, or
+ *(at your option) any later version.
+ *
+ *This program is distributed in the hope that it will be useful,
+ *but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ *GNU General Public License for more details.
+ *
+ *You should have received a copy of the GNU General Public License
+ *along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/syslog"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/LINBIT/drbdtop/pkg/resource"
+	"github.com/LINBIT/drbdtop/pkg/update"
+)
+
+// Conditions a Rule can watch for.
+const (
+	QuorumLost             = "quorum-lost"
+	ConnectionNotConnected = "connection-not-connected"
+	DiskNotUpToDate        = "disk-not-uptodate"
+	PeerDiskNotUpToDate    = "peer-disk-not-uptodate"
+	DangerAbove            = "danger-above"
+)
+
+// Time an exec action may run before it is killed.
+const execTimeout = 30 * time.Second
+
+// Duration is a time.Duration that is read from JSON strings such as "30s".
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+// Action is something that is done when a Rule fires. Exactly one of its fields has to be set.
+type Action struct {
+	// Command and arguments to execute, the Alert is passed in DRBDTOP_* environment variables.
+	Exec []string `json:"exec,omitempty"`
+	// Path of a file to append the Alert to.
+	Log string `json:"log,omitempty"`
+	// Write the Alert to syslog.
+	Syslog bool `json:"syslog,omitempty"`
+}
+
+// Rule fires its Actions when its Condition has held for Debounce. It fires
+// again only after the Condition has been cleared for Rearm.
+type Rule struct {
+	Name      string `json:"name"`
+	Condition string `json:"condition"`
+	// Used by DangerAbove.
+	Threshold uint64 `json:"threshold,omitempty"`
+	// Only watch these resources, all resources if empty.
+	Resources []string `json:"resources,omitempty"`
+	Debounce  Duration `json:"debounce,omitempty"`
+	Rearm     Duration `json:"rearm,omitempty"`
+	Actions   []Action `json:"actions"`
+}
+
+// Config is the set of Rules an Engine evaluates.
+type Config struct {
+	Rules []Rule `json:"rules"`
+}
+
+// Alert describes why a Rule fired.
+type Alert struct {
+	Rule       string
+	Condition  string
+	Resource   string
+	Volume     string
+	Connection string
+	State      string
+	Danger     uint64
+	Time       time.Time
+	Message    string
+}
+
+// Env returns the Alert as environment variables for exec actions.
+func (a Alert) Env() []string {
+	return []string{
+		"DRBDTOP_RULE=" + a.Rule,
+		"DRBDTOP_CONDITION=" + a.Condition,
+		"DRBDTOP_RESOURCE=" + a.Resource,
+		"DRBDTOP_VOLUME=" + a.Volume,
+		"DRBDTOP_CONNECTION=" + a.Connection,
+		"DRBDTOP_STATE=" + a.State,
+		"DRBDTOP_DANGER=" + strconv.FormatUint(a.Danger, 10),
+		"DRBDTOP_TIME=" + a.Time.Format(time.RFC3339),
+		"DRBDTOP_MESSAGE=" + a.Message,
+	}
+}
+
+func (a Alert) String() string {
+	return fmt.Sprintf("%s %s: %s", a.Time.Format(time.RFC3339), a.Rule, a.Message)
+}
+
+// A condition returns an Alert for everything in a resource it currently holds
+// for, keyed by what it holds for, e.g. a volume or a connection.
+type condition func(r *update.ByRes, threshold uint64) map[string]Alert
+
+var conditions = map[string]condition{
+	QuorumLost: func(r *update.ByRes, threshold uint64) map[string]Alert {
+		found := make(map[string]Alert)
+		for k, v := range r.Device.Volumes {
+			if v.QuorumAlert {
+				found[k] = Alert{Volume: k, State: v.Quorum,
+					Message: fmt.Sprintf("%s volume %s lost quorum", r.Res.Name, k)}
+			}
+		}
+		return found
+	},
+	ConnectionNotConnected: func(r *update.ByRes, threshold uint64) map[string]Alert {
+		found := make(map[string]Alert)
+		for k, c := range r.Connections {
+			if c.ConnectionStatus != "Connected" {
+				found[k] = Alert{Connection: k, State: c.ConnectionStatus,
+					Message: fmt.Sprintf("%s connection to %s is %s", r.Res.Name, k, c.ConnectionStatus)}
+			}
+		}
+		return found
+	},
+	DiskNotUpToDate: func(r *update.ByRes, threshold uint64) map[string]Alert {
+		found := make(map[string]Alert)
+		for k, v := range r.Device.Volumes {
+			// Intentionally diskless clients never have an UpToDate disk.
+			if v.DiskState != "UpToDate" && !(v.DiskState == "Diskless" && v.Client == "yes") {
+				found[k] = Alert{Volume: k, State: v.DiskState,
+					Message: fmt.Sprintf("%s volume %s is %s", r.Res.Name, k, v.DiskState)}
+			}
+		}
+		return found
+	},
+	PeerDiskNotUpToDate: func(r *update.ByRes, threshold uint64) map[string]Alert {
+		found := make(map[string]Alert)
+		for conn, p := range r.PeerDevices {
+			for k, v := range p.Volumes {
+				if v.DiskState != "UpToDate" && !(v.DiskState == "Diskless" && v.Client == "yes") {
+					found[conn+"/"+k] = Alert{Connection: conn, Volume: k, State: v.DiskState,
+						Message: fmt.Sprintf("%s volume %s on %s is %s", r.Res.Name, k, conn, v.DiskState)}
+				}
+			}
+		}
+		return found
+	},
+	DangerAbove: func(r *update.ByRes, threshold uint64) map[string]Alert {
+		found := make(map[string]Alert)
+		if r.Danger > threshold {
+			found[""] = Alert{State: strconv.FormatUint(r.Danger, 10),
+				Message: fmt.Sprintf("%s danger score %d is above %d", r.Res.Name, r.Danger, threshold)}
+		}
+		return found
+	},
+}
+
+// armState tracks a Rule for a single thing it watches.
+type armState struct {
+	// When the condition started to hold, zero if it doesn't.
+	pendingSince time.Time
+	fired        bool
+	// When the condition stopped to hold after the rule fired.
+	clearSince time.Time
+}
+
+type rule struct {
+	Rule
+	cond      condition
+	resources map[string]bool
+	states    map[string]*armState
+}
+
+// Engine evaluates Rules against the resources it sees and fires their Actions.
+type Engine struct {
+	resources *update.ResourceCollection
+	rules     []*rule
+	errors    chan<- error
+	// fire is called for every Alert, it runs the rule's Actions by default.
+	fire func(r *Rule, a Alert)
+}
+
+// NewEngine returns an Engine for the given Config.
+func NewEngine(d time.Duration, c Config) (*Engine, error) {
+	e := &Engine{resources: update.NewResourceCollection(d)}
+	e.fire = e.runActions
+
+	for i, r := range c.Rules {
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("rule%d", i)
+		}
+		cond, ok := conditions[r.Condition]
+		if !ok {
+			return nil, fmt.Errorf("Rule %s: unknown condition %q", r.Name, r.Condition)
+		}
+		for _, a := range r.Actions {
+			set := 0
+			if len(a.Exec) > 0 {
+				set++
+			}
+			if a.Log != "" {
+				set++
+			}
+			if a.Syslog {
+				set++
+			}
+			if set != 1 {
+				return nil, fmt.Errorf("Rule %s: every action needs exactly one of exec, log or syslog", r.Name)
+			}
+		}
+
+		ru := &rule{Rule: r, cond: cond, states: make(map[string]*armState)}
+		if len(r.Resources) > 0 {
+			ru.resources = make(map[string]bool)
+			for _, res := range r.Resources {
+				ru.resources[res] = true
+			}
+		}
+		e.rules = append(e.rules, ru)
+	}
+
+	return e, nil
+}
+
+// LoadEngine returns an Engine for the JSON encoded Config at path.
+func LoadEngine(d time.Duration, path string) (*Engine, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var c Config
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&c); err != nil {
+		return nil, fmt.Errorf("Couldn't parse alert rules %q: %v", path, err)
+	}
+
+	return NewEngine(d, c)
+}
+
+// Forward passes all Events from in to out and evaluates the rules on every DisplayEvent.
+func (e *Engine) Forward(in <-chan resource.Event, out chan<- resource.Event, errors chan<- error) {
+	e.errors = errors
+	for evt := range in {
+		switch evt.Target {
+		case resource.DisplayEvent:
+			e.resources.UpdateList()
+			e.Check(time.Now())
+		case resource.PruneEvent:
+			e.resources.Prune(evt)
+		default:
+			e.resources.Update(evt)
+		}
+		out <- evt
+	}
+	close(out)
+}
+
+// Check evaluates all rules at time now.
+func (e *Engine) Check(now time.Time) {
+	e.resources.RLock()
+	defer e.resources.RUnlock()
+
+	for _, ru := range e.rules {
+		found := make(map[string]Alert)
+		for _, r := range e.resources.List {
+			if ru.resources != nil && !ru.resources[r.Res.Name] {
+				continue
+			}
+			r.RLock()
+			for k, a := range ru.cond(r, ru.Threshold) {
+				a.Resource = r.Res.Name
+				a.Danger = r.Danger
+				found[r.Res.Name+":"+k] = a
+			}
+			r.RUnlock()
+		}
+
+		for k := range found {
+			if _, ok := ru.states[k]; !ok {
+				ru.states[k] = &armState{}
+			}
+		}
+
+		// Fire in a stable order.
+		var keys []string
+		for k := range ru.states {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			s := ru.states[k]
+			a, holds := found[k]
+			switch {
+			case holds && !s.fired:
+				if s.pendingSince.IsZero() {
+					s.pendingSince = now
+				}
+				if now.Sub(s.pendingSince) >= ru.Debounce.Duration {
+					a.Rule = ru.Name
+					a.Condition = ru.Condition
+					a.Time = now
+					e.fire(&ru.Rule, a)
+					s.fired = true
+					s.clearSince = time.Time{}
+				}
+			case holds && s.fired:
+				s.clearSince = time.Time{}
+			case !holds && !s.fired:
+				delete(ru.states, k)
+			case !holds && s.fired:
+				if s.clearSince.IsZero() {
+					s.clearSince = now
+				}
+				if now.Sub(s.clearSince) >= ru.Rearm.Duration {
+					delete(ru.states, k)
+				}
+			}
+		}
+	}
+}
+
+func (e *Engine) runActions(r *Rule, a Alert) {
+	for _, act := range r.Actions {
+		go func(act Action) {
+			if err := run(act, a); err != nil && e.errors != nil {
+				e.errors <- fmt.Errorf("Rule %s: %v", r.Name, err)
+			}
+		}(act)
+	}
+}
+
+func run(act Action, a Alert) error {
+	switch {
+	case len(act.Exec) > 0:
+		ctx, cancel := context.WithTimeout(context.Background(), execTimeout)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, act.Exec[0], act.Exec[1:]...)
+		cmd.Env = append(os.Environ(), a.Env()...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("%s failed: %v: %s", act.Exec[0], err, out)
+		}
+	case act.Log != "":
+		f, err := os.OpenFile(act.Log, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if _, err := fmt.Fprintln(f, a); err != nil {
+			return err
+		}
+	case act.Syslog:
+		w, err := syslog.New(syslog.LOG_WARNING|syslog.LOG_DAEMON, "drbdtop")
+		if err != nil {
+			return err
+		}
+		defer w.Close()
+		return w.Warning(a.Rule + ": " + a.Message)
+	}
+	return nil
+}
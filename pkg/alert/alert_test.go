@@ -0,0 +1,209 @@
+/*
+ *drbdtop - statistics for DRBD
+ *Copyright © 2017 Hayley Swimelar and Roland Kammerer
+ *
+ *This program is free software; you can redistribute it and/or modify
+ *it under the terms of the GNU General Public License as published by
+ *the Free Software Foundation; either version 2 of the License, or
+ *(at your option) any later version.
+ *
+ *This program is distributed in the hope that it will be useful,
+ *but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ *GNU General Public License for more details.
+ *
+ *You should have received a copy of the GNU General Public License
+ *along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package alert
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LINBIT/drbdtop/pkg/resource"
+)
+
+func feed(t *testing.T, e *Engine, s string) {
+	evt, err := resource.NewEvent(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.resources.Update(evt)
+	e.resources.UpdateList()
+}
+
+func TestEngineDebounceRearm(t *testing.T) {
+	e, err := NewEngine(time.Second, Config{Rules: []Rule{{
+		Name:      "link",
+		Condition: ConnectionNotConnected,
+		Debounce:  Duration{5 * time.Second},
+		Rearm:     Duration{time.Minute},
+		Actions:   []Action{{Syslog: true}},
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fired []Alert
+	e.fire = func(r *Rule, a Alert) { fired = append(fired, a) }
+
+	connected := "2017-02-15T14:43:16.688437+00:00 exists connection name:r0 conn-name:peer connection:Connected role:Secondary congested:no"
+	standalone := "2017-02-15T14:43:16.688437+00:00 change connection name:r0 conn-name:peer connection:StandAlone role:Unknown congested:no"
+	start := time.Date(2017, 2, 15, 14, 43, 16, 0, time.UTC)
+
+	feed(t, e, "2017-02-15T14:43:16.688437+00:00 exists resource name:r0 role:Primary suspended:no write-ordering:flush")
+	feed(t, e, connected)
+	e.Check(start)
+
+	// The link flaps quicker than the debounce time.
+	feed(t, e, standalone)
+	e.Check(start.Add(1 * time.Second))
+	feed(t, e, connected)
+	e.Check(start.Add(2 * time.Second))
+	feed(t, e, standalone)
+	e.Check(start.Add(3 * time.Second))
+	e.Check(start.Add(7 * time.Second))
+	if len(fired) != 0 {
+		t.Fatalf("Expected no alert while debouncing, got %v", fired)
+	}
+
+	e.Check(start.Add(8 * time.Second))
+	if len(fired) != 1 {
+		t.Fatalf("Expected a single alert, got %v", fired)
+	}
+	a := fired[0]
+	if a.Rule != "link" || a.Resource != "r0" || a.Connection != "peer" || a.State != "StandAlone" {
+		t.Errorf("Unexpected alert %+v", a)
+	}
+
+	// Flapping after the alert doesn't fire again until the rule is re-armed.
+	feed(t, e, connected)
+	e.Check(start.Add(10 * time.Second))
+	feed(t, e, standalone)
+	e.Check(start.Add(20 * time.Second))
+	e.Check(start.Add(90 * time.Second))
+	if len(fired) != 1 {
+		t.Fatalf("Expected a single alert while not re-armed, got %v", fired)
+	}
+
+	feed(t, e, connected)
+	e.Check(start.Add(100 * time.Second))
+	e.Check(start.Add(160 * time.Second))
+	feed(t, e, standalone)
+	e.Check(start.Add(161 * time.Second))
+	e.Check(start.Add(166 * time.Second))
+	if len(fired) != 2 {
+		t.Fatalf("Expected a second alert after re-arming, got %v", fired)
+	}
+}
+
+func TestEngineConditions(t *testing.T) {
+	var rules []Rule
+	for _, c := range []string{QuorumLost, DiskNotUpToDate, PeerDiskNotUpToDate, DangerAbove} {
+		rules = append(rules, Rule{Name: c, Condition: c, Threshold: 20, Resources: []string{"r0"}})
+	}
+	e, err := NewEngine(time.Second, Config{Rules: rules})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fired := make(map[string]Alert)
+	e.fire = func(r *Rule, a Alert) { fired[r.Name] = a }
+
+	feed(t, e, "2017-02-15T14:43:16.688437+00:00 exists resource name:r0 role:Primary suspended:no write-ordering:flush")
+	feed(t, e, "2017-02-15T14:43:16.688437+00:00 exists device name:r0 volume:0 minor:0 disk:Outdated client:no quorum:no size:4056 read:0 written:0 al-writes:0 bm-writes:0 upper-pending:0 lower-pending:0 al-suspended:no blocked:no")
+	feed(t, e, "2017-02-15T14:43:16.688437+00:00 exists peer-device name:r0 conn-name:peer volume:0 replication:Off peer-disk:DUnknown resync-suspended:no received:0 sent:0 out-of-sync:0 pending:0 unacked:0")
+	// Not watched by any rule.
+	feed(t, e, "2017-02-15T14:43:16.688437+00:00 exists resource name:r1 role:Secondary suspended:no write-ordering:flush")
+	feed(t, e, "2017-02-15T14:43:16.688437+00:00 exists device name:r1 volume:0 minor:1 disk:Outdated client:no quorum:no size:4056 read:0 written:0 al-writes:0 bm-writes:0 upper-pending:0 lower-pending:0 al-suspended:no blocked:no")
+
+	e.Check(time.Now())
+
+	if a, ok := fired[QuorumLost]; !ok || a.Volume != "0" {
+		t.Errorf("Expected quorum loss of volume 0 to fire, got %+v", a)
+	}
+	if a, ok := fired[DiskNotUpToDate]; !ok || a.State != "Outdated" {
+		t.Errorf("Expected Outdated disk to fire, got %+v", a)
+	}
+	if a, ok := fired[PeerDiskNotUpToDate]; !ok || a.Connection != "peer" || a.State != "DUnknown" {
+		t.Errorf("Expected DUnknown peer disk to fire, got %+v", a)
+	}
+	if a, ok := fired[DangerAbove]; !ok || a.Danger <= 20 {
+		t.Errorf("Expected danger above 20 to fire, got %+v", a)
+	}
+	for _, a := range fired {
+		if a.Resource != "r0" {
+			t.Errorf("Expected only r0 to be watched, got %+v", a)
+		}
+	}
+}
+
+func TestLoadEngine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "drbdtop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	logPath := filepath.Join(dir, "alerts.log")
+	path := filepath.Join(dir, "rules.json")
+	rules := `{"rules": [{"name": "quorum", "condition": "quorum-lost", "debounce": "10s", "rearm": "5m",
+		"actions": [{"log": "` + logPath + `"}]}]}`
+	if err := ioutil.WriteFile(path, []byte(rules), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := LoadEngine(time.Second, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.rules[0].Debounce.Duration != 10*time.Second || e.rules[0].Rearm.Duration != 5*time.Minute {
+		t.Errorf("Expected debounce of 10s and re-arm of 5m, got %v and %v", e.rules[0].Debounce, e.rules[0].Rearm)
+	}
+
+	a := Alert{Rule: "quorum", Time: time.Now(), Message: "r0 volume 0 lost quorum"}
+	if err := run(e.rules[0].Actions[0], a); err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), "quorum: r0 volume 0 lost quorum") {
+		t.Errorf("Expected the alert to be logged, got %q", out)
+	}
+
+	if _, err := NewEngine(time.Second, Config{Rules: []Rule{{Condition: "bogus"}}}); err == nil {
+		t.Error("Expected an unknown condition to be rejected")
+	}
+	if _, err := NewEngine(time.Second, Config{Rules: []Rule{{Condition: QuorumLost, Actions: []Action{{}}}}}); err == nil {
+		t.Error("Expected an action without anything to do to be rejected")
+	}
+}
+
+func TestExecAction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "drbdtop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "out")
+	act := Action{Exec: []string{"sh", "-c", `echo "$DRBDTOP_RESOURCE $DRBDTOP_STATE" > ` + out}}
+	if err := run(act, Alert{Resource: "r0", State: "StandAlone"}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "r0 StandAlone\n" {
+		t.Errorf("Expected the alert in the environment, got %q", b)
+	}
+}
@@ -0,0 +1,193 @@
+/*
+ *drbdtop - statistics for DRBD
+ *Copyright © 2017 Hayley Swimelar and Roland Kammerer
+ *
+ *This program is free software; you can redistribute it and/or modify
+ *it under the terms of the GNU General Public License as published by
+ *the Free Software Foundation; either version 2 of the License, or
+ *(at your option) any later version.
+ *
+ *This program is distributed in the hope that it will be useful,
+ *but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ *GNU General Public License for more details.
+ *
+ *You should have received a copy of the GNU General Public License
+ *along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/syslog"
+	"os"
+	"os/exec"
+	"os/user"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry records a command that was run, declined or rejected in drbdtop.
+type Entry struct {
+	Time time.Time `json:"time"`
+	User string    `json:"user"`
+	// The user that started drbdtop via sudo, if any.
+	SudoUser string   `json:"sudo_user,omitempty"`
+	Host     string   `json:"host"`
+	Targets  []string `json:"targets"`
+	Argv     []string `json:"argv"`
+	// Set if the user had to confirm the command.
+	ConfirmationRequired bool `json:"confirmation_required"`
+	// Set if the user declined to run the command, it was not run then.
+	Declined bool `json:"declined,omitempty"`
+	// Why drbdtop refused to run the command, it was not run then.
+	Rejected string `json:"rejected,omitempty"`
+	// -1 if the command did not exit on its own, e.g. because it timed out.
+	ExitStatus int `json:"exit_status"`
+	// Why the command failed to run at all, if it did.
+	Error  string `json:"error,omitempty"`
+	Output string `json:"output"`
+}
+
+// NewEntry returns an Entry for argv run on targets by the current user now.
+func NewEntry(argv, targets []string) Entry {
+	e := Entry{
+		Time:     time.Now(),
+		User:     os.Getenv("USER"),
+		SudoUser: os.Getenv("SUDO_USER"),
+		Targets:  targets,
+		Argv:     argv,
+	}
+	if u, err := user.Current(); err == nil {
+		e.User = u.Username
+	}
+	if host, err := os.Hostname(); err == nil {
+		e.Host = host
+	}
+	return e
+}
+
+// SetResult records the outcome of running the command.
+func (e *Entry) SetResult(out []byte, err error) {
+	e.Output = string(out)
+	if err == nil {
+		return
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		e.ExitStatus = exitErr.ExitCode()
+	} else {
+		e.ExitStatus = -1
+	}
+	if e.ExitStatus == -1 {
+		e.Error = err.Error()
+	}
+}
+
+// Status describes the outcome of the command in a few words.
+func (e Entry) Status() string {
+	switch {
+	case e.Declined:
+		return "declined"
+	case e.Rejected != "":
+		return "rejected: " + e.Rejected
+	case e.Error != "":
+		return "failed: " + e.Error
+	case e.ExitStatus != 0:
+		return fmt.Sprintf("failed with exit status %d", e.ExitStatus)
+	}
+	return "ok"
+}
+
+func (e Entry) String() string {
+	user := e.User
+	if e.SudoUser != "" {
+		user += " (sudo by " + e.SudoUser + ")"
+	}
+	confirmation := ""
+	if e.ConfirmationRequired {
+		confirmation = ", confirmation required"
+	}
+	return fmt.Sprintf("%s %s@%s: %s: %s%s",
+		e.Time.Format(time.RFC3339), user, e.Host, strings.Join(e.Argv, " "), e.Status(), confirmation)
+}
+
+// Log records Entries to an append-only file and/or syslog and keeps the
+// ones of the current session. The zero value only keeps the session's
+// Entries.
+type Log struct {
+	sync.Mutex
+	file    *os.File
+	syslog  *syslog.Writer
+	history []Entry
+}
+
+// New returns a Log appending to the file at path, unless path is empty, and
+// writing to syslog if useSyslog is set.
+func New(path string, useSyslog bool) (*Log, error) {
+	l := &Log{}
+	if path != "" {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't open the audit log: %v", err)
+		}
+		l.file = f
+	}
+	if useSyslog {
+		w, err := syslog.New(syslog.LOG_NOTICE|syslog.LOG_AUTH, "drbdtop")
+		if err != nil {
+			l.Close()
+			return nil, fmt.Errorf("Couldn't connect to syslog: %v", err)
+		}
+		l.syslog = w
+	}
+	return l, nil
+}
+
+// Record adds e to the history and writes it to the file and syslog.
+func (l *Log) Record(e Entry) error {
+	l.Lock()
+	defer l.Unlock()
+
+	l.history = append(l.history, e)
+
+	if l.file != nil {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if _, err := l.file.Write(append(b, '\n')); err != nil {
+			return fmt.Errorf("Couldn't write the audit log: %v", err)
+		}
+	}
+	if l.syslog != nil {
+		if err := l.syslog.Notice(e.String()); err != nil {
+			return fmt.Errorf("Couldn't write the audit log to syslog: %v", err)
+		}
+	}
+	return nil
+}
+
+// History returns the Entries recorded since the Log was created.
+func (l *Log) History() []Entry {
+	l.Lock()
+	defer l.Unlock()
+
+	return append([]Entry(nil), l.history...)
+}
+
+// Close closes the file and the connection to syslog.
+func (l *Log) Close() error {
+	var err error
+	if l.file != nil {
+		err = l.file.Close()
+	}
+	if l.syslog != nil {
+		if e := l.syslog.Close(); err == nil {
+			err = e
+		}
+	}
+	return err
+}
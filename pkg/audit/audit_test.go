@@ -0,0 +1,126 @@
+/*
+ *drbdtop - statistics for DRBD
+ *Copyright © 2017 Hayley Swimelar and Roland Kammerer
+ *
+ *This program is free software; you can redistribute it and/or modify
+ *it under the terms of the GNU General Public License as published by
+ *the Free Software Foundation; either version 2 of the License, or
+ *(at your option) any later version.
+ *
+ *This program is distributed in the hope that it will be useful,
+ *but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ *GNU General Public License for more details.
+ *
+ *You should have received a copy of the GNU General Public License
+ *along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSetResult(t *testing.T) {
+	e := NewEntry([]string{"false"}, nil)
+	e.SetResult(exec.Command("sh", "-c", "echo oops; exit 3").CombinedOutput())
+	if e.ExitStatus != 3 || e.Output != "oops\n" || e.Error != "" {
+		t.Errorf("Expected exit status 3 and output %q, got %d %q %q", "oops\n", e.ExitStatus, e.Output, e.Error)
+	}
+	if s := e.Status(); s != "failed with exit status 3" {
+		t.Errorf("Expected status %q, got %q", "failed with exit status 3", s)
+	}
+
+	e = NewEntry([]string{"does-not-exist"}, nil)
+	e.SetResult(exec.Command("/does/not/exist").CombinedOutput())
+	if e.ExitStatus != -1 || e.Error == "" {
+		t.Errorf("Expected exit status -1 and an error, got %d %q", e.ExitStatus, e.Error)
+	}
+
+	e = NewEntry([]string{"true"}, nil)
+	e.SetResult(nil, nil)
+	if e.Status() != "ok" {
+		t.Errorf("Expected status %q, got %q", "ok", e.Status())
+	}
+
+	e = NewEntry([]string{"drbdadm", "down", "r0"}, nil)
+	e.Rejected = "r0/0 is open"
+	if s := e.Status(); s != "rejected: r0/0 is open" {
+		t.Errorf("Expected status %q, got %q", "rejected: r0/0 is open", s)
+	}
+}
+
+func TestLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "drbdtop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	// Entries are appended to what is already there.
+	if err := ioutil.WriteFile(path, []byte("{}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := New(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adjust := NewEntry([]string{"drbdadm", "adjust", "r0"}, []string{"r0"})
+	adjust.SetResult([]byte("done\n"), nil)
+	invalidate := NewEntry([]string{"drbdadm", "invalidate", "r0/0"}, []string{"r0/0"})
+	invalidate.ConfirmationRequired = true
+	invalidate.Declined = true
+	down := NewEntry([]string{"drbdadm", "down", "r0"}, []string{"r0"})
+	down.Rejected = "r0/0 is open"
+	for _, e := range []Entry{adjust, invalidate, down} {
+		if err := l.Record(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if h := l.History(); len(h) != 3 || h[1].Argv[1] != "invalidate" {
+		t.Errorf("Expected both entries in the history, got %v", h)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var got []Entry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, e)
+	}
+	if len(got) != 4 {
+		t.Fatalf("Expected 4 lines in the audit log, got %d", len(got))
+	}
+	for i, e := range []Entry{adjust, invalidate, down} {
+		// Times lose their monotonic clock reading in JSON.
+		e.Time = e.Time.Round(0)
+		if !got[i+1].Time.Equal(e.Time) {
+			t.Errorf("Expected time %v, got %v", e.Time, got[i+1].Time)
+		}
+		got[i+1].Time = e.Time
+		if !reflect.DeepEqual(got[i+1], e) {
+			t.Errorf("Expected %+v, got %+v", e, got[i+1])
+		}
+	}
+}
@@ -0,0 +1,170 @@
+/*
+ *drbdtop - statistics for DRBD
+ *Copyright © 2017 Hayley Swimelar and Roland Kammerer
+ *
+ *This program is free software; you can redistribute it and/or modify
+ *it under the terms of the GNU General Public License as published by
+ *the Free Software Foundation; either version 2 of the License, or
+ *(at your option) any later version.
+ *
+ *This program is distributed in the hope that it will be useful,
+ *but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ *GNU General Public License for more details.
+ *
+ *You should have received a copy of the GNU General Public License
+ *along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package collect
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/LINBIT/drbdtop/pkg/resource"
+)
+
+// Node is a member of a cluster.
+type Node struct {
+	// Name of the node, ideally its host name in the DRBD configuration.
+	Name string
+	// Address the Transport uses to reach the node.
+	Address string
+}
+
+// ParseNode parses a node given as "name" or as "name=address".
+func ParseNode(s string) (Node, error) {
+	name, address := s, s
+	if i := strings.Index(s, "="); i >= 0 {
+		name, address = s[:i], s[i+1:]
+	}
+	if name == "" || address == "" {
+		return Node{}, fmt.Errorf("Couldn't parse node %q, expected name or name=address", s)
+	}
+	return Node{Name: name, Address: address}, nil
+}
+
+// Transport runs drbdsetup on the nodes of a cluster.
+type Transport interface {
+	// Command returns a command that runs drbdsetup with args on node.
+	Command(node Node, args ...string) *exec.Cmd
+}
+
+// SSHTransport runs drbdsetup via ssh.
+type SSHTransport struct {
+	// Options passed to ssh in front of the address.
+	Options []string
+}
+
+func (t SSHTransport) Command(node Node, args ...string) *exec.Cmd {
+	sshArgs := append([]string{"-o", "BatchMode=yes", "-o", "ConnectTimeout=10"}, t.Options...)
+	sshArgs = append(sshArgs, node.Address, "drbdsetup")
+	sshArgs = append(sshArgs, args...)
+	return exec.Command("ssh", sshArgs...)
+}
+
+// CommandTransport runs a local shell command instead of drbdsetup for every
+// node, e.g. to reach the nodes some other way or to stand in for them with
+// recorded output. Every "{node}" in Template is replaced by the address of
+// the node, the drbdsetup arguments are passed as "$@". The name of the node
+// is also available as DRBDTOP_NODE.
+type CommandTransport struct {
+	Template string
+}
+
+func (t CommandTransport) Command(node Node, args ...string) *exec.Cmd {
+	script := strings.Replace(t.Template, "{node}", node.Address, -1)
+	cmd := exec.Command("sh", append([]string{"-c", script, "drbdsetup"}, args...)...)
+	cmd.Env = append(os.Environ(), "DRBDTOP_NODE="+node.Name)
+	return cmd
+}
+
+// ClusterPoll calls drbdsetup events2 on every node of a cluster at a
+// specified Interval. Events are tagged with the name of the node they are
+// from.
+type ClusterPoll struct {
+	Nodes     []Node
+	Transport Transport
+	// Interval to wait between polling the nodes.
+	Interval time.Duration
+}
+
+type nodeOutput struct {
+	out []byte
+	err error
+}
+
+func (c ClusterPoll) Collect(events chan<- resource.Event, errors chan<- error) {
+	ticker := time.NewTicker(c.Interval)
+	displayEvent := resource.NewDisplayEvent()
+	// History of the last 3 poll cycle timestamps of every node, the nodes'
+	// clocks don't have to agree.
+	timeBacklog := make(map[string][]time.Time)
+	for {
+		outputs := c.poll()
+		for i, node := range c.Nodes {
+			if outputs[i].err != nil {
+				// Keep the last known state, it's pruned once the node answers again.
+				errors <- fmt.Errorf("unable to poll node %s: %v", node.Name, outputs[i].err)
+				continue
+			}
+
+			pollTime := time.Now()
+			havePollTime := false
+			for _, e := range strings.Split(string(outputs[i].out), "\n") {
+				if e == "" {
+					continue
+				}
+				evt, err := resource.NewEvent(e)
+				if err != nil {
+					errors <- fmt.Errorf("node %s: %v", node.Name, err)
+					continue
+				}
+				evt.Node = node.Name
+				if evt.TimeStamp.Before(pollTime) || !havePollTime {
+					pollTime = evt.TimeStamp
+					havePollTime = true
+				}
+				events <- evt
+			}
+
+			backlog := timeBacklog[node.Name]
+			if len(backlog) >= 3 {
+				pruneEvent := resource.NewPruneEvent()
+				pruneEvent.TimeStamp = backlog[0]
+				pruneEvent.Node = node.Name
+				events <- pruneEvent
+				backlog = backlog[1:]
+			}
+			timeBacklog[node.Name] = append(backlog, pollTime)
+		}
+		events <- displayEvent
+		<-ticker.C
+	}
+}
+
+// poll runs drbdsetup events2 on all nodes at once and returns the outputs
+// in the order of Nodes.
+func (c ClusterPoll) poll() []nodeOutput {
+	outputs := make([]nodeOutput, len(c.Nodes))
+	var wg sync.WaitGroup
+	for i, node := range c.Nodes {
+		wg.Add(1)
+		go func(i int, node Node) {
+			defer wg.Done()
+			cmd := c.Transport.Command(node, "events2", "--timestamps", "--statistics", "--now")
+			out, err := cmd.Output()
+			if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+				err = fmt.Errorf("%v: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+			}
+			outputs[i] = nodeOutput{out: out, err: err}
+		}(i, node)
+	}
+	wg.Wait()
+	return outputs
+}
@@ -0,0 +1,87 @@
+/*
+ *drbdtop - statistics for DRBD
+ *Copyright © 2017 Hayley Swimelar and Roland Kammerer
+ *
+ *This program is free software; you can redistribute it and/or modify
+ *it under the terms of the GNU General Public License as published by
+ *the Free Software Foundation; either version 2 of the License, or
+ *(at your option) any later version.
+ *
+ *This program is distributed in the hope that it will be useful,
+ *but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ *GNU General Public License for more details.
+ *
+ *You should have received a copy of the GNU General Public License
+ *along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package collect
+
+import (
+	"testing"
+	"time"
+
+	"github.com/LINBIT/drbdtop/pkg/resource"
+)
+
+func TestParseNode(t *testing.T) {
+	var tests = []struct {
+		in  string
+		out Node
+		err bool
+	}{
+		{"alpha", Node{Name: "alpha", Address: "alpha"}, false},
+		{"alpha=root@10.0.0.1", Node{Name: "alpha", Address: "root@10.0.0.1"}, false},
+		{"=10.0.0.1", Node{}, true},
+		{"alpha=", Node{}, true},
+	}
+
+	for _, tt := range tests {
+		node, err := ParseNode(tt.in)
+		if (err != nil) != tt.err {
+			t.Errorf("ParseNode(%q): Expected error %t, got %v", tt.in, tt.err, err)
+		}
+		if node != tt.out {
+			t.Errorf("ParseNode(%q): Expected %+v, got %+v", tt.in, tt.out, node)
+		}
+	}
+}
+
+func TestClusterPoll(t *testing.T) {
+	c := ClusterPoll{
+		Nodes: []Node{{Name: "alpha", Address: "10.0.0.1"}, {Name: "beta", Address: "10.0.0.2"}},
+		Transport: CommandTransport{
+			Template: `echo "2017-02-15T14:43:16.688437+00:00 exists resource name:{node} role:$DRBDTOP_NODE drbdsetup:$1"`,
+		},
+		Interval: time.Hour,
+	}
+
+	events := make(chan resource.Event, 10)
+	errors := make(chan error, 10)
+	go c.Collect(events, errors)
+
+	for _, node := range c.Nodes {
+		evt := <-events
+		if evt.Node != node.Name {
+			t.Errorf("Expected event from %s, got %q", node.Name, evt.Node)
+		}
+		if evt.Fields[resource.ResKeys.Name] != node.Address {
+			t.Errorf("Expected {node} to be replaced by %s, got %q", node.Address, evt.Fields[resource.ResKeys.Name])
+		}
+		if evt.Fields[resource.ResKeys.Role] != node.Name {
+			t.Errorf("Expected DRBDTOP_NODE to be %s, got %q", node.Name, evt.Fields[resource.ResKeys.Role])
+		}
+		if evt.Fields["drbdsetup"] != "events2" {
+			t.Errorf("Expected the drbdsetup arguments to be passed, got %q", evt.Fields["drbdsetup"])
+		}
+	}
+	if evt := <-events; evt.Target != resource.DisplayEvent {
+		t.Errorf("Expected a DisplayEvent after polling all nodes, got %q", evt.Target)
+	}
+	select {
+	case err := <-errors:
+		t.Error(err)
+	default:
+	}
+}
@@ -21,8 +21,10 @@ package collect
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"sort"
 	"strings"
 	"time"
 
@@ -36,6 +38,8 @@ type Collector interface {
 }
 
 // FileCollector gathers newline delimited events from a plaintext file.
+// Files written by a Recorder are displayed once per recorded cycle, any
+// other file after every single event.
 type FileCollector struct {
 	Path *string
 }
@@ -47,25 +51,55 @@ func (c FileCollector) Collect(events chan<- resource.Event, errors chan<- error
 	}
 	defer f.Close()
 
+	if err := scanEvents(f, events, errors); err != nil {
+		errors <- err
+	}
+	events <- resource.NewEOF()
+}
+
+// scanEvents sends the events read from r until it is exhausted. Input
+// written by a Recorder is displayed once per recorded cycle, any other
+// input after every single event.
+func scanEvents(r io.Reader, events chan<- resource.Event, errors chan<- error) error {
 	displayEvent := resource.NewDisplayEvent()
-	scanner := bufio.NewScanner(f)
+	cycles := false
+	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
 		e := scanner.Text()
+		if isComment(e) {
+			if _, ok := isCycleMarker(e); ok {
+				cycles = true
+				events <- displayEvent
+			} else if t, ok := isPruneMarker(e); ok {
+				pruneEvent := resource.NewPruneEvent()
+				pruneEvent.TimeStamp = t
+				events <- pruneEvent
+			} else if e == resetMarker {
+				events <- resource.NewResetEvent()
+			} else if e == recordHeader {
+				cycles = true
+			}
+			continue
+		}
 		evt, err := resource.NewEvent(e)
 		if err != nil {
 			errors <- err
 		} else {
 			events <- evt
 		}
-		events <- displayEvent
+		if !cycles {
+			events <- displayEvent
+		}
 	}
-	events <- resource.NewEOF()
+	return scanner.Err()
 }
 
 // Events2Poll continuously calls drbdsetup events2 at a specified Interval.
 type Events2Poll struct {
 	// Interval to wait between calls to drbdsetup devents2
 	Interval time.Duration
+	// Recorder, if set, records everything that is collected.
+	Recorder *Recorder
 }
 
 func (c Events2Poll) Collect(events chan<- resource.Event, errors chan<- error) {
@@ -90,6 +124,9 @@ func (c Events2Poll) Collect(events chan<- resource.Event, errors chan<- error)
 			s := string(out)
 			for _, e := range strings.Split(s, "\n") {
 				if e != "" {
+					if err := c.Recorder.Line(e); err != nil {
+						errors <- err
+					}
 					evt, err := resource.NewEvent(e)
 					if err != nil {
 						errors <- err
@@ -107,23 +144,244 @@ func (c Events2Poll) Collect(events chan<- resource.Event, errors chan<- error)
 			}
 		}
 		for res := range remainingResources {
-			events <- resource.NewUnconfiguredRes(res)
+			evt := resource.NewUnconfiguredRes(res)
+			if err := c.Recorder.Event(evt); err != nil {
+				errors <- err
+			}
+			events <- evt
 		}
 		if len(timeBacklog) >= 3 {
 			// PruneEvent instances are generated when needed to avoid reusing and modifying
 			// an existing event that may be queued in a channel
 			pruneEvent := resource.NewPruneEvent()
 			pruneEvent.TimeStamp = timeBacklog[0]
+			if err := c.Recorder.Prune(pruneEvent.TimeStamp); err != nil {
+				errors <- err
+			}
 			events <- pruneEvent
 			timeBacklog = append(timeBacklog[1:], pollTime)
 		} else {
 			timeBacklog = append(timeBacklog, pollTime)
 		}
+		if err := c.Recorder.Cycle(time.Now()); err != nil {
+			errors <- err
+		}
+		events <- displayEvent
+		<-ticker.C
+	}
+}
+
+// Events2Stream follows a single long-running drbdsetup events2 process, so
+// that every state transition is seen as it happens instead of once per poll.
+type Events2Stream struct {
+	// Interval to wait between statistics refreshes and display updates.
+	Interval time.Duration
+	// Recorder, if set, records everything that is collected.
+	Recorder *Recorder
+}
+
+func (c Events2Stream) Collect(events chan<- resource.Event, errors chan<- error) {
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+	displayEvent := resource.NewDisplayEvent()
+
+	for {
+		if err := c.follow(events, errors, ticker.C); err != nil {
+			errors <- err
+		}
+		// drbdsetup went away, give it a tick before restarting it.
 		events <- displayEvent
 		<-ticker.C
 	}
 }
 
+// follow runs drbdsetup events2 until it exits. With --poll, drbdsetup prints
+// the current state including statistics every time it reads a newline on
+// stdin, which is used to refresh the statistics once per Interval.
+func (c Events2Stream) follow(events chan<- resource.Event, errors chan<- error, tick <-chan time.Time) error {
+	cmd := exec.Command("drbdsetup", "events2", "--timestamps", "--statistics", "--poll")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("unable to follow drbdsetup events2: %v", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("unable to follow drbdsetup events2: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("unable to follow drbdsetup events2: %v", err)
+	}
+
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+
+	// Kill the child and wait for the reader to notice, the reader
+	// blocks on lines otherwise.
+	stop := func() error {
+		stdin.Close()
+		cmd.Process.Kill()
+		for range lines {
+		}
+		return cmd.Wait()
+	}
+
+	state := newStreamState()
+	if configured, err := allResources(); err != nil {
+		errors <- err
+	} else {
+		state.configured = configured
+	}
+
+	displayEvent := resource.NewDisplayEvent()
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				if err := cmd.Wait(); err != nil {
+					return fmt.Errorf("drbdsetup events2 exited: %v", err)
+				}
+				return fmt.Errorf("drbdsetup events2 exited unexpectedly")
+			}
+			if line == "" {
+				continue
+			}
+			if err := c.Recorder.Line(line); err != nil {
+				errors <- err
+			}
+			evt, err := resource.NewEvent(line)
+			if err != nil {
+				errors <- err
+				continue
+			}
+			if state.resourcesChanged(evt) {
+				if configured, err := allResources(); err != nil {
+					errors <- err
+				} else {
+					state.configured = configured
+				}
+			}
+			events <- evt
+			for _, e := range state.apply(evt) {
+				c.record(e, errors)
+				events <- e
+			}
+		case <-tick:
+			if _, err := io.WriteString(stdin, "n\n"); err != nil {
+				stop()
+				return fmt.Errorf("unable to request statistics from drbdsetup events2: %v", err)
+			}
+			for _, e := range state.unconfigured() {
+				c.record(e, errors)
+				events <- e
+			}
+			if err := c.Recorder.Cycle(time.Now()); err != nil {
+				errors <- err
+			}
+			events <- displayEvent
+		}
+	}
+}
+
+// record records an Event generated by the collector.
+func (c Events2Stream) record(e resource.Event, errors chan<- error) {
+	var err error
+	if e.Target == resource.PruneEvent {
+		err = c.Recorder.Prune(e.TimeStamp)
+	} else {
+		err = c.Recorder.Event(e)
+	}
+	if err != nil {
+		errors <- err
+	}
+}
+
+// streamState keeps track of which configured resources the event stream
+// currently knows about.
+type streamState struct {
+	// Resources present in the DRBD configuration.
+	configured map[string]bool
+	// Resources the kernel reported as existing.
+	active map[string]bool
+	// Set once the initial "exists" dump has been read completely.
+	dumped bool
+	// Time of the first event in the initial dump.
+	dumpStart time.Time
+}
+
+func newStreamState() *streamState {
+	return &streamState{
+		configured: make(map[string]bool),
+		active:     make(map[string]bool),
+	}
+}
+
+// resourcesChanged reports whether evt creates or destroys a resource, in
+// which case the list of configured resources is worth reloading.
+func (s *streamState) resourcesChanged(evt resource.Event) bool {
+	return evt.Target == "resource" && (evt.EventType == "create" || evt.EventType == "destroy")
+}
+
+// apply records evt and returns any Events that have to follow it.
+func (s *streamState) apply(evt resource.Event) []resource.Event {
+	if evt.EventType == "exists" && evt.Target == "-" {
+		if s.dumped {
+			return nil
+		}
+		// The initial dump is complete, anything older than it is left over
+		// from a previous drbdsetup process and has to go.
+		s.dumped = true
+		ret := s.unconfigured()
+		if !s.dumpStart.IsZero() {
+			pruneEvent := resource.NewPruneEvent()
+			pruneEvent.TimeStamp = s.dumpStart
+			ret = append(ret, pruneEvent)
+		}
+		return ret
+	}
+
+	if !s.dumped && s.dumpStart.IsZero() {
+		s.dumpStart = evt.TimeStamp
+	}
+
+	if evt.Target == "resource" {
+		name := evt.Fields[resource.ResKeys.Name]
+		if evt.EventType == "destroy" {
+			delete(s.active, name)
+		} else {
+			s.active[name] = true
+		}
+	}
+	return nil
+}
+
+// unconfigured returns an Event for every configured resource that the
+// kernel does not know about.
+func (s *streamState) unconfigured() []resource.Event {
+	if !s.dumped {
+		return nil
+	}
+
+	var names []string
+	for res := range s.configured {
+		if !s.active[res] {
+			names = append(names, res)
+		}
+	}
+	sort.Strings(names)
+
+	var ret []resource.Event
+	for _, res := range names {
+		ret = append(ret, resource.NewUnconfiguredRes(res))
+	}
+	return ret
+}
+
 func allResources() (map[string]bool, error) {
 	cmd, err := godrbdutils.NewDrbdCmd(godrbdutils.Drbdadm, godrbdutils.Connect, []string{"all"}, "-d")
 	if err != nil {
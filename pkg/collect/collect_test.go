@@ -21,6 +21,8 @@ package collect
 import (
 	"reflect"
 	"testing"
+
+	"github.com/LINBIT/drbdtop/pkg/resource"
 )
 
 func TestDoAllResources(t *testing.T) {
@@ -47,3 +49,54 @@ func TestDoAllResources(t *testing.T) {
 	}
 
 }
+
+func TestStreamState(t *testing.T) {
+	s := newStreamState()
+	s.configured = map[string]bool{"r0": true, "r1": true}
+
+	for _, l := range []string{
+		"2017-02-15T14:43:16.688437+00:00 exists resource name:r0 role:Primary suspended:no write-ordering:flush",
+		"2017-02-15T14:43:16.688437+00:00 exists connection name:r0 conn-name:peer connection:Connected role:Secondary congested:no",
+	} {
+		evt, err := resource.NewEvent(l)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out := s.apply(evt); len(out) != 0 {
+			t.Errorf("Expected no additional events during the initial dump, got %v", out)
+		}
+	}
+
+	// Nothing is reported as unconfigured until the initial dump is complete.
+	if out := s.unconfigured(); len(out) != 0 {
+		t.Errorf("Expected no unconfigured resources before the dump is complete, got %v", out)
+	}
+
+	evt, err := resource.NewEvent("2017-02-15T14:43:16.688437+00:00 exists -")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := s.apply(evt)
+	if len(out) != 2 {
+		t.Fatalf("Expected an unconfigured and a prune event, got %v", out)
+	}
+	if out[0].Fields[resource.ResKeys.Name] != "r1" || out[0].Fields[resource.ResKeys.Unconfigured] != "true" {
+		t.Errorf("Expected r1 to be reported as unconfigured, got %v", out[0])
+	}
+	if out[1].Target != resource.PruneEvent || !out[1].TimeStamp.Equal(s.dumpStart) {
+		t.Errorf("Expected a prune event at %v, got %v", s.dumpStart, out[1])
+	}
+
+	evt, err = resource.NewEvent("2017-02-15T14:44:16.688437+00:00 destroy resource name:r0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.resourcesChanged(evt) {
+		t.Error("Expected destroying a resource to change the resources")
+	}
+	s.apply(evt)
+
+	if out := s.unconfigured(); len(out) != 2 {
+		t.Errorf("Expected r0 and r1 to be unconfigured, got %v", out)
+	}
+}
@@ -0,0 +1,117 @@
+/*
+ *drbdtop - statistics for DRBD
+ *Copyright © 2017 Hayley Swimelar and Roland Kammerer
+ *
+ *This program is free software; you can redistribute it and/or modify
+ *it under the terms of the GNU General Public License as published by
+ *the Free Software Foundation; either version 2 of the License, or
+ *(at your option) any later version.
+ *
+ *This program is distributed in the hope that it will be useful,
+ *but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ *GNU General Public License for more details.
+ *
+ *You should have received a copy of the GNU General Public License
+ *along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package collect
+
+import (
+	"bufio"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/LINBIT/drbdtop/pkg/resource"
+)
+
+// Kernel messages of DRBD 9 about a connection or one of its peer devices,
+// e.g. "drbd r0 peer: ..." or "drbd r0/0 drbd1 peer: ...".
+var kernelLogConn = regexp.MustCompile(`drbd ([^\s/]+)(?:/\d+ drbd\d+)? ([^\s:]+): (.*)$`)
+
+// The time stamp dmesg prefixes messages with, in seconds since boot.
+var kernelLogStamp = regexp.MustCompile(`^\[\s*(\d+\.\d+)\]`)
+
+// KernelLog follows the kernel log and sends an Event for every split brain
+// DRBD reports there, drbdsetup events2 only reports the ones the
+// split-brain handler is called for. It does not send any display updates,
+// so it is meant to run alongside another Collector.
+type KernelLog struct{}
+
+func (c KernelLog) Collect(events chan<- resource.Event, errors chan<- error) {
+	// dmesg --follow starts with the whole ring buffer. Split brains in
+	// there may have been resolved long ago, so skip up to its current end.
+	out, err := exec.Command("dmesg").Output()
+	if err != nil {
+		errors <- fmt.Errorf("unable to read the kernel log: %v", err)
+		return
+	}
+	end, skip := kernelLogEnd(out)
+
+	cmd := exec.Command("dmesg", "--follow")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		errors <- fmt.Errorf("unable to follow the kernel log: %v", err)
+		return
+	}
+	if err := cmd.Start(); err != nil {
+		errors <- fmt.Errorf("unable to follow the kernel log: %v", err)
+		return
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if skip {
+			if t, ok := kernelLogTime(line); ok && t <= end {
+				continue
+			}
+			skip = false
+		}
+		if evt, ok := kernelLogSplitBrain(line); ok {
+			events <- evt
+		}
+	}
+	if err := cmd.Wait(); err != nil {
+		errors <- fmt.Errorf("stopped following the kernel log: %v", err)
+	}
+}
+
+// kernelLogEnd returns the time stamp of the last message in the output of
+// dmesg, if there is one.
+func kernelLogEnd(out []byte) (float64, bool) {
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	return kernelLogTime(lines[len(lines)-1])
+}
+
+// kernelLogTime returns the time stamp of a message printed by dmesg.
+func kernelLogTime(line string) (float64, bool) {
+	m := kernelLogStamp.FindStringSubmatch(line)
+	if m == nil {
+		return 0, false
+	}
+	t, err := strconv.ParseFloat(m[1], 64)
+	return t, err == nil
+}
+
+// kernelLogSplitBrain returns a split-brain Event if line reports a split brain.
+func kernelLogSplitBrain(line string) (resource.Event, bool) {
+	m := kernelLogConn.FindStringSubmatch(line)
+	if m == nil {
+		return resource.Event{}, false
+	}
+
+	msg := m[3]
+	switch {
+	case strings.Contains(msg, "Split-Brain detected"):
+		return resource.NewSplitBrainEvent(m[1], m[2], resource.SplitBrainKernel), true
+	case strings.HasPrefix(msg, "uuid_compare()=") && strings.HasSuffix(msg, "by rule 100"):
+		// Rule 100 is where the UUID comparison finds that the data diverged.
+		return resource.NewSplitBrainEvent(m[1], m[2], resource.SplitBrainUUIDs), true
+	}
+	return resource.Event{}, false
+}
@@ -0,0 +1,86 @@
+/*
+ *drbdtop - statistics for DRBD
+ *Copyright © 2017 Hayley Swimelar and Roland Kammerer
+ *
+ *This program is free software; you can redistribute it and/or modify
+ *it under the terms of the GNU General Public License as published by
+ *the Free Software Foundation; either version 2 of the License, or
+ *(at your option) any later version.
+ *
+ *This program is distributed in the hope that it will be useful,
+ *but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ *GNU General Public License for more details.
+ *
+ *You should have received a copy of the GNU General Public License
+ *along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package collect
+
+import (
+	"testing"
+
+	"github.com/LINBIT/drbdtop/pkg/resource"
+)
+
+func TestKernelLogSplitBrain(t *testing.T) {
+	var tests = []struct {
+		in     string
+		ok     bool
+		res    string
+		conn   string
+		reason string
+	}{
+		{"[ 4242.123456] drbd r0/0 drbd1 beta: Split-Brain detected but unresolved, dropping connection!",
+			true, "r0", "beta", resource.SplitBrainKernel},
+		{"[ 4242.123456] drbd r0/0 drbd1 beta: uuid_compare()=split-brain by rule 100",
+			true, "r0", "beta", resource.SplitBrainUUIDs},
+		{"[ 4242.123456] drbd r0 beta: Split-Brain detected, 1 primaries, automatically solved. Sync from this node",
+			true, "r0", "beta", resource.SplitBrainKernel},
+		{"[ 4242.123456] drbd r0/0 drbd1 beta: uuid_compare()=no-sync by rule 38", false, "", "", ""},
+		{"[ 4242.123456] drbd r0 beta: conn( Connecting -> Connected ) peer( Unknown -> Secondary )", false, "", "", ""},
+		{"[ 4242.123456] e1000: eth0 NIC Link is Up", false, "", "", ""},
+	}
+
+	for _, tt := range tests {
+		evt, ok := kernelLogSplitBrain(tt.in)
+		if ok != tt.ok {
+			t.Errorf("kernelLogSplitBrain(%q): expected %t, got %t", tt.in, tt.ok, ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if res := evt.Fields[resource.SplitBrainKeys.Name]; res != tt.res {
+			t.Errorf("kernelLogSplitBrain(%q): expected resource %q, got %q", tt.in, tt.res, res)
+		}
+		if conn := evt.Fields[resource.SplitBrainKeys.ConnName]; conn != tt.conn {
+			t.Errorf("kernelLogSplitBrain(%q): expected connection %q, got %q", tt.in, tt.conn, conn)
+		}
+		if reason := evt.Fields[resource.SplitBrainKeys.Reason]; reason != tt.reason {
+			t.Errorf("kernelLogSplitBrain(%q): expected reason %q, got %q", tt.in, tt.reason, reason)
+		}
+	}
+}
+
+func TestKernelLogEnd(t *testing.T) {
+	var tests = []struct {
+		in  string
+		end float64
+		ok  bool
+	}{
+		{"[    0.000000] Linux version 5.10.0\n[ 4242.123456] drbd r0 beta: Split-Brain detected but unresolved, dropping connection!\n",
+			4242.123456, true},
+		{"[12345.5] e1000: eth0 NIC Link is Up", 12345.5, true},
+		{"", 0, false},
+		{"Jan 01 00:00:00 no time stamps", 0, false},
+	}
+
+	for _, tt := range tests {
+		end, ok := kernelLogEnd([]byte(tt.in))
+		if ok != tt.ok || end != tt.end {
+			t.Errorf("kernelLogEnd(%q): expected %v/%t, got %v/%t", tt.in, tt.end, tt.ok, end, ok)
+		}
+	}
+}
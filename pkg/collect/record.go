@@ -0,0 +1,138 @@
+/*
+ *drbdtop - statistics for DRBD
+ *Copyright © 2017 Hayley Swimelar and Roland Kammerer
+ *
+ *This program is free software; you can redistribute it and/or modify
+ *it under the terms of the GNU General Public License as published by
+ *the Free Software Foundation; either version 2 of the License, or
+ *(at your option) any later version.
+ *
+ *This program is distributed in the hope that it will be useful,
+ *but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ *GNU General Public License for more details.
+ *
+ *You should have received a copy of the GNU General Public License
+ *along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package collect
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/LINBIT/drbdtop/pkg/resource"
+)
+
+const (
+	// recordHeader is the first line of every recording.
+	recordHeader = "# drbdtop recording"
+	// cycleMarker starts a line that closes a collection cycle, it is
+	// followed by the time the cycle was displayed.
+	cycleMarker = "# drbdtop cycle "
+	// pruneMarker starts a line that asks to prune everything older than
+	// the time following it.
+	pruneMarker = "# drbdtop prune "
+	// resetMarker asks to forget everything collected so far.
+	resetMarker = "# drbdtop reset"
+)
+
+// Recorder appends collected events2 lines to a file, together with a marker
+// at the end of every collection cycle, so that a session can be replayed
+// later with its original timing.
+type Recorder struct {
+	sync.Mutex
+	w io.Writer
+}
+
+// NewRecorder returns a Recorder writing to w.
+func NewRecorder(w io.Writer) (*Recorder, error) {
+	r := &Recorder{w: w}
+	if err := r.write(recordHeader); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Line records a raw line of drbdsetup events2 output.
+func (r *Recorder) Line(s string) error {
+	if r == nil {
+		return nil
+	}
+	return r.write(s)
+}
+
+// Event records an Event that was generated by drbdtop itself.
+func (r *Recorder) Event(e resource.Event) error {
+	if r == nil {
+		return nil
+	}
+	return r.write(e.String())
+}
+
+// Cycle marks the end of a collection cycle that was displayed at t.
+func (r *Recorder) Cycle(t time.Time) error {
+	if r == nil {
+		return nil
+	}
+	return r.write(cycleMarker + t.Format(time.RFC3339Nano))
+}
+
+// Prune marks that everything not updated since t is gone.
+func (r *Recorder) Prune(t time.Time) error {
+	if r == nil {
+		return nil
+	}
+	return r.write(pruneMarker + t.Format(time.RFC3339Nano))
+}
+
+// Reset marks that everything collected so far is to be forgotten.
+func (r *Recorder) Reset() error {
+	if r == nil {
+		return nil
+	}
+	return r.write(resetMarker)
+}
+
+func (r *Recorder) write(s string) error {
+	r.Lock()
+	defer r.Unlock()
+
+	if _, err := io.WriteString(r.w, s+"\n"); err != nil {
+		return fmt.Errorf("unable to record events: %v", err)
+	}
+	return nil
+}
+
+// isCycleMarker reports whether line closes a collection cycle and returns
+// the time it was recorded at.
+func isCycleMarker(line string) (time.Time, bool) {
+	return isMarker(line, cycleMarker)
+}
+
+// isPruneMarker reports whether line asks to prune and returns the time
+// to prune at.
+func isPruneMarker(line string) (time.Time, bool) {
+	return isMarker(line, pruneMarker)
+}
+
+func isMarker(line, marker string) (time.Time, bool) {
+	if !strings.HasPrefix(line, marker) {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339Nano, strings.TrimPrefix(line, marker))
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// isComment reports whether line is not an event, but e.g. a header or
+// marker of a recording.
+func isComment(line string) bool {
+	return strings.HasPrefix(line, "#")
+}
@@ -0,0 +1,72 @@
+/*
+ *drbdtop - statistics for DRBD
+ *Copyright © 2017 Hayley Swimelar and Roland Kammerer
+ *
+ *This program is free software; you can redistribute it and/or modify
+ *it under the terms of the GNU General Public License as published by
+ *the Free Software Foundation; either version 2 of the License, or
+ *(at your option) any later version.
+ *
+ *This program is distributed in the hope that it will be useful,
+ *but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ *GNU General Public License for more details.
+ *
+ *You should have received a copy of the GNU General Public License
+ *along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package collect
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/LINBIT/drbdtop/pkg/resource"
+)
+
+func TestRecorder(t *testing.T) {
+	var buf bytes.Buffer
+	r, err := NewRecorder(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	line := "2017-02-15T14:43:16.688437+00:00 exists resource name:r0 role:Primary suspended:no write-ordering:flush"
+	if err := r.Line(line); err != nil {
+		t.Fatal(err)
+	}
+	evt := resource.NewUnconfiguredRes("r1")
+	if err := r.Event(evt); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Cycle(time.Date(2017, 2, 15, 14, 43, 17, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Prune(time.Date(2017, 2, 15, 14, 43, 16, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Reset(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := recordHeader + "\n" +
+		line + "\n" +
+		evt.String() + "\n" +
+		"# drbdtop cycle 2017-02-15T14:43:17Z\n" +
+		"# drbdtop prune 2017-02-15T14:43:16Z\n" +
+		"# drbdtop reset\n"
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, buf.String())
+	}
+
+	// A nil Recorder records nothing.
+	var none *Recorder
+	if err := none.Line(line); err != nil {
+		t.Errorf("Expected a nil Recorder to ignore lines, got %v", err)
+	}
+	if err := none.Cycle(time.Now()); err != nil {
+		t.Errorf("Expected a nil Recorder to ignore cycles, got %v", err)
+	}
+}
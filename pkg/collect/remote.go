@@ -0,0 +1,76 @@
+/*
+ *drbdtop - statistics for DRBD
+ *Copyright © 2017 Hayley Swimelar and Roland Kammerer
+ *
+ *This program is free software; you can redistribute it and/or modify
+ *it under the terms of the GNU General Public License as published by
+ *the Free Software Foundation; either version 2 of the License, or
+ *(at your option) any later version.
+ *
+ *This program is distributed in the hope that it will be useful,
+ *but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ *GNU General Public License for more details.
+ *
+ *You should have received a copy of the GNU General Public License
+ *along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package collect
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/LINBIT/drbdtop/pkg/resource"
+)
+
+// SplitAddress splits an address given as "unix:/path/to/socket",
+// "tcp:host:port" or just "host:port" into the network and the address
+// as expected by net.Dial and net.Listen.
+func SplitAddress(s string) (network, address string) {
+	for _, n := range []string{"unix", "tcp"} {
+		if strings.HasPrefix(s, n+":") {
+			return n, strings.TrimPrefix(s, n+":")
+		}
+	}
+	return "tcp", s
+}
+
+// Remote gathers events from a "drbdtop serve" instance. The connection is
+// reestablished if it breaks.
+type Remote struct {
+	// Address of the server, see SplitAddress.
+	Address string
+	// Interval to wait before reconnecting.
+	Interval time.Duration
+}
+
+func (c Remote) Collect(events chan<- resource.Event, errors chan<- error) {
+	for {
+		if err := c.follow(events, errors); err != nil {
+			errors <- err
+		}
+		events <- resource.NewDisplayEvent()
+		time.Sleep(c.Interval)
+	}
+}
+
+func (c Remote) follow(events chan<- resource.Event, errors chan<- error) error {
+	network, address := SplitAddress(c.Address)
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return fmt.Errorf("unable to connect to %s: %v", c.Address, err)
+	}
+	defer conn.Close()
+
+	// The server starts with its complete state, drop whatever is left from
+	// a previous connection.
+	events <- resource.NewResetEvent()
+	if err := scanEvents(conn, events, errors); err != nil {
+		return fmt.Errorf("lost connection to %s: %v", c.Address, err)
+	}
+	return fmt.Errorf("lost connection to %s", c.Address)
+}
@@ -0,0 +1,91 @@
+/*
+ *drbdtop - statistics for DRBD
+ *Copyright © 2017 Hayley Swimelar and Roland Kammerer
+ *
+ *This program is free software; you can redistribute it and/or modify
+ *it under the terms of the GNU General Public License as published by
+ *the Free Software Foundation; either version 2 of the License, or
+ *(at your option) any later version.
+ *
+ *This program is distributed in the hope that it will be useful,
+ *but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ *GNU General Public License for more details.
+ *
+ *You should have received a copy of the GNU General Public License
+ *along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package collect
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/LINBIT/drbdtop/pkg/resource"
+)
+
+func TestSplitAddress(t *testing.T) {
+	var tests = []struct {
+		in, network, address string
+	}{
+		{"unix:/run/drbdtop.sock", "unix", "/run/drbdtop.sock"},
+		{"tcp:localhost:4242", "tcp", "localhost:4242"},
+		{"localhost:4242", "tcp", "localhost:4242"},
+		{":4242", "tcp", ":4242"},
+	}
+
+	for _, tt := range tests {
+		network, address := SplitAddress(tt.in)
+		if network != tt.network || address != tt.address {
+			t.Errorf("SplitAddress(%q): Expected %s %s, got %s %s", tt.in, tt.network, tt.address, network, address)
+		}
+	}
+}
+
+func TestRemote(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	line := "2017-02-15T14:43:16.688437+00:00 exists resource name:r0 role:Primary suspended:no write-ordering:flush"
+	pruneTime := time.Date(2017, 2, 15, 14, 43, 16, 0, time.UTC)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r, err := NewRecorder(conn)
+		if err != nil {
+			return
+		}
+		r.Line(line)
+		r.Cycle(time.Now())
+		r.Prune(pruneTime)
+		r.Reset()
+	}()
+
+	events := make(chan resource.Event, 10)
+	errors := make(chan error, 10)
+	go Remote{Address: "tcp:" + l.Addr().String(), Interval: time.Hour}.Collect(events, errors)
+
+	expected := []string{resource.ResetEvent, "resource", resource.DisplayEvent,
+		resource.PruneEvent, resource.ResetEvent, resource.DisplayEvent}
+	for _, target := range expected {
+		evt := <-events
+		if evt.Target != target {
+			t.Fatalf("Expected a %s event, got %q", target, evt.Target)
+		}
+		if evt.Target == resource.PruneEvent && !evt.TimeStamp.Equal(pruneTime) {
+			t.Errorf("Expected to prune at %s, got %s", pruneTime, evt.TimeStamp)
+		}
+	}
+
+	if err := <-errors; err == nil {
+		t.Error("Expected an error when the server hangs up")
+	}
+}
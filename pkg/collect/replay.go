@@ -0,0 +1,356 @@
+/*
+ *drbdtop - statistics for DRBD
+ *Copyright © 2017 Hayley Swimelar and Roland Kammerer
+ *
+ *This program is free software; you can redistribute it and/or modify
+ *it under the terms of the GNU General Public License as published by
+ *the Free Software Foundation; either version 2 of the License, or
+ *(at your option) any later version.
+ *
+ *This program is distributed in the hope that it will be useful,
+ *but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ *GNU General Public License for more details.
+ *
+ *You should have received a copy of the GNU General Public License
+ *along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package collect
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/LINBIT/drbdtop/pkg/resource"
+)
+
+// ReplaySpeeds are the speeds Faster and Slower switch between.
+var ReplaySpeeds = []float64{1, 2, 5, 10, 100}
+
+// cycle is a group of events that was displayed at once.
+type cycle struct {
+	time   time.Time
+	events []resource.Event
+}
+
+// readCycles splits a recording into its cycles. Files that were not written
+// by a Recorder have no cycle markers, every event is a cycle of its own then.
+// Recorded prunes and resets are part of the cycle they happened in.
+func readCycles(r io.Reader) ([]cycle, []error) {
+	var cycles []cycle
+	var errs []error
+	var pending []resource.Event
+	marked := false
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if isComment(line) {
+			if t, ok := isCycleMarker(line); ok {
+				marked = true
+				cycles = append(cycles, cycle{time: t, events: pending})
+				pending = nil
+			} else if t, ok := isPruneMarker(line); ok {
+				pruneEvent := resource.NewPruneEvent()
+				pruneEvent.TimeStamp = t
+				pending = append(pending, pruneEvent)
+			} else if line == resetMarker {
+				pending = append(pending, resource.NewResetEvent())
+			} else if line == recordHeader {
+				marked = true
+			}
+			continue
+		}
+		if line == "" {
+			continue
+		}
+		evt, err := resource.NewEvent(line)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if marked {
+			pending = append(pending, evt)
+		} else {
+			cycles = append(cycles, cycle{time: evt.TimeStamp, events: []resource.Event{evt}})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, fmt.Errorf("unable to read recording: %v", err))
+	}
+
+	// The session ended in the middle of a cycle.
+	if len(pending) > 0 {
+		t := pending[len(pending)-1].TimeStamp
+		if len(cycles) > 0 && t.Before(cycles[len(cycles)-1].time) {
+			t = cycles[len(cycles)-1].time
+		}
+		cycles = append(cycles, cycle{time: t, events: pending})
+	}
+
+	return cycles, errs
+}
+
+// Replay plays back a recorded session honoring the time between its cycles.
+// It can be paused, stepped through, sped up and slowed down, and seeked
+// while it is running.
+type Replay struct {
+	sync.Mutex
+	path   string
+	cycles []cycle
+	// Number of cycles that have been sent so far.
+	pos    int
+	speed  float64
+	paused bool
+	step   bool
+	// Number of cycles the next seek should end up at, -1 if there is none.
+	seek int
+	// Wall clock time the last cycle was sent at.
+	last time.Time
+	done bool
+	// Signals a changed state to Collect.
+	wake chan struct{}
+}
+
+// NewReplay returns a Replay of the recording at path, starting at speed.
+func NewReplay(path string, speed float64) *Replay {
+	if speed <= 0 {
+		speed = 1
+	}
+	return &Replay{
+		path:  path,
+		speed: speed,
+		seek:  -1,
+		wake:  make(chan struct{}, 1),
+	}
+}
+
+func (r *Replay) Collect(events chan<- resource.Event, errors chan<- error) {
+	f, err := os.Open(r.path)
+	if err != nil {
+		errors <- fmt.Errorf("unable to replay %s: %v", r.path, err)
+		events <- resource.NewEOF()
+		return
+	}
+	cycles, errs := readCycles(f)
+	f.Close()
+	for _, err := range errs {
+		errors <- err
+	}
+
+	r.Lock()
+	r.cycles = cycles
+	r.Unlock()
+
+	r.play(events)
+}
+
+// play sends the cycles to events until it is told to stop, which it never is.
+// At the end of the recording it waits for a seek backwards.
+func (r *Replay) play(events chan<- resource.Event) {
+	displayEvent := resource.NewDisplayEvent()
+
+	for {
+		r.Lock()
+
+		if r.seek >= 0 {
+			from, to := r.pos, r.seek
+			reset := to < from
+			if reset {
+				from = 0
+			}
+			r.pos, r.seek = to, -1
+			r.done = false
+			r.last = time.Now()
+			batch := r.cycles[from:to]
+			r.Unlock()
+
+			if reset {
+				events <- resource.NewResetEvent()
+			}
+			for _, c := range batch {
+				for _, e := range c.events {
+					events <- e
+				}
+			}
+			events <- displayEvent
+			continue
+		}
+
+		if r.pos >= len(r.cycles) {
+			done := r.done
+			r.done = true
+			r.Unlock()
+			if !done {
+				events <- resource.NewEOF()
+			}
+			<-r.wake
+			continue
+		}
+
+		if r.paused && !r.step {
+			r.Unlock()
+			<-r.wake
+			continue
+		}
+
+		if !r.step {
+			if wait := time.Until(r.due()); wait > 0 {
+				r.Unlock()
+				timer := time.NewTimer(wait)
+				select {
+				case <-timer.C:
+				case <-r.wake:
+					timer.Stop()
+				}
+				continue
+			}
+		}
+
+		c := r.cycles[r.pos]
+		r.pos++
+		r.step = false
+		r.last = time.Now()
+		r.Unlock()
+
+		for _, e := range c.events {
+			events <- e
+		}
+		events <- displayEvent
+	}
+}
+
+// due returns the wall clock time the next cycle has to be sent at.
+func (r *Replay) due() time.Time {
+	if r.pos == 0 {
+		return r.last
+	}
+	gap := r.cycles[r.pos].time.Sub(r.cycles[r.pos-1].time)
+	return r.last.Add(time.Duration(float64(gap) / r.speed))
+}
+
+// notify wakes up play, r has to be locked.
+func (r *Replay) notify() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// TogglePause pauses or resumes the replay.
+func (r *Replay) TogglePause() {
+	r.Lock()
+	defer r.Unlock()
+
+	r.paused = !r.paused
+	if !r.paused {
+		// Resuming continues with the gap to the next cycle.
+		r.last = time.Now()
+	}
+	r.notify()
+}
+
+// Step sends the next cycle immediately and pauses the replay.
+func (r *Replay) Step() {
+	r.Lock()
+	defer r.Unlock()
+
+	r.paused = true
+	r.step = true
+	r.notify()
+}
+
+// Faster switches to the next higher speed.
+func (r *Replay) Faster() {
+	r.Lock()
+	defer r.Unlock()
+
+	for _, s := range ReplaySpeeds {
+		if s > r.speed {
+			r.speed = s
+			break
+		}
+	}
+	r.notify()
+}
+
+// Slower switches to the next lower speed.
+func (r *Replay) Slower() {
+	r.Lock()
+	defer r.Unlock()
+
+	for i := len(ReplaySpeeds) - 1; i >= 0; i-- {
+		if ReplaySpeeds[i] < r.speed {
+			r.speed = ReplaySpeeds[i]
+			break
+		}
+	}
+	r.notify()
+}
+
+// Seek jumps d back or forth in recorded time. Jumping back restarts the
+// replay from the beginning, as events can not be undone.
+func (r *Replay) Seek(d time.Duration) {
+	r.Lock()
+	defer r.Unlock()
+
+	if len(r.cycles) == 0 {
+		return
+	}
+	pos := r.pos
+	if r.seek >= 0 {
+		pos = r.seek
+	}
+	r.seek = seekTarget(r.cycles, pos, d)
+	r.notify()
+}
+
+// seekTarget returns the number of cycles that have to be sent to be d away
+// from the time of the last sent cycle. At least the first cycle is sent.
+func seekTarget(cycles []cycle, pos int, d time.Duration) int {
+	cur := cycles[0].time
+	if pos > 0 {
+		cur = cycles[pos-1].time
+	}
+	want := cur.Add(d)
+	target := sort.Search(len(cycles), func(i int) bool {
+		return cycles[i].time.After(want)
+	})
+	if target < 1 {
+		target = 1
+	}
+	return target
+}
+
+// Status returns a single line describing the position and state of the replay.
+func (r *Replay) Status() string {
+	r.Lock()
+	defer r.Unlock()
+
+	if len(r.cycles) == 0 {
+		return "Replay: no data"
+	}
+
+	start := r.cycles[0].time
+	cur := start
+	if r.pos > 0 {
+		cur = r.cycles[r.pos-1].time
+	}
+	total := r.cycles[len(r.cycles)-1].time.Sub(start)
+
+	state := "playing"
+	if r.pos >= len(r.cycles) {
+		state = "finished"
+	} else if r.paused {
+		state = "paused"
+	}
+
+	return fmt.Sprintf("Replay: %s (%s/%s) %gx %s",
+		cur.Format("2006-01-02 15:04:05"), cur.Sub(start).Round(time.Second), total.Round(time.Second), r.speed, state)
+}
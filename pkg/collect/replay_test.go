@@ -0,0 +1,218 @@
+/*
+ *drbdtop - statistics for DRBD
+ *Copyright © 2017 Hayley Swimelar and Roland Kammerer
+ *
+ *This program is free software; you can redistribute it and/or modify
+ *it under the terms of the GNU General Public License as published by
+ *the Free Software Foundation; either version 2 of the License, or
+ *(at your option) any later version.
+ *
+ *This program is distributed in the hope that it will be useful,
+ *but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ *GNU General Public License for more details.
+ *
+ *You should have received a copy of the GNU General Public License
+ *along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package collect
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LINBIT/drbdtop/pkg/resource"
+	"github.com/LINBIT/drbdtop/pkg/update"
+)
+
+const testRecording = `# drbdtop recording
+2017-02-15T14:43:16.688437+00:00 exists resource name:r0 role:Primary suspended:no write-ordering:flush
+2017-02-15T14:43:16.688437+00:00 exists -
+# drbdtop cycle 2017-02-15T14:43:17Z
+2017-02-15T14:43:17.688437+00:00 change resource name:r0 role:Secondary
+# drbdtop cycle 2017-02-15T14:43:27Z
+# drbdtop cycle 2017-02-15T14:44:27Z
+2017-02-15T14:44:28.688437+00:00 change resource name:r0 role:Primary
+`
+
+func TestReadCycles(t *testing.T) {
+	cycles, errs := readCycles(strings.NewReader(testRecording))
+	if len(errs) != 0 {
+		t.Fatal(errs)
+	}
+
+	expected := []struct {
+		time   time.Time
+		events int
+	}{
+		{time.Date(2017, 2, 15, 14, 43, 17, 0, time.UTC), 2},
+		{time.Date(2017, 2, 15, 14, 43, 27, 0, time.UTC), 1},
+		{time.Date(2017, 2, 15, 14, 44, 27, 0, time.UTC), 0},
+		// The unfinished last cycle is timed by its last event.
+		{time.Date(2017, 2, 15, 14, 44, 28, 688437, time.Local), 1},
+	}
+	if len(cycles) != len(expected) {
+		t.Fatalf("Expected %d cycles, got %d", len(expected), len(cycles))
+	}
+	for i, e := range expected {
+		if !cycles[i].time.Equal(e.time) {
+			t.Errorf("Expected cycle %d at %s, got %s", i, e.time, cycles[i].time)
+		}
+		if len(cycles[i].events) != e.events {
+			t.Errorf("Expected cycle %d to have %d events, got %d", i, e.events, len(cycles[i].events))
+		}
+	}
+}
+
+func TestReadCyclesWithoutMarkers(t *testing.T) {
+	in := "2017-02-15T14:43:16.688437+00:00 exists resource name:r0 role:Primary suspended:no write-ordering:flush\n" +
+		"garbage\n" +
+		"2017-02-15T14:43:18.688437+00:00 change resource name:r0 role:Secondary\n"
+
+	cycles, errs := readCycles(strings.NewReader(in))
+	if len(errs) != 1 {
+		t.Errorf("Expected 1 error, got %v", errs)
+	}
+	if len(cycles) != 2 {
+		t.Fatalf("Expected every event to be a cycle of its own, got %d cycles", len(cycles))
+	}
+	if d := cycles[1].time.Sub(cycles[0].time); d != 2*time.Second {
+		t.Errorf("Expected the cycles to be 2s apart, got %s", d)
+	}
+}
+
+func TestSeekTarget(t *testing.T) {
+	cycles, _ := readCycles(strings.NewReader(testRecording))
+
+	var seekTests = []struct {
+		pos      int
+		d        time.Duration
+		expected int
+	}{
+		{1, 10 * time.Second, 2},
+		{1, 5 * time.Second, 1},
+		{1, time.Minute, 2},
+		{1, 72 * time.Second, 4},
+		{4, -time.Minute, 2},
+		{4, -time.Hour, 1},
+		{0, time.Hour, 4},
+	}
+
+	for _, tt := range seekTests {
+		if out := seekTarget(cycles, tt.pos, tt.d); out != tt.expected {
+			t.Errorf("Seeking %s from %d: expected %d, got %d", tt.d, tt.pos, tt.expected, out)
+		}
+	}
+}
+
+func TestReplaySeek(t *testing.T) {
+	cycles, _ := readCycles(strings.NewReader(testRecording))
+	r := NewReplay("", 100)
+	r.cycles = cycles
+	r.paused = true
+
+	events := make(chan resource.Event, 100)
+	go r.play(events)
+
+	// Read everything up to and including the next DisplayEvent.
+	next := func() []resource.Event {
+		var ret []resource.Event
+		for {
+			select {
+			case evt := <-events:
+				if evt.Target == resource.EOF {
+					continue
+				}
+				ret = append(ret, evt)
+				if evt.Target == resource.DisplayEvent {
+					return ret
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("Timed out waiting for the replay")
+			}
+		}
+	}
+
+	r.Step()
+	if out := next(); len(out) != 3 {
+		t.Errorf("Expected the first cycle, got %v", out)
+	}
+
+	r.Seek(time.Hour)
+	if out := next(); len(out) != 3 {
+		t.Errorf("Expected all remaining cycles at once, got %v", out)
+	}
+
+	r.Seek(-time.Hour)
+	out := next()
+	if len(out) != 4 || out[0].Target != resource.ResetEvent {
+		t.Errorf("Expected a reset followed by the first cycle, got %v", out)
+	}
+
+	if status := r.Status(); !strings.Contains(status, "100x paused") {
+		t.Errorf("Expected a paused replay at 100x, got %q", status)
+	}
+}
+
+func TestReplayPrune(t *testing.T) {
+	var buf bytes.Buffer
+	rec, err := NewRecorder(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Two polls, the connection is gone in the second one.
+	first := []string{
+		"2017-02-15T14:43:16.688437+00:00 exists resource name:r0 role:Primary suspended:no write-ordering:flush",
+		"2017-02-15T14:43:16.688437+00:00 exists connection name:r0 conn-name:peer connection:Connected role:Secondary congested:no",
+	}
+	second := []string{
+		"2017-02-15T14:43:18.688437+00:00 exists resource name:r0 role:Primary suspended:no write-ordering:flush",
+	}
+	for _, l := range first {
+		rec.Line(l)
+	}
+	rec.Cycle(time.Date(2017, 2, 15, 14, 43, 17, 0, time.UTC))
+	for _, l := range second {
+		rec.Line(l)
+	}
+	rec.Prune(time.Date(2017, 2, 15, 14, 43, 18, 0, time.UTC))
+	rec.Cycle(time.Date(2017, 2, 15, 14, 43, 19, 0, time.UTC))
+	rec.Reset()
+	rec.Cycle(time.Date(2017, 2, 15, 14, 43, 20, 0, time.UTC))
+
+	cycles, errs := readCycles(&buf)
+	if len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	if len(cycles) != 3 {
+		t.Fatalf("Expected 3 cycles, got %d", len(cycles))
+	}
+
+	rc := update.NewResourceCollection(time.Second)
+	apply := func(c cycle) {
+		for _, e := range c.events {
+			if e.Target == resource.PruneEvent {
+				rc.Prune(e)
+			} else {
+				rc.Update(e)
+			}
+		}
+	}
+
+	apply(cycles[0])
+	if _, ok := rc.Map["r0"].Connections["peer"]; !ok {
+		t.Fatal("Expected the connection after the first cycle")
+	}
+	apply(cycles[1])
+	if _, ok := rc.Map["r0"].Connections["peer"]; ok {
+		t.Error("Expected the connection to be pruned in the second cycle")
+	}
+	apply(cycles[2])
+	if len(rc.Map) != 0 {
+		t.Errorf("Expected no resources after the reset, got %d", len(rc.Map))
+	}
+}
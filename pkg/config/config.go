@@ -0,0 +1,58 @@
+/*
+ *drbdtop - statistics for DRBD
+ *Copyright © 2017 Hayley Swimelar and Roland Kammerer
+ *
+ *This program is free software; you can redistribute it and/or modify
+ *it under the terms of the GNU General Public License as published by
+ *the Free Software Foundation; either version 2 of the License, or
+ *(at your option) any later version.
+ *
+ *This program is distributed in the hope that it will be useful,
+ *but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ *GNU General Public License for more details.
+ *
+ *You should have received a copy of the GNU General Public License
+ *along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// DefaultPath is where the configuration is read from unless told otherwise.
+const DefaultPath = "/etc/drbdtop.json"
+
+// Config contains the defaults of command line options.
+type Config struct {
+	// Mode is the permission profile of the interactive TUI, see --mode.
+	Mode string `json:"mode"`
+	// SSHHosts are the addresses ssh connects to, by the node names of the
+	// DRBD configuration. Nodes without one are connected to by their name.
+	SSHHosts map[string]string `json:"ssh-hosts"`
+}
+
+// Load reads the configuration at path. A missing file at the DefaultPath is
+// an empty configuration.
+func Load(path string) (Config, error) {
+	var c Config
+	f, err := os.Open(path)
+	if os.IsNotExist(err) && path == DefaultPath {
+		return c, nil
+	} else if err != nil {
+		return c, err
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&c); err != nil {
+		return c, fmt.Errorf("Couldn't parse configuration %q: %v", path, err)
+	}
+
+	return c, nil
+}
@@ -0,0 +1,68 @@
+/*
+ *drbdtop - statistics for DRBD
+ *Copyright © 2017 Hayley Swimelar and Roland Kammerer
+ *
+ *This program is free software; you can redistribute it and/or modify
+ *it under the terms of the GNU General Public License as published by
+ *the Free Software Foundation; either version 2 of the License, or
+ *(at your option) any later version.
+ *
+ *This program is distributed in the hope that it will be useful,
+ *but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ *GNU General Public License for more details.
+ *
+ *You should have received a copy of the GNU General Public License
+ *along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "drbdtop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "drbdtop.json")
+	if _, err := Load(path); err == nil {
+		t.Error("Expected an error for a missing configuration")
+	}
+
+	if err := ioutil.WriteFile(path, []byte(`{"mode": "operator"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Mode != "operator" {
+		t.Errorf("Expected mode %q, got %q", "operator", c.Mode)
+	}
+
+	if err := ioutil.WriteFile(path, []byte(`{"ssh-hosts": {"beta": "10.0.0.2"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err = Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.SSHHosts["beta"] != "10.0.0.2" {
+		t.Errorf("Expected the ssh host of beta to be %q, got %q", "10.0.0.2", c.SSHHosts["beta"])
+	}
+
+	if err := ioutil.WriteFile(path, []byte(`{"mode": "operator", "expert": true}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Error("Expected an error for an unknown setting")
+	}
+}
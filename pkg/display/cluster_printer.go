@@ -0,0 +1,124 @@
+/*
+ *drbdtop - statistics for DRBD
+ *Copyright © 2017 Hayley Swimelar and Roland Kammerer
+ *
+ *This program is free software; you can redistribute it and/or modify
+ *it under the terms of the GNU General Public License as published by
+ *the Free Software Foundation; either version 2 of the License, or
+ *(at your option) any later version.
+ *
+ *This program is distributed in the hope that it will be useful,
+ *but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ *GNU General Public License for more details.
+ *
+ *You should have received a copy of the GNU General Public License
+ *along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package display
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/LINBIT/drbdtop/pkg/resource"
+	"github.com/LINBIT/drbdtop/pkg/update"
+)
+
+// ClusterPrinter prints the resources of all nodes of a cluster side by side.
+type ClusterPrinter struct {
+	cluster *update.Cluster
+	out     io.Writer
+	lastErr []error
+}
+
+// NewClusterPrinter returns a ClusterPrinter for nodes writing to stdout.
+func NewClusterPrinter(d time.Duration, nodes []string) ClusterPrinter {
+	return ClusterPrinter{
+		cluster: update.NewCluster(d, nodes),
+		out:     os.Stdout,
+	}
+}
+
+// Display clears the screen and prints the cluster on every display update.
+func (c *ClusterPrinter) Display(event <-chan resource.Event, err <-chan error) {
+	for {
+		select {
+		case evt := <-event:
+			switch evt.Target {
+			case resource.EOF:
+				return
+			case resource.DisplayEvent:
+				c.cluster.UpdateList()
+				clear := exec.Command("clear")
+				clear.Stdout = os.Stdout
+				clear.Run()
+				c.print()
+			case resource.PruneEvent:
+				c.cluster.Prune(evt)
+			default:
+				c.cluster.Update(evt)
+			}
+		case err := <-err:
+			if len(c.lastErr) >= 5 {
+				c.lastErr = append(c.lastErr[1:], err)
+			} else {
+				c.lastErr = append(c.lastErr, err)
+			}
+		}
+	}
+}
+
+func (c *ClusterPrinter) print() {
+	c.cluster.RLock()
+	defer c.cluster.RUnlock()
+
+	w := tabwriter.NewWriter(c.out, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "Name\t%s\t\n", strings.Join(c.cluster.Nodes, "\t"))
+	for _, cr := range c.cluster.List {
+		cells := []string{cr.Name}
+		for _, n := range c.cluster.Nodes {
+			cell := clusterCell(cr.Nodes[n])
+			if cr.Disagreeing(n) {
+				cell = "!" + cell
+			}
+			cells = append(cells, cell)
+		}
+		fmt.Fprintf(w, "%s\t\n", strings.Join(cells, "\t"))
+	}
+	w.Flush()
+
+	fmt.Fprintf(c.out, "\nDisagreements:\n")
+	for _, cr := range c.cluster.List {
+		for _, d := range cr.Disagreements {
+			fmt.Fprintf(c.out, "%s: %s\n", cr.Name, d.Message)
+		}
+	}
+	fmt.Fprintf(c.out, "\nErrors:\n")
+	for _, e := range c.lastErr {
+		fmt.Fprintf(c.out, "%v\n", e)
+	}
+}
+
+// clusterCell returns the role and the disk states of a resource on a node,
+// or "-" if the node doesn't know it.
+func clusterCell(v *update.NodeView) string {
+	if v == nil {
+		return "-"
+	}
+
+	var disks []string
+	for _, vol := range v.Volumes() {
+		disks = append(disks, v.Disks[vol])
+	}
+	if len(disks) == 0 {
+		return v.Role
+	}
+	return v.Role + " " + strings.Join(disks, "/")
+}
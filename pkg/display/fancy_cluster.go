@@ -0,0 +1,268 @@
+/*
+ *drbdtop - statistics for DRBD
+ *Copyright © 2017 Hayley Swimelar and Roland Kammerer
+ *
+ *This program is free software; you can redistribute it and/or modify
+ *it under the terms of the GNU General Public License as published by
+ *the Free Software Foundation; either version 2 of the License, or
+ *(at your option) any later version.
+ *
+ *This program is distributed in the hope that it will be useful,
+ *but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ *GNU General Public License for more details.
+ *
+ *You should have received a copy of the GNU General Public License
+ *along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package display
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/LINBIT/drbdtop/pkg/resource"
+	"github.com/LINBIT/drbdtop/pkg/update"
+	"github.com/LINBIT/termui"
+)
+
+var clusterHelp string = "q: QUIT | j/k: down/up | <home>/<end>: first/last"
+
+// Height of the window listing disagreements and errors.
+const problemsHeight = 8
+
+// ClusterTUI shows the resources of all nodes of a cluster side by side and
+// highlights where the nodes disagree.
+type ClusterTUI struct {
+	sync.Mutex
+	cluster        *update.Cluster
+	lastErr        []error
+	header, footer *termui.Par
+	tbl            *termui.Table
+	problems       *termui.Par
+	selidx         int
+}
+
+func NewClusterTUI(d time.Duration, nodes []string) *ClusterTUI {
+	e := termui.Init()
+	if e != nil {
+		panic(e)
+	}
+
+	c := &ClusterTUI{cluster: update.NewCluster(d, nodes)}
+
+	c.header = termui.NewPar(fmt.Sprintf("DRBDTOP - Cluster %s", strings.Join(nodes, ", ")))
+	c.header.Height = 1
+	c.header.TextFgColor = termui.ColorDefault
+	c.header.TextBgColor = termui.ColorDefault
+	c.header.Border = false
+
+	c.footer = termui.NewPar(clusterHelp)
+	c.footer.Height = 1
+	c.footer.TextFgColor = termui.ColorDefault
+	c.footer.TextBgColor = termui.ColorDefault
+	c.footer.Border = false
+
+	c.tbl = termui.NewTable()
+	c.tbl.Rows = [][]string{c.tblHeader()}
+	c.tbl.FgColor = termui.ColorDefault
+	c.tbl.BgColor = termui.ColorDefault
+	c.tbl.TextAlign = termui.AlignLeft
+	c.tbl.Separator = false
+	c.tbl.Border = true
+	c.tbl.BorderLabel = "Resources"
+	c.tbl.Analysis()
+	c.tbl.SetSize()
+	c.tbl.FgColors[0] |= termui.AttrBold
+
+	c.problems = termui.NewPar("")
+	c.problems.Height = problemsHeight
+	c.problems.BorderLabel = "Disagreements"
+	c.problems.TextFgColor = termui.ColorDefault
+
+	c.resize()
+	return c
+}
+
+// SetVersion shows the version in the header.
+func (c *ClusterTUI) SetVersion(v string) {
+	c.header.Text = fmt.Sprintf("DRBDTOP %s %s - Cluster %s", v, getVersionInfo(), strings.Join(c.cluster.Nodes, ", "))
+}
+
+func (c *ClusterTUI) tblHeader() []string {
+	return append([]string{"Name"}, c.cluster.Nodes...)
+}
+
+// rows returns how many resources fit into the table.
+func (c *ClusterTUI) rows() int {
+	return c.tbl.Height - 3
+}
+
+func (c *ClusterTUI) resize() {
+	c.tbl.Width = termui.TermWidth()
+	c.tbl.Height = termui.TermHeight() - c.header.Height - c.footer.Height - c.problems.Height
+}
+
+// Display shows the cluster until the user quits.
+func (c *ClusterTUI) Display(event <-chan resource.Event, err <-chan error) {
+	defer termui.Close()
+	c.initHandlers()
+	c.updateGUI()
+
+	go c.updateResources(event, err)
+
+	termui.Loop()
+}
+
+func (c *ClusterTUI) updateResources(event <-chan resource.Event, err <-chan error) {
+	for {
+		select {
+		case evt := <-event:
+			switch evt.Target {
+			case resource.EOF:
+			case resource.DisplayEvent:
+				c.cluster.UpdateList()
+				c.update()
+			case resource.PruneEvent:
+				c.cluster.Prune(evt)
+			default:
+				c.cluster.Update(evt)
+			}
+		case err := <-err:
+			c.Lock()
+			if len(c.lastErr) >= 5 {
+				c.lastErr = append(c.lastErr[1:], err)
+			} else {
+				c.lastErr = append(c.lastErr, err)
+			}
+			c.Unlock()
+		}
+	}
+}
+
+func (c *ClusterTUI) initHandlers() {
+	termui.Handle("/sys/kbd/q", func(termui.Event) {
+		termui.StopLoop()
+	})
+
+	move := func(to func(idx, n int) int) func(termui.Event) {
+		return func(termui.Event) {
+			c.Lock()
+			c.cluster.RLock()
+			if n := len(c.cluster.List); n > 0 {
+				c.selidx = to(c.selidx, n)
+			}
+			c.cluster.RUnlock()
+			c.Unlock()
+			c.update()
+		}
+	}
+	down := move(func(idx, n int) int { return (idx + 1) % n })
+	up := move(func(idx, n int) int { return (idx + n - 1) % n })
+	termui.Handle("/sys/kbd/j", down)
+	termui.Handle("/sys/kbd/<down>", down)
+	termui.Handle("/sys/kbd/k", up)
+	termui.Handle("/sys/kbd/<up>", up)
+	termui.Handle("/sys/kbd/<home>", move(func(idx, n int) int { return 0 }))
+	termui.Handle("/sys/kbd/<end>", move(func(idx, n int) int { return n - 1 }))
+
+	termui.Handle("/sys/wnd/resize", func(termui.Event) {
+		c.Lock()
+		c.resize()
+		c.Unlock()
+		c.updateGUI()
+	})
+}
+
+func (c *ClusterTUI) updateGUI() {
+	c.updateWidgets()
+
+	grid := termui.NewGrid()
+	grid.AddRows(
+		termui.NewRow(
+			termui.NewCol(12, 0, c.header)),
+		termui.NewRow(
+			termui.NewCol(12, 0, c.tbl)),
+		termui.NewRow(
+			termui.NewCol(12, 0, c.problems)),
+		termui.NewRow(
+			termui.NewCol(12, 0, c.footer)))
+
+	switchDisp(grid)
+}
+
+func (c *ClusterTUI) update() {
+	c.updateWidgets()
+	termui.Render(c.tbl, c.problems, c.footer)
+}
+
+func (c *ClusterTUI) updateWidgets() {
+	c.Lock()
+	defer c.Unlock()
+	c.cluster.RLock()
+	defer c.cluster.RUnlock()
+
+	if c.selidx >= len(c.cluster.List) {
+		c.selidx = 0
+	}
+	from, to := window(c.selidx, c.rows(), len(c.cluster.List))
+
+	rows := [][]string{c.tblHeader()}
+	var problems []string
+	for idx, cr := range c.cluster.List {
+		for _, d := range cr.Disagreements {
+			problems = append(problems, colRed(cr.Name+": "+d.Message, false))
+		}
+		if idx < from || idx >= to {
+			continue
+		}
+
+		name := cr.Name
+		if len(cr.Disagreements) > 0 {
+			name = colRed(name, true)
+		}
+		row := []string{name}
+		for _, n := range c.cluster.Nodes {
+			row = append(row, clusterTUICell(cr, n))
+		}
+		rows = append(rows, row)
+	}
+	for _, e := range c.lastErr {
+		problems = append(problems, e.Error())
+	}
+
+	c.tbl.SetRows(rows)
+	for i := 1; i < len(c.tbl.Rows); i++ { // skip header
+		c.tbl.BgColors[i] = termui.ColorDefault
+	}
+	if s := c.selidx - from + 1; s < len(c.tbl.Rows) {
+		c.tbl.BgColors[s] = termui.ColorBlue
+	}
+
+	c.problems.BorderLabel = fmt.Sprintf("Disagreements (%d)", len(problems)-len(c.lastErr))
+	if len(problems) == 0 {
+		c.problems.Text = colGreen("The nodes agree on all resources.", false)
+	} else {
+		c.problems.Text = strings.Join(problems, "\n")
+	}
+}
+
+// clusterTUICell colors a clusterCell: red if the node disagrees with other
+// nodes, the role green if the resource is Primary there.
+func clusterTUICell(cr *update.ClusterRes, node string) string {
+	v := cr.Nodes[node]
+	s := clusterCell(v)
+	if v == nil {
+		return colDefault(s, false)
+	}
+	if cr.Disagreeing(node) {
+		return colRed(s, true)
+	}
+	if v.Role == "Primary" {
+		return colGreen(v.Role, false) + strings.TrimPrefix(s, v.Role)
+	}
+	return s
+}
@@ -23,8 +23,10 @@ import (
 	"os"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/LINBIT/drbdtop/pkg/convert"
+	"github.com/LINBIT/drbdtop/pkg/drbdadm"
 	"github.com/LINBIT/drbdtop/pkg/resource"
 	"github.com/LINBIT/drbdtop/pkg/update"
 
@@ -38,8 +40,12 @@ const (
 	status
 	detailedstatus
 	dmesgw
+	graphs
 )
 
+// Height of a single sparkline in the graph view, without its title.
+const sparklineHeight = 3
+
 type uiGauge struct {
 	p *termui.Par
 	g *termui.Gauge
@@ -50,20 +56,27 @@ type detailView struct {
 	header, footer *termui.Par
 	oldselres      string
 	selres         string
-	volGauges      map[string]uiGauge // oos view
-	status         *termui.Par        // status & dmesg view
+	volGauges      map[string]uiGauge            // oos view
+	volGraphs      map[string]*termui.Sparklines // graph view
+	status         *termui.Par                   // status & dmesg view
 	window         win
+	// The objects of the resource commands can be run on, and the selected one.
+	targets []drbdadm.Target
+	target  int
 	// constantly updating status leads to flickering, especially for the dmesg output
 	scratch string // that is where you prepare you status
 	buf     string // the buffer that is set to scratch if scratch != buf
 }
 
+var detailHelp = "j/k: target | x: command | q: back | s: status | d: detailed status | m: dmesg | i: inSync | g: graphs | R: reset stats | H: history"
+
 const txtUnconfigured = "This resource is Unconfigured, no further information available."
 
 func NewDetailView() *detailView {
 	d := detailView{
 		grid:      nil,
 		volGauges: make(map[string]uiGauge),
+		volGraphs: make(map[string]*termui.Sparklines),
 	}
 
 	d.header = termui.NewPar("")
@@ -77,7 +90,7 @@ func NewDetailView() *detailView {
 	d.status.TextFgColor = termui.ColorDefault
 	d.status.TextBgColor = termui.ColorDefault
 
-	d.footer = termui.NewPar("q: back | s: status | d: detailed status | m: dmesg | i: inSync")
+	d.footer = termui.NewPar(detailHelp)
 	d.footer.Height = 1
 	d.footer.TextFgColor = termui.ColorDefault
 	d.footer.TextBgColor = termui.ColorDefault
@@ -94,6 +107,13 @@ func (d *detailView) printRes(r *update.ByRes) {
 		d.scratch += fmt.Sprintf("(Suspended)")
 	}
 
+	if d.window == detailedstatus && r.Res.MayPromote.Present {
+		d.scratch += fmt.Sprintf(" may promote:%s", yesNo(r.Res.MayPromote.Value))
+		if r.Res.PromotionScore.Present {
+			d.scratch += fmt.Sprintf(" promotion score:%d", r.Res.PromotionScore.Value)
+		}
+	}
+
 	d.scratch += fmt.Sprintf("\n")
 }
 
@@ -135,6 +155,12 @@ func (dv *detailView) printLocalDisk(r *update.ByRes) {
 				convert.KiB2Human(float64(v.Size)),
 				convert.KiB2Human(float64(v.ReadKiB.Total)), convert.KiB2Human(v.ReadKiB.PerSecond),
 				convert.KiB2Human(float64(v.WrittenKiB.Total)), convert.KiB2Human(v.WrittenKiB.PerSecond))
+			dv.scratch += fmt.Sprintf("\n    read/Sec 1m/5m/15m:%s written/Sec 1m/5m/15m:%s ",
+				rateAverages(v.ReadKiB.Avg1m, v.ReadKiB.Avg5m, v.ReadKiB.Avg15m),
+				rateAverages(v.WrittenKiB.Avg1m, v.WrittenKiB.Avg5m, v.WrittenKiB.Avg15m))
+			if v.Open.Present {
+				dv.scratch += fmt.Sprintf("open:%s ", yesNo(v.Open.Value))
+			}
 		}
 		dv.scratch += fmt.Sprintf("\n")
 	}
@@ -159,6 +185,30 @@ func (d *detailView) printConn(c *resource.Connection) {
 	}
 
 	d.scratch += fmt.Sprintf("\n")
+
+	if c.SplitBrain != nil && c.SplitBrain.Confirmed {
+		d.scratch += fmt.Sprintf("  %s since %s: %s\n", colRed("SPLIT BRAIN", true),
+			c.SplitBrain.Since.Format("2006-01-02 15:04:05"), c.SplitBrain)
+	}
+
+	if d.window == detailedstatus && (c.APInFlightKiB.Present || c.RSInFlightKiB.Present) {
+		d.scratch += fmt.Sprintf("  in flight: application:%s resync:%s\n",
+			optKiB2Human(c.APInFlightKiB), optKiB2Human(c.RSInFlightKiB))
+	}
+
+	var pathKeys []string
+	for k := range c.Paths {
+		pathKeys = append(pathKeys, k)
+	}
+	sort.Strings(pathKeys)
+	for _, k := range pathKeys {
+		p := c.Paths[k]
+		state := colDefault("not established", false)
+		if p.Established {
+			state = colGreen("established", false)
+		}
+		d.scratch += fmt.Sprintf("  path %s -> %s: %s\n", p.Local, p.Peer, state)
+	}
 }
 
 func (dv *detailView) printPeerDev(r *update.ByRes, conn string) {
@@ -188,6 +238,10 @@ func (dv *detailView) printPeerDev(r *update.ByRes, conn string) {
 				(float64(v.OutOfSyncKiB.Current)/float64(r.Device.Volumes[k].Size))*100)
 		}
 
+		if v.Resync.Active {
+			dv.scratch += fmt.Sprintf("\n   %s", resyncProgress(&v.Resync))
+		}
+
 		status := v.DiskState
 		if status == "UpToDate" {
 			status = colGreen(status, false)
@@ -200,29 +254,41 @@ func (dv *detailView) printPeerDev(r *update.ByRes, conn string) {
 		dv.scratch += fmt.Sprintf("\n")
 
 		if dv.window == detailedstatus {
-			dv.scratch += fmt.Sprintf("   Sent: total:%s Per/Sec:%s\n",
-				convert.KiB2Human(float64(v.SentKiB.Total)), convert.KiB2Human(v.SentKiB.PerSecond))
+			dv.scratch += fmt.Sprintf("   Sent: total:%s Per/Sec:%s 1m/5m/15m:%s\n",
+				convert.KiB2Human(float64(v.SentKiB.Total)), convert.KiB2Human(v.SentKiB.PerSecond),
+				rateAverages(v.SentKiB.Avg1m, v.SentKiB.Avg5m, v.SentKiB.Avg15m))
 
-			dv.scratch += fmt.Sprintf("   Received: total:%s Per/Sec:%s\n",
-				convert.KiB2Human(float64(v.ReceivedKiB.Total)), convert.KiB2Human(v.ReceivedKiB.PerSecond))
+			dv.scratch += fmt.Sprintf("   Received: total:%s Per/Sec:%s 1m/5m/15m:%s\n",
+				convert.KiB2Human(float64(v.ReceivedKiB.Total)), convert.KiB2Human(v.ReceivedKiB.PerSecond),
+				rateAverages(v.ReceivedKiB.Avg1m, v.ReceivedKiB.Avg5m, v.ReceivedKiB.Avg15m))
 
-			dv.scratch += fmt.Sprintf("   OutOfSync: current:%s average:%s min:%s max:%s\n",
+			oosP, penP, unAckP := v.OutOfSyncKiB.Percentiles(), v.PendingWrites.Percentiles(), v.UnackedWrites.Percentiles()
+			dv.scratch += fmt.Sprintf("   OutOfSync: current:%s average:%s min:%s max:%s p50/p95/p99:%s/%s/%s\n",
 				convert.KiB2Human(float64(v.OutOfSyncKiB.Current)),
 				convert.KiB2Human(float64(v.OutOfSyncKiB.Avg)),
 				convert.KiB2Human(float64(v.OutOfSyncKiB.Min)),
-				convert.KiB2Human(float64(v.OutOfSyncKiB.Max)))
+				convert.KiB2Human(float64(v.OutOfSyncKiB.Max)),
+				convert.KiB2Human(float64(oosP.P50)),
+				convert.KiB2Human(float64(oosP.P95)),
+				convert.KiB2Human(float64(oosP.P99)))
 
-			dv.scratch += fmt.Sprintf("   PendingWrites: current:%s average:%s min:%s max:%s\n",
+			dv.scratch += fmt.Sprintf("   PendingWrites: current:%s average:%s min:%s max:%s p50/p95/p99:%d/%d/%d\n",
 				fmt.Sprintf("%.1f", float64(v.PendingWrites.Current)),
 				fmt.Sprintf("%.1f", float64(v.PendingWrites.Avg)),
 				fmt.Sprintf("%.1f", float64(v.PendingWrites.Min)),
-				fmt.Sprintf("%.1f", float64(v.PendingWrites.Max)))
+				fmt.Sprintf("%.1f", float64(v.PendingWrites.Max)),
+				penP.P50, penP.P95, penP.P99)
 
-			dv.scratch += fmt.Sprintf("   UnackedWrites: current:%s average:%s min:%s max:%s\n",
+			dv.scratch += fmt.Sprintf("   UnackedWrites: current:%s average:%s min:%s max:%s p50/p95/p99:%d/%d/%d\n",
 				fmt.Sprintf("%.1f", float64(v.UnackedWrites.Current)),
 				fmt.Sprintf("%.1f", float64(v.UnackedWrites.Avg)),
 				fmt.Sprintf("%.1f", float64(v.UnackedWrites.Min)),
-				fmt.Sprintf("%.1f", float64(v.UnackedWrites.Max)))
+				fmt.Sprintf("%.1f", float64(v.UnackedWrites.Max)),
+				unAckP.P50, unAckP.P95, unAckP.P99)
+
+			if rs := resyncStats(&v.ResyncStats); rs != "" {
+				dv.scratch += fmt.Sprintf("   Resync: %s\n", rs)
+			}
 
 			dv.scratch += fmt.Sprintf("\n")
 		}
@@ -336,11 +402,27 @@ func (d *detailView) UpdateInSync() {
 			}
 
 			var oos, nrPeerDevs uint64
+			var slowest *resource.Resync
 			for _, pdev := range res.PeerDevices {
 				pvol := pdev.Volumes[k]
 				oos += pvol.OutOfSyncKiB.Current
 				nrPeerDevs++
+				if pvol.Resync.Active && (slowest == nil || resyncSlower(&pvol.Resync, slowest)) {
+					slowest = &pvol.Resync
+				}
+			}
+
+			// The volume is in sync when the slowest resync is done.
+			label := "In Sync"
+			if slowest != nil {
+				if slowest.Paused {
+					label += " (resync paused)"
+				} else if slowest.ETA > 0 {
+					label += fmt.Sprintf(" (resync at %s/s, ETA %s)",
+						convert.KiB2Human(slowest.KiBPerSecond), slowest.ETA.Round(time.Second))
+				}
 			}
+			d.volGauges[k].g.BorderLabel = label
 
 			// oosp is oos over *all* peers, sizes are (roughly) the same, so multiply v.Size by nrPeerDevs, to get sane percentage
 			oosp := int(float64(oos*100) / float64(v.Size*nrPeerDevs))
@@ -354,6 +436,94 @@ func (d *detailView) UpdateInSync() {
 	d.oldselres = d.selres
 }
 
+// UpdateGraphs shows the history of the read/write rates of every local volume
+// and the send/receive rates of every peer volume.
+func (d *detailView) UpdateGraphs() {
+	db.RLock()
+	defer db.RUnlock()
+
+	for _, rname := range db.keys {
+		if rname != d.selres {
+			continue
+		}
+		// Copy only the fields, copying the ByRes would copy its lock.
+		dev, pdevs := db.buf[rname].Device, db.buf[rname].PeerDevices
+		if d.selres != d.oldselres {
+			d.volGraphs = make(map[string]*termui.Sparklines)
+		}
+
+		// Keys sort local volumes before the peers.
+		seen := make(map[string]bool)
+		for k, v := range dev.Volumes {
+			key := "0 " + k
+			seen[key] = true
+			d.setGraph(key, fmt.Sprintf("Vol %s (/dev/drbd%s)", k, v.Minor),
+				rateSparkline("read", v.ReadKiB.Previous.Values),
+				rateSparkline("written", v.WrittenKiB.Previous.Values))
+		}
+		for conn, pdev := range pdevs {
+			for k, v := range pdev.Volumes {
+				key := "1 " + conn + " " + k
+				seen[key] = true
+				d.setGraph(key, fmt.Sprintf("Vol %s to %s", k, conn),
+					rateSparkline("sent", v.SentKiB.Previous.Values),
+					rateSparkline("received", v.ReceivedKiB.Previous.Values))
+			}
+		}
+		for key := range d.volGraphs {
+			if !seen[key] {
+				delete(d.volGraphs, key)
+			}
+		}
+	}
+	d.oldselres = d.selres
+}
+
+func (d *detailView) setGraph(key, label string, lines ...termui.Sparkline) {
+	g, ok := d.volGraphs[key]
+	if !ok {
+		g = termui.NewSparklines()
+		g.Height = 2 + len(lines)*(sparklineHeight+1)
+		d.volGraphs[key] = g
+	}
+	g.BorderLabel = label
+	g.Lines = lines
+}
+
+// rateSparkline turns the history of a rate in KiB/s into a sparkline.
+func rateSparkline(title string, history []float64) termui.Sparkline {
+	l := termui.NewSparkline()
+	l.Height = sparklineHeight
+	l.LineColor = termui.ColorGreen
+
+	var cur, max float64
+	for _, v := range history {
+		// Sparklines only take ints, bytes are precise enough.
+		l.Data = append(l.Data, int(v*1024))
+		if v > max {
+			max = v
+		}
+		cur = v
+	}
+	l.Title = fmt.Sprintf("%s: %s/s (max %s/s)", title, convert.KiB2Human(cur), convert.KiB2Human(max))
+
+	return l
+}
+
+func (d *detailView) sortedGraphs() []*termui.Sparklines {
+	var keys []string
+	for k := range d.volGraphs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var ret []*termui.Sparklines
+	for _, k := range keys {
+		ret = append(ret, d.volGraphs[k])
+	}
+	return ret
+}
+
 func (d *detailView) updateContent() {
 	switch d.window {
 	case insync:
@@ -362,6 +532,8 @@ func (d *detailView) updateContent() {
 		d.UpdateStatus()
 	case dmesgw:
 		d.UpdateDmesg()
+	case graphs:
+		d.UpdateGraphs()
 	default:
 		panic("window")
 	}
@@ -396,6 +568,14 @@ func (d *detailView) updateGUI(updateContent bool) {
 			termui.NewRow(
 				termui.NewCol(12, 0, d.status)))
 		heights = d.status.Height + d.header.Height + d.footer.Height
+	case graphs:
+		heights = d.header.Height + d.footer.Height
+		for _, g := range d.sortedGraphs() {
+			d.grid.AddRows(
+				termui.NewRow(
+					termui.NewCol(12, 0, g)))
+			heights += g.Height
+		}
 	default:
 		panic("window")
 	}
@@ -424,6 +604,84 @@ func (d *detailView) UpdateGUI() {
 	d.updateGUI(true)
 }
 
+// Target returns the object commands are run on.
+func (d *detailView) Target() drbdadm.Target {
+	if d.target < len(d.targets) {
+		return d.targets[d.target]
+	}
+	return drbdadm.Target{Resource: d.selres}
+}
+
+// updateTargets collects the resource, its volumes, connections and peer
+// volumes as targets, keeping the selected one if it still exists.
+func (d *detailView) updateTargets() {
+	db.RLock()
+	defer db.RUnlock()
+	// A resource that went away has no device.
+	dev, conns, pdevs := db.buf[d.selres].Device, db.buf[d.selres].Connections, db.buf[d.selres].PeerDevices
+
+	old := d.Target()
+	d.targets = []drbdadm.Target{{Resource: d.selres}}
+	if dev != nil {
+		var vols []string
+		for v := range dev.Volumes {
+			vols = append(vols, v)
+		}
+		sort.Strings(vols)
+		for _, v := range vols {
+			d.targets = append(d.targets, drbdadm.Target{Resource: d.selres, Volume: v})
+		}
+
+		var names []string
+		for c := range conns {
+			names = append(names, c)
+		}
+		sort.Strings(names)
+		for _, c := range names {
+			d.targets = append(d.targets, drbdadm.Target{Resource: d.selres, Peer: c})
+			vols = nil
+			if p, ok := pdevs[c]; ok {
+				for v := range p.Volumes {
+					vols = append(vols, v)
+				}
+			}
+			sort.Strings(vols)
+			for _, v := range vols {
+				d.targets = append(d.targets, drbdadm.Target{Resource: d.selres, Peer: c, Volume: v})
+			}
+		}
+	}
+
+	d.target = 0
+	for i, t := range d.targets {
+		if t == old {
+			d.target = i
+		}
+	}
+}
+
+// targetChanged updates the targets and reports whether another one is
+// selected now, e.g. because the selected one went away.
+func (d *detailView) targetChanged() bool {
+	old := d.Target()
+	d.updateTargets()
+	return d.Target() != old
+}
+
+// showTarget shows the selected target and the help in the footer.
+func (d *detailView) showTarget() {
+	d.updateTargets()
+	d.footer.Text = fmt.Sprintf("Target: %s | %s", colDefault(d.Target().String(), true), detailHelp)
+	termui.Render(d.footer)
+}
+
+// moveTarget selects the n-th next target, or previous one if n is negative.
+func (d *detailView) moveTarget(n int) {
+	d.updateTargets()
+	d.target = (d.target + n + len(d.targets)) % len(d.targets)
+	d.showTarget()
+}
+
 func (d *detailView) setWindow(e termui.Event) {
 	k, _ := e.Data.(termui.EvtKbd)
 	old := d.window
@@ -436,6 +694,8 @@ func (d *detailView) setWindow(e termui.Event) {
 		d.window = detailedstatus
 	case "m":
 		d.window = dmesgw
+	case "g":
+		d.window = graphs
 	}
 
 	if old != d.window {
@@ -470,6 +730,16 @@ func (d *detailView) Update() {
 	case dmesgw:
 		d.UpdateDmesg()
 		termui.Render(d.status)
+	case graphs:
+		was := len(d.volGraphs)
+		d.UpdateGraphs()
+		if was != len(d.volGraphs) {
+			d.updateGUI(false)
+		} else {
+			for _, g := range d.sortedGraphs() {
+				termui.Render(g)
+			}
+		}
 	default:
 		panic("window")
 	}
@@ -0,0 +1,158 @@
+/*
+ *drbdtop - statistics for DRBD
+ *Copyright © 2017 Hayley Swimelar and Roland Kammerer
+ *
+ *This program is free software; you can redistribute it and/or modify
+ *it under the terms of the GNU General Public License as published by
+ *the Free Software Foundation; either version 2 of the License, or
+ *(at your option) any later version.
+ *
+ *This program is distributed in the hope that it will be useful,
+ *but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ *GNU General Public License for more details.
+ *
+ *You should have received a copy of the GNU General Public License
+ *along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package display
+
+import (
+	"strings"
+
+	"github.com/LINBIT/drbdtop/pkg/drbdadm"
+)
+
+// menu is a command menu, opened by its key in command mode.
+type menu struct {
+	key     string
+	name    string
+	entries []menuEntry
+}
+
+// menuEntry runs its action on the selected targets, or on all resources if
+// its key is upper case.
+type menuEntry struct {
+	key    string
+	name   string
+	action drbdadm.Action
+}
+
+var menus = []menu{
+	{"s", "state", []menuEntry{
+		{"u", "up", drbdadm.Up},
+		{"d", "down", drbdadm.Down},
+		{"s", "suspend-io", drbdadm.SuspendIO},
+		{"r", "resume-io", drbdadm.ResumeIO},
+		{"U", "up all", drbdadm.Up},
+		{"D", "down all", drbdadm.Down},
+	}},
+	{"r", "role", []menuEntry{
+		{"p", "primary", drbdadm.Primary},
+		{"f", "primary --force", drbdadm.ForcePrimary},
+		{"s", "secondary", drbdadm.Secondary},
+		{"P", "primary all", drbdadm.Primary},
+		{"S", "secondary all", drbdadm.Secondary},
+	}},
+	{"a", "adjust", []menuEntry{
+		{"a", "adjust", drbdadm.Adjust},
+		{"A", "adjust all", drbdadm.Adjust},
+	}},
+	{"d", "disk", []menuEntry{
+		{"a", "attach", drbdadm.Attach},
+		{"d", "detach", drbdadm.Detach},
+		{"i", "invalidate", drbdadm.Invalidate},
+		{"r", "invalidate-remote", drbdadm.InvalidateRemote},
+		{"z", "resize", drbdadm.Resize},
+		{"A", "attach all", drbdadm.Attach},
+		{"D", "detach all", drbdadm.Detach},
+	}},
+	{"c", "conn", []menuEntry{
+		{"c", "connect", drbdadm.Connect},
+		{"d", "disconnect", drbdadm.Disconnect},
+		{"m", "discard my data", drbdadm.DiscardMyData},
+		{"f", "forget unconnected peers", drbdadm.ForgetPeer},
+		// The recovery discards the data of the victim.
+		{"b", "resolve split brain", drbdadm.DiscardMyData},
+		{"C", "connect all", drbdadm.Connect},
+		{"D", "disconnect all", drbdadm.Disconnect},
+	}},
+	{"m", "meta", []menuEntry{
+		{"c", "create-md --force", drbdadm.CreateMD},
+		{"n", "new-current-uuid", drbdadm.NewCurrentUUID},
+	}},
+	{"v", "verify/sync", []menuEntry{
+		{"v", "verify", drbdadm.Verify},
+		{"p", "pause-sync", drbdadm.PauseSync},
+		{"r", "resume-sync", drbdadm.ResumeSync},
+		{"V", "verify all", drbdadm.Verify},
+	}},
+}
+
+// findMenu returns the menu opened by key, nil if there is none or mode
+// allows none of its entries.
+func findMenu(key string, mode drbdadm.Mode) *menu {
+	for i, m := range menus {
+		if m.key == key && len(m.allowed(mode)) > 0 {
+			return &menus[i]
+		}
+	}
+	return nil
+}
+
+// findEntry returns the entry of the menu opened by menuKey that is chosen
+// by key, nil if there is none or mode does not allow it.
+func findEntry(menuKey, key string, mode drbdadm.Mode) *menuEntry {
+	m := findMenu(menuKey, mode)
+	if m == nil {
+		return nil
+	}
+	for _, e := range m.allowed(mode) {
+		if e.key == key {
+			return &e
+		}
+	}
+	return nil
+}
+
+// allowed returns the entries of m that mode allows.
+func (m menu) allowed(mode drbdadm.Mode) []menuEntry {
+	var entries []menuEntry
+	for _, e := range m.entries {
+		if mode.Allows(e.action) {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// help returns the help for the entries of m that mode allows.
+func (m menu) help(mode drbdadm.Mode) string {
+	var help []string
+	for _, e := range m.allowed(mode) {
+		help = append(help, e.key+": "+e.name)
+	}
+	return strings.Join(help, " | ")
+}
+
+// menuHelp returns the help for the menus that mode allows, empty if it
+// allows none.
+func menuHelp(mode drbdadm.Mode) string {
+	var help []string
+	for _, m := range menus {
+		if len(m.allowed(mode)) > 0 {
+			help = append(help, m.key+": "+m.name)
+		}
+	}
+	return strings.Join(help, " | ")
+}
+
+// setMenuHelp updates the footers to the commands mode allows.
+func setMenuHelp(mode drbdadm.Mode) {
+	commandHelp = menuHelp(mode)
+	lockedHelp = lockedHelpFor(commandHelp)
+	if commandHelp == "" {
+		detailHelp = strings.Replace(detailHelp, "x: command | ", "", 1)
+	}
+}
@@ -0,0 +1,127 @@
+/*
+ *drbdtop - statistics for DRBD
+ *Copyright © 2017 Hayley Swimelar and Roland Kammerer
+ *
+ *This program is free software; you can redistribute it and/or modify
+ *it under the terms of the GNU General Public License as published by
+ *the Free Software Foundation; either version 2 of the License, or
+ *(at your option) any later version.
+ *
+ *This program is distributed in the hope that it will be useful,
+ *but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ *GNU General Public License for more details.
+ *
+ *You should have received a copy of the GNU General Public License
+ *along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package display
+
+import (
+	"strings"
+
+	"github.com/LINBIT/termui"
+)
+
+// outputView shows text that doesn't fit into the footer, such as the
+// output of commands, in a scrollable pane.
+type outputView struct {
+	grid           *termui.Grid
+	header, footer *termui.Par
+	text           *termui.Par
+	lines          []string
+	offset         int
+}
+
+func NewOutputView() *outputView {
+	o := outputView{}
+
+	o.header = termui.NewPar("")
+	o.header.Height = 1
+	o.header.TextFgColor = termui.ColorDefault
+	o.header.TextBgColor = termui.ColorDefault
+	o.header.Border = false
+
+	o.text = termui.NewPar("")
+	o.text.TextFgColor = termui.ColorDefault
+	o.text.TextBgColor = termui.ColorDefault
+
+	o.footer = termui.NewPar("")
+	o.footer.Height = 1
+	o.footer.TextFgColor = termui.ColorDefault
+	o.footer.TextBgColor = termui.ColorDefault
+	o.footer.Border = false
+
+	return &o
+}
+
+// SetText replaces the text and scrolls to its start.
+func (o *outputView) SetText(title string, lines []string) {
+	o.text.BorderLabel = title
+	o.lines = lines
+	o.offset = 0
+}
+
+// AddLines appends lines and scrolls down so that they are visible.
+func (o *outputView) AddLines(lines ...string) {
+	o.lines = append(o.lines, lines...)
+	if over := len(o.lines) - o.visibleLines(); over > o.offset {
+		o.offset = over
+	}
+}
+
+// SetHelp sets the footer text.
+func (o *outputView) SetHelp(help string) {
+	o.footer.Text = help
+}
+
+// Scroll by n lines, up if n is negative.
+func (o *outputView) Scroll(n int) {
+	o.offset += n
+	if max := len(o.lines) - o.visibleLines(); o.offset > max {
+		o.offset = max
+	}
+	if o.offset < 0 {
+		o.offset = 0
+	}
+	o.Update()
+}
+
+func (o *outputView) visibleLines() int {
+	return o.text.Height - 2
+}
+
+func (o *outputView) setText() {
+	to := o.offset + o.visibleLines()
+	if to > len(o.lines) {
+		to = len(o.lines)
+	}
+	from := o.offset
+	if from > to {
+		from = to
+	}
+	o.text.Text = strings.Join(o.lines[from:to], "\n")
+}
+
+func (o *outputView) UpdateGUI() {
+	o.header.Text = drbdtopversion
+	o.text.Height = termui.TermHeight() - o.header.Height - o.footer.Height
+	o.setText()
+
+	o.grid = termui.NewGrid()
+	o.grid.AddRows(
+		termui.NewRow(
+			termui.NewCol(12, 0, o.header)),
+		termui.NewRow(
+			termui.NewCol(12, 0, o.text)),
+		termui.NewRow(
+			termui.NewCol(12, 0, o.footer)))
+
+	switchDisp(o.grid)
+}
+
+func (o *outputView) Update() {
+	o.setText()
+	termui.Render(o.text, o.footer)
+}
@@ -18,10 +18,25 @@
 
 package display
 
-import "github.com/LINBIT/termui"
-
-var lockedHelp string = "q: QUIT | /: find | t: tag | s: state | r: role | a: adjust | d: disk | c: conn | m: meta | <tab>: Update"
-var unlockedHelp string = "q: QUIT | j/k: down/up | f: Toggle dangerous filter | <tab>: Toggle updates"
+import (
+	"github.com/LINBIT/drbdtop/pkg/drbdadm"
+	"github.com/LINBIT/termui"
+)
+
+var commandHelp string = menuHelp(drbdadm.Admin)
+var lockedHelp string = lockedHelpFor(commandHelp)
+var unlockedHelp string = "q: QUIT | j/k: down/up | f: Toggle dangerous filter | o: sort | R: reset stats | H: history | <tab>: Toggle updates"
+var replayHelp string = " | <space>: pause | .: step | +/-: speed | </>: seek 10s | [/]: seek 1m"
+
+// lockedHelpFor returns the help of the frozen overview for the command menus
+// described by commandHelp.
+func lockedHelpFor(commandHelp string) string {
+	if commandHelp == "" {
+		// Nothing to tag for.
+		return "q: QUIT | /: find | <tab>: Update"
+	}
+	return "q: QUIT | /: find | t: tag | " + commandHelp + " | <tab>: Update"
+}
 
 func window(selidx, maxItems, overall int) (from, to int) {
 	block := 0
@@ -52,6 +67,7 @@ type overView struct {
 	from, to            int
 	locked              bool // TODO maybe make this a propper lock
 	filterDanger        bool // probably going to be an actuall score/int
+	sortLabel           string
 }
 
 func NewOverView() *overView {
@@ -138,8 +154,12 @@ func (o *overView) UpdateTable() {
 				devdanger := r.Device.Danger
 
 				var conndanger uint64
+				splitBrain := false
 				for _, c := range r.Connections {
 					conndanger += c.Danger
+					if c.SplitBrain != nil && c.SplitBrain.Confirmed {
+						splitBrain = true
+					}
 				}
 
 				var pddanger uint64
@@ -170,10 +190,15 @@ func (o *overView) UpdateTable() {
 					}
 				}
 
+				conns := dangerToString(conndanger, ucfg)
+				if splitBrain {
+					conns = colRed("✗ SPLIT BRAIN", true)
+				}
+
 				tblrows[idx+1] = []string{res.Name, role,
 					dangerToString(devdanger, ucfg),
 					dangerToString(pddanger, ucfg),
-					dangerToString(conndanger, ucfg),
+					conns,
 					dangerToString(r.Danger, false),
 					quorumLabel}
 			}
@@ -304,6 +329,9 @@ func (o *overView) setLockedStr() {
 		}
 		o.footer.Text = unlockedHelp
 	}
+	if o.sortLabel != "" {
+		o.tbl.BorderLabel += " (sorted by " + o.sortLabel + ")"
+	}
 	termui.Render(o.tbl, o.footer)
 }
 
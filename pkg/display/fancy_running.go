@@ -0,0 +1,289 @@
+/*
+ *drbdtop - statistics for DRBD
+ *Copyright © 2017 Hayley Swimelar and Roland Kammerer
+ *
+ *This program is free software; you can redistribute it and/or modify
+ *it under the terms of the GNU General Public License as published by
+ *the Free Software Foundation; either version 2 of the License, or
+ *(at your option) any later version.
+ *
+ *This program is distributed in the hope that it will be useful,
+ *but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ *GNU General Public License for more details.
+ *
+ *You should have received a copy of the GNU General Public License
+ *along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package display
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/LINBIT/drbdtop/pkg/audit"
+	"github.com/LINBIT/drbdtop/pkg/drbdadm"
+	"github.com/LINBIT/termui"
+)
+
+// Time a command may run before it is killed, it can be canceled earlier.
+const commandTimeout = 2 * time.Minute
+
+var spinner = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// runningCommand is a command that runs in the background.
+type runningCommand struct {
+	cancel context.CancelFunc
+}
+
+// startCommand runs cmd in the background and shows its progress in p until
+// it is done, then its output. <esc> or q cancels it.
+func (f *FancyTUI) startCommand(cmd *drbdadm.Command, entry audit.Entry, p *termui.Par) {
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	f.running = &runningCommand{cancel: cancel}
+
+	go func() {
+		defer cancel()
+
+		var out []byte
+		var err error
+		done := make(chan struct{})
+		go func() {
+			out, err = cmd.Run(ctx)
+			close(done)
+		}()
+
+		took := f.showProgress(p, func() string {
+			return fmt.Sprintf("Executing '%s'...", cmd)
+		}, done)
+		entry.SetResult(out, err)
+
+		f.Lock()
+		defer f.Unlock()
+		f.commandDone(cmd, entry, took, p)
+	}()
+}
+
+// showProgress shows a spinner, the status and the elapsed time in p until
+// done is closed, and returns the elapsed time. It runs in the background
+// and locks the TUI only to update p.
+func (f *FancyTUI) showProgress(p *termui.Par, status func() string, done <-chan struct{}) time.Duration {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	start := time.Now()
+	for i := 0; ; i++ {
+		text := fmt.Sprintf("%s %s %.1fs | <esc>/q: cancel",
+			spinner[i%len(spinner)], status(), time.Since(start).Seconds())
+		f.Lock()
+		p.Text = text
+		termui.Render(p)
+		f.Unlock()
+
+		select {
+		case <-done:
+			return time.Since(start)
+		case <-ticker.C:
+		}
+	}
+}
+
+// commandDone records the result of a command run by startCommand and shows
+// its output, unless it succeeded silently. The TUI has to be locked.
+func (f *FancyTUI) commandDone(cmd *drbdadm.Command, entry audit.Entry, took time.Duration, p *termui.Par) {
+	auditErr := f.audit.Record(entry)
+	f.running = nil
+	f.finishCommand()
+
+	if entry.Status() == "ok" && auditErr == nil && strings.TrimSpace(entry.Output) == "" {
+		tmpFooterMsg(p, fmt.Sprintf("Executed '%s' %s", cmd, setOK()), 2*time.Second)
+		return
+	}
+
+	var lines []string
+	if out := strings.TrimRight(entry.Output, "\n"); out != "" {
+		lines = append(strings.Split(out, "\n"), "")
+	}
+	took = took.Round(10 * time.Millisecond)
+	if entry.Status() == "ok" {
+		lines = append(lines, colGreen(fmt.Sprintf("Succeeded after %s.", took), true))
+	} else {
+		lines = append(lines, colRed(fmt.Sprintf("The command %s after %s.", entry.Status(), took), true))
+	}
+	if auditErr != nil {
+		lines = append(lines, colRed(auditErr.Error(), true))
+	}
+
+	f.output.SetText(fmt.Sprintf("Output of '%s'", cmd), lines)
+	f.output.SetHelp("j/k: scroll | q: back")
+	f.showOutput()
+}
+
+// Number of commands of a bulk operation that run at the same time.
+const bulkParallel = 4
+
+// bulkResults are the results of a command that was run on several targets,
+// one at a time.
+type bulkResults struct {
+	// The keys the command was entered with.
+	command string
+	cmds    []*drbdadm.Command
+	entries []audit.Entry
+}
+
+// failed returns the commands that failed.
+func (b *bulkResults) failed() []*drbdadm.Command {
+	var failed []*drbdadm.Command
+	for i, e := range b.entries {
+		if e.Status() != "ok" {
+			failed = append(failed, b.cmds[i])
+		}
+	}
+	return failed
+}
+
+// startBulk runs cmds in the background, bulkParallel at a time, and shows the
+// progress in p until they are done, then their results. <esc> or q cancels
+// them.
+func (f *FancyTUI) startBulk(cmds []*drbdadm.Command, confirmationRequired bool, p *termui.Par) {
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	f.running = &runningCommand{cancel: cancel}
+
+	b := &bulkResults{command: commandstr, cmds: cmds}
+	for _, c := range cmds {
+		entry := audit.NewEntry(c.Argv(), commandTargets(c))
+		entry.ConfirmationRequired = confirmationRequired
+		b.entries = append(b.entries, entry)
+	}
+
+	go func() {
+		defer cancel()
+
+		var mu sync.Mutex
+		finished, failed := 0, 0
+		done := make(chan struct{})
+		go func() {
+			drbdadm.RunAll(ctx, cmds, bulkParallel, func(i int, r drbdadm.Result) {
+				mu.Lock()
+				defer mu.Unlock()
+				b.entries[i].SetResult(r.Output, r.Err)
+				finished++
+				if b.entries[i].Status() != "ok" {
+					failed++
+				}
+			})
+			close(done)
+		}()
+
+		took := f.showProgress(p, func() string {
+			mu.Lock()
+			defer mu.Unlock()
+			return fmt.Sprintf("Executing 'drbdadm %s' on %s: %d done, %d failed...",
+				cmds[0].Action, countTargets(len(cmds)), finished, failed)
+		}, done)
+
+		f.Lock()
+		defer f.Unlock()
+		f.bulkDone(b, took)
+	}()
+}
+
+// bulkDone records the results of a bulk operation and shows them. The TUI
+// has to be locked.
+func (f *FancyTUI) bulkDone(b *bulkResults, took time.Duration) {
+	var auditErrs []string
+	for _, e := range b.entries {
+		if err := f.audit.Record(e); err != nil {
+			auditErrs = append(auditErrs, err.Error())
+		}
+	}
+	f.running = nil
+	f.finishCommand()
+
+	failed := len(b.failed())
+	summary := fmt.Sprintf("'drbdadm %s' succeeded on %s after %s.",
+		b.cmds[0].Action, countTargets(len(b.cmds)), took.Round(10*time.Millisecond))
+	if failed > 0 {
+		summary = colRed(fmt.Sprintf("'drbdadm %s' failed on %d of %s after %s.",
+			b.cmds[0].Action, failed, countTargets(len(b.cmds)), took.Round(10*time.Millisecond)), true)
+	} else {
+		summary = colGreen(summary, true)
+	}
+	lines := []string{summary, ""}
+	for _, err := range auditErrs {
+		lines = append(lines, colRed(err, true))
+	}
+
+	var table bytes.Buffer
+	w := tabwriter.NewWriter(&table, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "TARGET\tSTATUS\tOUTPUT")
+	for i, e := range b.entries {
+		out := strings.Split(strings.TrimSpace(e.Output), "\n")
+		fmt.Fprintf(w, "%s\t%s\t%s\n", b.cmds[i].Targets[0], e.Status(), out[0])
+	}
+	w.Flush()
+	lines = append(lines, strings.Split(strings.TrimRight(table.String(), "\n"), "\n")...)
+
+	for i, e := range b.entries {
+		out := strings.TrimSpace(e.Output)
+		if strings.Count(out, "\n") == 0 {
+			// Already complete in the table.
+			continue
+		}
+		lines = append(lines, "", fmt.Sprintf("Output for %s:", b.cmds[i].Targets[0]))
+		for _, l := range strings.Split(out, "\n") {
+			lines = append(lines, "    "+l)
+		}
+	}
+
+	help := "j/k: scroll | q: back"
+	if failed > 0 {
+		help = "r: retry failed | " + help
+		f.results = b
+	}
+	f.output.SetText("Results", lines)
+	f.output.SetHelp(help)
+	f.showOutput()
+}
+
+// countTargets returns "n targets".
+func countTargets(n int) string {
+	if n == 1 {
+		return "1 target"
+	}
+	return fmt.Sprintf("%d targets", n)
+}
+
+// resultsKey handles a key pressed in the results of a bulk operation. The
+// failed commands are retried like a new command on their targets, the state
+// of the resources has probably changed since they failed.
+func (f *FancyTUI) resultsKey(key string) {
+	if key != "r" {
+		return
+	}
+	b := f.results
+	f.results = nil
+
+	f.dmode = f.outputFrom
+	p := f.overview.footer
+	if f.dmode == detail {
+		f.detail.UpdateGUI()
+		p = f.detail.footer
+	} else {
+		f.overview.UpdateGUI()
+	}
+	f.cmode = command
+	commandstr = b.command
+	commandFinished = true
+	for _, c := range b.failed() {
+		f.retry = append(f.retry, c.Targets...)
+	}
+	f.confirmCommand("", unknown, p)
+	termui.Render(p)
+}
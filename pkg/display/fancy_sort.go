@@ -0,0 +1,127 @@
+/*
+ *drbdtop - statistics for DRBD
+ *Copyright © 2017 Hayley Swimelar and Roland Kammerer
+ *
+ *This program is free software; you can redistribute it and/or modify
+ *it under the terms of the GNU General Public License as published by
+ *the Free Software Foundation; either version 2 of the License, or
+ *(at your option) any later version.
+ *
+ *This program is distributed in the hope that it will be useful,
+ *but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ *GNU General Public License for more details.
+ *
+ *You should have received a copy of the GNU General Public License
+ *along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package display
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/LINBIT/drbdtop/pkg/update"
+)
+
+// sortKey is an order of the overview, chosen by its key in the sort menu.
+// The upper case key reverses it.
+type sortKey struct {
+	key  string
+	name string
+	// less puts the resources most worth a look first, reverse last.
+	less    update.LessFunc
+	reverse update.LessFunc
+}
+
+var sortKeys = []sortKey{
+	{"d", "danger", update.DangerReverse, update.Danger},
+	{"s", "size", update.SizeReverse, update.Size},
+	{"n", "name", update.Name, update.NameReverse},
+	{"r", "role", update.Role, update.RoleReverse},
+	{"w", "write-rate", update.WriteRateReverse, update.WriteRate},
+	{"e", "read-rate", update.ReadRateReverse, update.ReadRate},
+	{"o", "out-of-sync", update.OutOfSyncReverse, update.OutOfSync},
+	{"p", "pending", update.PendingReverse, update.Pending},
+	{"u", "unacked", update.UnackedReverse, update.Unacked},
+	{"c", "peers", update.PeersReverse, update.Peers},
+	{"t", "state-change", update.StateChangedReverse, update.StateChanged},
+}
+
+// SortKeys returns the names of the orders of the overview.
+func SortKeys() []string {
+	var names []string
+	for _, s := range sortKeys {
+		names = append(names, s.name)
+	}
+	return names
+}
+
+// sortOrder is how the overview is sorted.
+type sortOrder struct {
+	sortKey
+	reversed bool
+}
+
+// lessFuncs returns the LessFuncs of o. Resources that are equal are left in
+// the default order.
+func (o sortOrder) lessFuncs() []update.LessFunc {
+	less := o.less
+	if o.reversed {
+		less = o.reverse
+	}
+	return []update.LessFunc{less, update.DangerReverse, update.SizeReverse, update.Name}
+}
+
+func (o sortOrder) String() string {
+	if o.reversed {
+		return o.name + ", reversed"
+	}
+	return o.name
+}
+
+// sortHelp returns the help of the sort menu.
+func sortHelp() string {
+	var help []string
+	for _, s := range sortKeys {
+		help = append(help, s.key+": "+s.name)
+	}
+	return "Sort by " + strings.Join(help, " | ") + " | upper case: reverse | <esc>: Abort"
+}
+
+// SetSort sorts the overview by the order called name.
+func (f *FancyTUI) SetSort(name string, reverse bool) error {
+	for _, s := range sortKeys {
+		if s.name == name {
+			f.setSort(sortOrder{sortKey: s, reversed: reverse})
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown order %q", name)
+}
+
+func (f *FancyTUI) setSort(o sortOrder) {
+	f.resources.OrderBy(o.lessFuncs()...)
+	f.overview.sortLabel = o.String()
+	f.overview.setLockedStr()
+}
+
+// chooseSort handles a key pressed in the sort menu.
+func (f *FancyTUI) chooseSort(key string) {
+	for _, s := range sortKeys {
+		if key == s.key || key == strings.ToUpper(s.key) {
+			f.setSort(sortOrder{sortKey: s, reversed: key != s.key})
+			f.sorting = false
+			f.reset()
+			f.resources.Sort()
+			// Handlers hold the lock UpdateDisp waits for. If it is waiting
+			// already, it shows the new order anyway, so don't block.
+			select {
+			case f.updateDisp <- struct{}{}:
+			default:
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,405 @@
+/*
+ *drbdtop - statistics for DRBD
+ *Copyright © 2017 Hayley Swimelar and Roland Kammerer
+ *
+ *This program is free software; you can redistribute it and/or modify
+ *it under the terms of the GNU General Public License as published by
+ *the Free Software Foundation; either version 2 of the License, or
+ *(at your option) any later version.
+ *
+ *This program is distributed in the hope that it will be useful,
+ *but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ *GNU General Public License for more details.
+ *
+ *You should have received a copy of the GNU General Public License
+ *along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package display
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/LINBIT/drbdtop/pkg/audit"
+	"github.com/LINBIT/drbdtop/pkg/drbdadm"
+	"github.com/LINBIT/drbdtop/pkg/update"
+)
+
+// Time a step of the recovery may run before it is killed.
+const recoveryStepTimeout = 30 * time.Second
+
+// commandStep is a drbdadm command, run on the local node or via ssh on a peer.
+type commandStep struct {
+	// Node to run the command on, the local node if empty.
+	node string
+	// Address ssh connects to for node.
+	host string
+	cmd  *drbdadm.Command
+}
+
+func (s commandStep) argv() []string {
+	argv := s.cmd.Argv()
+	if s.node != "" {
+		argv = append([]string{"ssh", "-o", "BatchMode=yes", "-o", "ConnectTimeout=10", s.host}, argv...)
+	}
+	return argv
+}
+
+func (s commandStep) String() string {
+	return s.cmd.String()
+}
+
+func (s commandStep) run(ctx context.Context) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, recoveryStepTimeout)
+	defer cancel()
+
+	argv := s.argv()
+	return exec.CommandContext(ctx, argv[0], argv[1:]...).CombinedOutput()
+}
+
+// splitBrainRecovery guides through resolving a split brain by discarding
+// the data of a victim and reconnecting it to the survivors.
+type splitBrainRecovery struct {
+	res string
+	// Name of the local node as its peers know it.
+	local string
+	// Peers that are, or may be, split brained.
+	peers []string
+	// Addresses ssh connects to, by node name. Nodes without one are
+	// connected to by their name.
+	hosts  map[string]string
+	drbd84 bool
+	victim string
+	steps  []commandStep
+	// Problems the local steps would cause, as found by drbdadm.Preflight.
+	problems []drbdadm.Problem
+	done     bool
+}
+
+func newSplitBrainRecovery(r *update.ByRes, hosts map[string]string) (*splitBrainRecovery, error) {
+	s := &splitBrainRecovery{res: r.Res.Name, hosts: hosts}
+	for name, c := range r.Connections {
+		if c.SplitBrain != nil {
+			s.peers = append(s.peers, name)
+		}
+	}
+	if len(s.peers) == 0 {
+		return nil, fmt.Errorf("No split brain detected for %s", s.res)
+	}
+	sort.Strings(s.peers)
+
+	// drbdadm finds the local node in the configuration by this name, too.
+	local, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't determine the name of this node: %v", err)
+	}
+	s.local = local
+
+	if kv, err := getKernelModVersion(); err == nil && kv.major == 8 {
+		s.drbd84 = true
+	}
+	return s, nil
+}
+
+// describe explains the split brain and the nodes that can be chosen as the victim.
+func (s *splitBrainRecovery) describe(r *update.ByRes) []string {
+	lines := []string{fmt.Sprintf("%s is %s on %s (this node), its disks:", s.res, r.Res.Role, s.local)}
+	var vols []string
+	for v := range r.Device.Volumes {
+		vols = append(vols, v)
+	}
+	sort.Strings(vols)
+	for _, v := range vols {
+		lines = append(lines, fmt.Sprintf("  volume %s: %s", v, r.Device.Volumes[v].DiskState))
+	}
+
+	lines = append(lines, "")
+	for _, p := range s.peers {
+		c := r.Connections[p]
+		kind := colRed("split brain", true)
+		if !c.SplitBrain.Confirmed {
+			kind = "possible split brain"
+		}
+		lines = append(lines, fmt.Sprintf("%s with %s since %s: %s, the peer is %s",
+			kind, p, c.SplitBrain.Since.Format("2006-01-02 15:04:05"), c.SplitBrain, c.Role))
+	}
+
+	lines = append(lines, "", "Choose the victim, its changes since the split brain are discarded:")
+	for i, n := range s.nodes() {
+		lines = append(lines, fmt.Sprintf("  %d: %s", i, n))
+	}
+	return lines
+}
+
+// nodes returns the nodes the victim can be chosen from, this node first.
+func (s *splitBrainRecovery) nodes() []string {
+	return append([]string{s.local}, s.peers...)
+}
+
+// target returns the target for drbdadm that addresses the connection to peer.
+func (s *splitBrainRecovery) target(peer string) drbdadm.Target {
+	if s.drbd84 {
+		// There is only one connection.
+		return drbdadm.Target{Resource: s.res}
+	}
+	return drbdadm.Target{Resource: s.res, Peer: peer}
+}
+
+// step returns a step that runs drbdadm a on t on node.
+func (s *splitBrainRecovery) step(node string, a drbdadm.Action, t drbdadm.Target) commandStep {
+	cmd := drbdadm.New(a, t)
+	if node == s.local {
+		return commandStep{cmd: cmd}
+	}
+	host := s.hosts[node]
+	if host == "" {
+		host = node
+	}
+	return commandStep{node: node, host: host, cmd: cmd}
+}
+
+// choose plans the recovery with the n-th of nodes as the victim.
+func (s *splitBrainRecovery) choose(n int) bool {
+	nodes := s.nodes()
+	if n < 0 || n >= len(nodes) {
+		return false
+	}
+	s.victim = nodes[n]
+
+	res := drbdadm.Target{Resource: s.res}
+	if s.victim == s.local {
+		s.steps = []commandStep{s.step(s.local, drbdadm.Secondary, res)}
+		for _, p := range s.peers {
+			s.steps = append(s.steps,
+				s.step(s.local, drbdadm.DiscardMyData, s.target(p)),
+				s.step(p, drbdadm.Connect, s.target(s.local)))
+		}
+	} else {
+		s.steps = []commandStep{
+			s.step(s.victim, drbdadm.Secondary, res),
+			s.step(s.victim, drbdadm.DiscardMyData, s.target(s.local)),
+			s.step(s.local, drbdadm.Connect, s.target(s.victim)),
+		}
+	}
+	return true
+}
+
+// check runs the pre-flight checks on the local steps against the current
+// state of the resources, the state of the peers is not known. The
+// resources have to be locked.
+func (s *splitBrainRecovery) check(resources map[string]*update.ByRes) {
+	s.problems = nil
+	demoted := false
+	for _, st := range s.steps {
+		if st.node != "" {
+			continue
+		}
+		for _, p := range drbdadm.Preflight(st.cmd, resources) {
+			// The recovery demotes the node before discarding its data and
+			// stops if that fails.
+			if demoted && p.Blocking && st.cmd.Action.String() == drbdadm.DiscardMyData.String() {
+				continue
+			}
+			s.problems = append(s.problems, p)
+		}
+		if st.cmd.Action.String() == drbdadm.Secondary.String() {
+			demoted = true
+		}
+	}
+}
+
+func (s *splitBrainRecovery) stepNode(st commandStep) string {
+	if st.node == "" {
+		return s.local
+	}
+	return fmt.Sprintf("%s (ssh %s)", st.node, st.host)
+}
+
+// plan describes the steps of the recovery and the problems they would cause.
+func (s *splitBrainRecovery) plan() []string {
+	lines := []string{fmt.Sprintf("Victim: %s, its changes since the split brain are discarded.", s.victim), ""}
+	for i, st := range s.steps {
+		lines = append(lines, fmt.Sprintf("  %d. on %s: %s", i+1, s.stepNode(st), st))
+	}
+	lines = append(lines, "", "Nodes are reached by their name unless \"ssh-hosts\" in the configuration",
+		"has an address for them.")
+
+	if len(s.problems) > 0 {
+		lines = append(lines, "")
+		for _, p := range s.problems {
+			if p.Blocking {
+				lines = append(lines, colRed("Refusing: "+p.String(), true))
+			} else {
+				lines = append(lines, colRed("Warning: "+p.String(), true))
+			}
+		}
+	}
+	if drbdadm.Blocking(s.problems) {
+		return append(lines, "", "The recovery can't be run.")
+	}
+	return append(lines, "", "Run these steps?")
+}
+
+// startSplitBrainRecovery starts the recovery of the selected resource.
+func (f *FancyTUI) startSplitBrainRecovery() {
+	f.resources.RLock()
+	r, ok := f.resources.Map[f.overview.selres]
+	f.resources.RUnlock()
+	if !ok {
+		return
+	}
+	r.RLock()
+	defer r.RUnlock()
+
+	rec, err := newSplitBrainRecovery(r, f.sshHosts)
+	if err != nil {
+		tmpFooterMsg(f.overview.footer, err.Error(), 4*time.Second)
+		return
+	}
+
+	f.recovery = rec
+	f.output.SetText("Split brain recovery of "+rec.res, rec.describe(r))
+	f.output.SetHelp(fmt.Sprintf("0-%d: choose victim | j/k: scroll | q: abort", len(rec.nodes())-1))
+	f.showOutput()
+}
+
+// recoveryKey handles a key pressed during the split brain recovery.
+func (f *FancyTUI) recoveryKey(key string) {
+	rec := f.recovery
+	switch {
+	case rec.done:
+	case rec.victim == "":
+		n, err := strconv.Atoi(key)
+		if err != nil || !rec.choose(n) {
+			return
+		}
+		f.resources.RLock()
+		rec.check(f.resources.Map)
+		f.resources.RUnlock()
+		f.output.AddLines("")
+		f.output.AddLines(rec.plan()...)
+		if drbdadm.Blocking(rec.problems) {
+			f.rejectRecovery()
+			break
+		}
+		f.output.SetHelp("y: run | n: abort | j/k: scroll")
+	case f.running != nil:
+	case key == "y":
+		f.startRecovery()
+	case key == "n":
+		f.reset()
+		return
+	}
+	f.output.Update()
+}
+
+// rejectRecovery stops a recovery whose pre-flight checks found blocking
+// problems, its steps are recorded as rejected.
+func (f *FancyTUI) rejectRecovery() {
+	rec := f.recovery
+	rec.done = true
+
+	var blocking []drbdadm.Problem
+	for _, p := range rec.problems {
+		if p.Blocking {
+			blocking = append(blocking, p)
+		}
+	}
+	for _, st := range rec.steps {
+		entry := audit.NewEntry(st.argv(), []string{rec.res})
+		entry.Rejected = problemsText(blocking)
+		if err := f.audit.Record(entry); err != nil {
+			f.output.AddLines(colRed(err.Error(), true))
+		}
+	}
+	f.output.SetHelp("j/k: scroll | q: back")
+}
+
+// startRecovery runs the planned steps in the background until one fails,
+// reporting each of them. <esc> or q cancels the recovery.
+func (f *FancyTUI) startRecovery() {
+	rec := f.recovery
+	ctx, cancel := context.WithCancel(context.Background())
+	f.running = &runningCommand{cancel: cancel}
+	f.output.AddLines("")
+
+	go func() {
+		defer cancel()
+
+		var mu sync.Mutex
+		current := 0
+		done := make(chan struct{})
+		go func() {
+			for i, st := range rec.steps {
+				mu.Lock()
+				current = i + 1
+				mu.Unlock()
+				if !f.runRecoveryStep(ctx, i, st) {
+					break
+				}
+			}
+			close(done)
+		}()
+
+		f.showProgress(f.output.footer, func() string {
+			mu.Lock()
+			defer mu.Unlock()
+			return fmt.Sprintf("Running step %d of %d...", current, len(rec.steps))
+		}, done)
+
+		f.Lock()
+		defer f.Unlock()
+		f.running = nil
+		rec.done = true
+		f.output.SetHelp("j/k: scroll | q: back")
+		f.output.Update()
+	}()
+}
+
+// runRecoveryStep runs the i-th step of the recovery and reports whether it
+// succeeded. It locks the TUI only to report the step.
+func (f *FancyTUI) runRecoveryStep(ctx context.Context, i int, st commandStep) bool {
+	rec := f.recovery
+	f.Lock()
+	f.output.AddLines(fmt.Sprintf("%s step %d on %s: %s",
+		time.Now().Format("15:04:05"), i+1, rec.stepNode(st), st))
+	f.output.Update()
+	f.Unlock()
+
+	out, err := st.run(ctx)
+
+	f.Lock()
+	defer f.Unlock()
+	defer f.output.Update()
+
+	entry := audit.NewEntry(st.argv(), []string{rec.res})
+	entry.ConfirmationRequired = true
+	entry.SetResult(out, err)
+	if err := f.audit.Record(entry); err != nil {
+		f.output.AddLines("    " + colRed(err.Error(), true))
+	}
+	for _, l := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if l != "" {
+			f.output.AddLines("    " + l)
+		}
+	}
+	if err != nil {
+		f.output.AddLines(colRed(fmt.Sprintf("    failed: %v", err), true),
+			"", "Stopped, the remaining steps were not run.")
+		return false
+	}
+	f.output.AddLines("    " + setOK())
+	if i == len(rec.steps)-1 {
+		f.output.AddLines("", colGreen("Done, the victim resyncs from the survivors now.", true))
+	}
+	return true
+}
@@ -19,16 +19,20 @@
 package display
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/LINBIT/drbdtop/pkg/audit"
+	"github.com/LINBIT/drbdtop/pkg/drbdadm"
 	"github.com/LINBIT/drbdtop/pkg/resource"
 	"github.com/LINBIT/drbdtop/pkg/update"
-	drbdutils "github.com/LINBIT/godrbdutils"
 	"github.com/LINBIT/termui"
 )
 
@@ -59,6 +63,7 @@ type displayMode int
 const (
 	overview displayMode = iota
 	detail
+	output
 )
 
 type changeIdx int
@@ -80,33 +85,70 @@ type displayBuffer struct {
 
 var db displayBuffer
 
+// Player controls the playback of a recorded session.
+type Player interface {
+	TogglePause()
+	Step()
+	Faster()
+	Slower()
+	Seek(d time.Duration)
+	Status() string
+}
+
+// FancyTUI is the interactive TUI. termui runs every handler in a goroutine
+// of its own, so the handlers, the display updates and the commands running
+// in the background hold the mutex while they use the state of the TUI.
 type FancyTUI struct {
+	sync.Mutex
 	resources  *update.ResourceCollection
 	lastErr    []error
 	cmode      commandMode
 	dmode      displayMode
 	overview   *overView
 	detail     *detailView
+	output     *outputView
 	updateDisp chan struct{}
 	expert     bool
+	// The commands that can be run.
+	mode drbdadm.Mode
+	// Preview every command with a dry run before running it.
+	preview bool
+	// The view the output view returns to.
+	outputFrom displayMode
+	running    *runningCommand
+	results    *bulkResults
+	// Targets the command is retried on, instead of the selected ones.
+	retry    []drbdadm.Target
+	player   Player
+	recovery *splitBrainRecovery
+	// Addresses ssh connects to, by the node names of the DRBD configuration.
+	sshHosts map[string]string
+	audit    *audit.Log
+	// Set while the sort menu is open.
+	sorting bool
 }
 
-func NewFancyTUI(d time.Duration, expert bool) FancyTUI {
+func NewFancyTUI(d time.Duration, expert bool) *FancyTUI {
 	e := termui.Init()
 	if e != nil {
 		panic(e)
 	}
 
 	db.buf = make(map[string]update.ByRes)
-	return FancyTUI{
+	f := &FancyTUI{
 		resources:  update.NewResourceCollection(d),
 		cmode:      ex,
 		dmode:      overview,
 		overview:   NewOverView(),
 		detail:     NewDetailView(),
+		output:     NewOutputView(),
 		expert:     expert,
+		mode:       drbdadm.Admin,
+		audit:      &audit.Log{},
 		updateDisp: make(chan struct{}),
 	}
+	f.setSort(sortOrder{sortKey: sortKeys[0]})
+	return f
 }
 
 func (f *FancyTUI) SetVersion(v string) {
@@ -115,6 +157,52 @@ func (f *FancyTUI) SetVersion(v string) {
 	f.detail.header.Text = drbdtopversion
 }
 
+// SetPreview enables the dry-run preview of every command, which then has to
+// be confirmed, dangerous or not.
+func (f *FancyTUI) SetPreview(preview bool) {
+	f.preview = preview
+}
+
+// SetMode limits the commands that can be run to the ones mode allows.
+func (f *FancyTUI) SetMode(mode drbdadm.Mode) {
+	f.mode = mode
+	setMenuHelp(mode)
+	f.detail.footer.Text = detailHelp
+}
+
+// SetSSHHosts sets the addresses the split brain recovery connects to, by
+// the node names of the DRBD configuration.
+func (f *FancyTUI) SetSSHHosts(hosts map[string]string) {
+	f.sshHosts = hosts
+}
+
+// SetAuditLog sets the log the commands run from the TUI are recorded in.
+func (f *FancyTUI) SetAuditLog(l *audit.Log) {
+	f.audit = l
+}
+
+// SetPlayer enables the replay controls.
+func (f *FancyTUI) SetPlayer(p Player) {
+	f.player = p
+	unlockedHelp += replayHelp
+	f.overview.footer.Text = unlockedHelp
+}
+
+// showPlayerStatus adds the state of the replay to the header of the current view.
+func (f *FancyTUI) showPlayerStatus() {
+	if f.player == nil {
+		return
+	}
+	status := " - " + f.player.Status()
+	if f.dmode == overview {
+		f.overview.header.Text = drbdtopversion + status
+		termui.Render(f.overview.header)
+	} else if f.dmode == detail {
+		f.detail.header.Text = drbdtopversion + " - Details for " + f.detail.selres + status
+		termui.Render(f.detail.header)
+	}
+}
+
 func (f *FancyTUI) UpdateResources(event <-chan resource.Event, err <-chan error) {
 	for {
 		select {
@@ -144,6 +232,7 @@ func (f *FancyTUI) UpdateResources(event <-chan resource.Event, err <-chan error
 func (f *FancyTUI) UpdateDisp() {
 	for {
 		<-f.updateDisp
+		f.Lock()
 		f.resources.RLock()
 
 		db.Lock()
@@ -192,8 +281,13 @@ func (f *FancyTUI) UpdateDisp() {
 			f.overview.Update()
 		} else if f.dmode == detail {
 			f.detail.Update()
+			if f.cmode == ex && f.detail.targetChanged() {
+				f.detail.showTarget()
+			}
 		}
+		f.showPlayerStatus()
 		f.resources.RUnlock()
+		f.Unlock()
 	}
 }
 
@@ -204,7 +298,6 @@ func (f *FancyTUI) Display(event <-chan resource.Event, err <-chan error) {
 	f.overview.UpdateGUI()
 
 	go f.UpdateResources(event, err)
-	f.resources.OrderBy(update.DangerReverse, update.SizeReverse, update.Name)
 	go f.UpdateDisp()
 
 	termui.Loop()
@@ -229,11 +322,20 @@ func (f *FancyTUI) toggleLocked() {
 	}
 }
 
+// handle registers handler for path, it runs with the TUI locked.
+func (f *FancyTUI) handle(path string, handler func(termui.Event)) {
+	termui.Handle(path, func(e termui.Event) {
+		f.Lock()
+		defer f.Unlock()
+		handler(e)
+	})
+}
+
 func (f *FancyTUI) initHandlers() {
 	registerDefaultHandler := func(key string, p *termui.Par) {
-		termui.Handle("/sys/kbd/"+key, func(e termui.Event) {
+		f.handle("/sys/kbd/"+key, func(e termui.Event) {
 			if f.cmode == insert {
-				insertMode(e, p)
+				f.insertMode(e, p)
 				return
 			}
 			if f.dmode == overview {
@@ -244,19 +346,24 @@ func (f *FancyTUI) initHandlers() {
 				}
 			} else if f.dmode == detail {
 				f.detail.setWindow(e)
+			} else if f.dmode == output && f.recovery != nil {
+				f.recoveryKey(key)
 			}
 		})
 	}
 
 	registerCmdHandler := func(key string, p *termui.Par) {
-		termui.Handle("/sys/kbd/"+key, func(e termui.Event) {
+		f.handle("/sys/kbd/"+key, func(e termui.Event) {
 			if f.cmode == insert {
-				insertMode(e, p)
+				f.insertMode(e, p)
 				return
 			}
 
-			if f.dmode == overview {
-				if f.overview.locked {
+			if f.dmode == detail && f.cmode == command {
+				f.cmdMode(e, f.detail.footer)
+			} else if f.dmode == overview {
+				// A retried command is answered in the unlocked overview.
+				if (f.overview.locked || f.cmode == command) && f.mode != drbdadm.ReadOnly {
 					f.cmode = command
 					f.cmdMode(e, p)
 				} else if key == "f" {
@@ -264,24 +371,40 @@ func (f *FancyTUI) initHandlers() {
 				}
 			} else if f.dmode == detail {
 				f.detail.setWindow(e)
+			} else if f.dmode == output && f.recovery != nil {
+				f.recoveryKey(key)
+			} else if f.dmode == output && f.results != nil {
+				f.resultsKey(key)
+			} else if f.dmode == output && f.cmode == command {
+				f.previewKey(e)
 			}
 		})
 	}
 	/* THINK: find a better way */
-	defHandlers := "beghilotvwxz" + "BEFGHIJKLMNOQRTVWXYZ" + "0123456789" + "!§$%&()[],;-.:_+*~<>|"
+	defHandlers := "eghltw" + "BEFGIJKLMNOQTWXYZ" + "0123456789" + "!§$%&()[],;-.:_+*~<>|"
+	playerHandlers := "+-.<>[]"
+	if f.player != nil {
+		for _, h := range playerHandlers {
+			defHandlers = strings.Replace(defHandlers, string(h), "", -1)
+		}
+	}
 	for _, h := range defHandlers {
 		registerDefaultHandler(string(h), f.overview.footer)
 	}
 
-	cmdHandlers := "acdfmnprusy" + "ACDPUS"
+	cmdHandlers := "abcdfimnprsuvyz" + "ACDPSUV"
 	for _, h := range cmdHandlers {
 		registerCmdHandler(string(h), f.overview.footer)
 	}
 
 	/* "special" handlers that override the default behavior; don't forget to rm these from defHandlers */
-	termui.Handle("/sys/kbd/q", func(e termui.Event) {
+	f.handle("/sys/kbd/q", func(e termui.Event) {
 		if f.cmode == insert {
-			insertMode(e, f.overview.footer)
+			f.insertMode(e, f.overview.footer)
+			return
+		}
+		if f.running != nil {
+			f.running.cancel()
 			return
 		}
 		if f.dmode == overview {
@@ -289,50 +412,134 @@ func (f *FancyTUI) initHandlers() {
 		} else if f.dmode == detail {
 			f.dmode = overview
 			f.overview.UpdateGUI()
+		} else if f.dmode == output {
+			f.reset()
+		}
+	})
+
+	f.handle("/sys/kbd/x", func(e termui.Event) {
+		if f.cmode == insert {
+			f.insertMode(e, f.overview.footer)
+			return
+		}
+		if f.dmode == detail && f.cmode == ex && f.mode != drbdadm.ReadOnly {
+			f.cmode = command
+			f.detail.footer.Text = fmt.Sprintf("Command for %s: %s | <esc>: Abort command", f.detail.Target(), commandHelp)
+			termui.Render(f.detail.footer)
+		}
+	})
+
+	f.handle("/sys/kbd/o", func(e termui.Event) {
+		if f.cmode == insert {
+			f.insertMode(e, f.overview.footer)
+			return
+		}
+		if f.dmode == overview && f.cmode == ex && !f.overview.locked {
+			f.sorting = true
+			f.cmode = insert
+			f.overview.footer.Text = sortHelp()
+			termui.Render(f.overview.footer)
+		}
+	})
+
+	f.handle("/sys/kbd/H", func(e termui.Event) {
+		if f.cmode == insert {
+			f.insertMode(e, f.overview.footer)
+			return
+		}
+		if f.cmode == ex && (f.dmode == overview || f.dmode == detail) {
+			f.showHistory()
 		}
 	})
 
+	f.handle("/sys/kbd/R", func(e termui.Event) {
+		if f.cmode == insert {
+			f.insertMode(e, f.overview.footer)
+			return
+		}
+		f.resources.ResetStats()
+	})
+
+	/* REPLAY */
+	if f.player != nil {
+		registerPlayerHandler := func(key string, action func()) {
+			f.handle("/sys/kbd/"+key, func(e termui.Event) {
+				if f.cmode == insert {
+					if key != "<space>" {
+						f.insertMode(e, f.overview.footer)
+					}
+					return
+				}
+				action()
+				f.showPlayerStatus()
+			})
+		}
+
+		registerPlayerHandler("<space>", f.player.TogglePause)
+		registerPlayerHandler(".", f.player.Step)
+		registerPlayerHandler("+", f.player.Faster)
+		registerPlayerHandler("-", f.player.Slower)
+		registerPlayerHandler("<", func() { f.player.Seek(-10 * time.Second) })
+		registerPlayerHandler(">", func() { f.player.Seek(10 * time.Second) })
+		registerPlayerHandler("[", func() { f.player.Seek(-time.Minute) })
+		registerPlayerHandler("]", func() { f.player.Seek(time.Minute) })
+	}
+
 	/* MOVEMENT */
 	kbdDown := func(e termui.Event) {
 		if f.cmode == insert {
-			insertMode(e, f.overview.footer)
+			f.insertMode(e, f.overview.footer)
 			return
 		}
 
-		if f.dmode == overview {
+		if f.dmode == overview && f.cmode != command {
+			// The selection is the target of the command.
 			if !f.overview.locked {
 				f.setLocked()
 			}
 			f.overview.SetIdx(down)
+		} else if f.dmode == detail && f.cmode == ex {
+			f.detail.moveTarget(1)
+		} else if f.dmode == output {
+			f.output.Scroll(1)
 		}
 	}
 
-	termui.Handle("/sys/kbd/j", kbdDown)
-	termui.Handle("/sys/kbd/<down>", kbdDown)
+	f.handle("/sys/kbd/j", kbdDown)
+	f.handle("/sys/kbd/<down>", kbdDown)
 
 	kbdUp := func(e termui.Event) {
 		if f.cmode == insert {
-			insertMode(e, f.overview.footer)
+			f.insertMode(e, f.overview.footer)
 			return
 		}
 
-		if f.dmode == overview {
+		if f.dmode == overview && f.cmode != command {
+			// The selection is the target of the command.
 			if !f.overview.locked {
 				f.setLocked()
 			}
 			f.overview.SetIdx(up)
+		} else if f.dmode == detail && f.cmode == ex {
+			f.detail.moveTarget(-1)
+		} else if f.dmode == output {
+			f.output.Scroll(-1)
 		}
 	}
 
-	termui.Handle("/sys/kbd/k", kbdUp)
-	termui.Handle("/sys/kbd/<up>", kbdUp)
+	f.handle("/sys/kbd/k", kbdUp)
+	f.handle("/sys/kbd/<up>", kbdUp)
 
-	termui.Handle("/sys/kbd/<tab>", func(termui.Event) {
+	f.handle("/sys/kbd/<tab>", func(termui.Event) {
+		if f.running != nil {
+			return
+		}
 		f.cmode = ex
+		f.sorting = false
 		f.toggleLocked()
 	})
 
-	termui.Handle("/sys/kbd/<home>", func(termui.Event) {
+	f.handle("/sys/kbd/<home>", func(termui.Event) {
 		if f.cmode == insert {
 			return
 		}
@@ -345,7 +552,7 @@ func (f *FancyTUI) initHandlers() {
 		}
 	})
 
-	termui.Handle("/sys/kbd/<end>", func(termui.Event) {
+	f.handle("/sys/kbd/<end>", func(termui.Event) {
 		if f.cmode == insert {
 			return
 		}
@@ -358,7 +565,7 @@ func (f *FancyTUI) initHandlers() {
 		}
 	})
 
-	termui.Handle("/sys/kbd/<previous>", func(termui.Event) {
+	f.handle("/sys/kbd/<previous>", func(termui.Event) {
 		if f.cmode == insert {
 			return
 		}
@@ -371,7 +578,7 @@ func (f *FancyTUI) initHandlers() {
 		}
 	})
 
-	termui.Handle("/sys/kbd/<next>", func(termui.Event) {
+	f.handle("/sys/kbd/<next>", func(termui.Event) {
 		if f.cmode == insert {
 			return
 		}
@@ -385,7 +592,7 @@ func (f *FancyTUI) initHandlers() {
 	})
 
 	/* REST */
-	termui.Handle("/sys/kbd//", func(termui.Event) {
+	f.handle("/sys/kbd//", func(termui.Event) {
 		if f.cmode == ex {
 			if !f.overview.locked {
 				return
@@ -397,8 +604,8 @@ func (f *FancyTUI) initHandlers() {
 		termui.Render(f.overview.footer)
 	})
 
-	termui.Handle("/sys/kbd/<backspace>", func(termui.Event) {
-		if f.cmode == insert {
+	f.handle("/sys/kbd/<backspace>", func(termui.Event) {
+		if f.cmode == insert && !f.sorting {
 			// TODO: make this more clever
 			if len(f.overview.footer.Text) > len("Regex: ") {
 				f.overview.footer.Text = f.overview.footer.Text[:len(f.overview.footer.Text)-1]
@@ -408,11 +615,18 @@ func (f *FancyTUI) initHandlers() {
 		termui.Render(f.overview.footer)
 	})
 
-	termui.Handle("/sys/kbd/<escape>", func(termui.Event) {
+	f.handle("/sys/kbd/<escape>", func(termui.Event) {
+		if f.running != nil {
+			f.running.cancel()
+			return
+		}
 		f.reset()
 	})
 
-	termui.Handle("/sys/kbd/<enter>", func(termui.Event) {
+	f.handle("/sys/kbd/<enter>", func(termui.Event) {
+		if f.sorting {
+			return
+		}
 		if f.dmode == overview {
 			if f.cmode == insert {
 				defer func() {
@@ -446,13 +660,16 @@ func (f *FancyTUI) initHandlers() {
 				if f.overview.selres != "" {
 					f.detail.selres = f.overview.selres
 					f.dmode = detail
+					f.detail.targets = nil
+					f.detail.updateTargets()
 					f.detail.UpdateGUI()
+					f.detail.showTarget()
 				}
 			}
 		}
 	})
 
-	termui.Handle("/sys/wnd/resize", func(e termui.Event) {
+	f.handle("/sys/wnd/resize", func(e termui.Event) {
 		if f.dmode == overview {
 			f.overview.tblheight = termui.TermHeight() - f.overview.header.Height - f.overview.footer.Height
 			f.overview.tblwidth = termui.TermWidth()
@@ -460,24 +677,45 @@ func (f *FancyTUI) initHandlers() {
 			f.overview.tbl.Width = f.overview.tblwidth
 			f.overview.tbl.Height = f.overview.tblheight
 			f.overview.UpdateGUI()
+		} else if f.dmode == output {
+			f.output.UpdateGUI()
 		}
 	})
 }
 
-func insertMode(e termui.Event, p *termui.Par) {
+func (f *FancyTUI) insertMode(e termui.Event, p *termui.Par) {
 	k, _ := e.Data.(termui.EvtKbd)
+	if f.sorting {
+		f.chooseSort(k.KeyStr)
+		return
+	}
 	p.Text += k.KeyStr
 	termui.Render(p)
 }
 
 func (f *FancyTUI) reset() {
+	// Aborting a command in the detail view stays there.
+	inCommand := f.cmode == command
 	f.cmode = ex
+	f.sorting = false
 	commandstr = ""
 	commandFinished = false
+	f.retry = nil
 	if f.dmode == overview {
 		f.overview.setLockedStr()
-	} else if f.dmode == detail {
+	} else if f.dmode == detail && inCommand {
+		f.detail.showTarget()
+	} else if f.dmode == output && f.outputFrom == detail {
+		f.recovery = nil
+		f.results = nil
+		f.dmode = detail
+		f.detail.UpdateGUI()
+		f.detail.showTarget()
+	} else if f.dmode == detail || f.dmode == output {
+		f.recovery = nil
+		f.results = nil
 		f.dmode = overview
+		f.overview.setLockedStr()
 		f.overview.UpdateGUI()
 	}
 }
@@ -485,175 +723,392 @@ func (f *FancyTUI) reset() {
 func (f *FancyTUI) cmdMode(e termui.Event, p *termui.Par) {
 	k, _ := e.Data.(termui.EvtKbd)
 	keyStr := k.KeyStr
-	isDangerous := false
 	var confirmed confirmed = unknown
 
-	valid := true
-	switch keyStr {
-	case "d":
-		switch commandstr {
-		case "": // disk menu
-			p.Text = "a/d: attach/detach selected | A/D: attach/detach all"
-		default:
-			commandFinished = true
-		}
-	case "a":
-		switch commandstr {
-		case "": // adjust menu
-			p.Text = "a: adjust selected | A: adjust all"
-		default:
-			commandFinished = true
-		}
-	case "c":
-		switch commandstr {
-		case "": // connection menu
-			p.Text = "c/d/m: connect/disconnect/discard my data selected | C/D: connect/disconnect all"
-		case "c", "d", "m":
-			commandFinished = true
-		}
-	case "r":
-		switch commandstr {
-		case "": // role menu
-			p.Text = "p/f/s: primary/primary --force/secondary selected | P/S: primary/secondary all"
-		}
-	case "s":
-		switch commandstr {
-		case "": // state menu
-			p.Text = "u/d: up/down selected | U/D: up/down all"
-		default:
-			commandFinished = true
-		}
-	case "f":
-		switch commandstr {
-		case "r": // currently only valid if coming from role menu
-			commandFinished = true
-		default:
-			valid = false
-		}
-	case "m":
-		switch commandstr {
-		case "": // meta-data menu
-			p.Text = "c: create-md --force selected"
-		case "c":
-			commandFinished = true
+	if f.running != nil {
+		return
+	}
+
+	answering := commandFinished
+	if answering {
+		// Only the answer to the confirmation is left.
+		switch keyStr {
+		case "y", "n", "p":
 		default:
-			valid = false
+			return
 		}
-	case "y":
+	}
+
+	valid := true
+	switch {
+	case answering && keyStr == "y":
 		confirmed = yes
-	case "n":
+	case answering && keyStr == "n":
 		confirmed = no
-	case "A", "C", "D", "P", "S", "U", "p", "u":
+	case answering:
+		// "p", the preview is shown below.
+	case commandstr == "":
+		if m := findMenu(keyStr, f.mode); m != nil {
+			p.Text = m.help(f.mode) + " | <esc>: Abort command"
+		} else {
+			valid = false
+		}
+	case findEntry(commandstr, keyStr, f.mode) != nil:
 		commandFinished = true
 	default:
 		valid = false
 	}
 
-	if valid && confirmed == unknown {
+	if valid && !answering {
 		commandstr += keyStr
 	}
 
-	p.Text += " | <esc>: Abort command"
+	if commandFinished && commandstr == "cb" {
+		f.reset()
+		f.startSplitBrainRecovery()
+		return
+	}
 
 	defer termui.Render(p)
 	if commandFinished {
-		valid = true
-		cmd := drbdutils.Drbdadm
-		var res []string
-		if len(f.overview.tagres) > 0 {
-			for k := range f.overview.tagres {
-				res = append(res, k)
-			}
-		} else {
-			res = append(res, f.overview.selres)
-		}
-		var action drbdutils.Action
-		var arg []string
-		switch commandstr {
-		/* adjust */
-		case "aa", "aA":
-			action = drbdutils.Adjust
-		/* disk */
-		case "da", "dA":
-			action = drbdutils.Attach
-		case "dd", "dD":
-			action = drbdutils.Detach
-		/* connection */
-		case "cc", "cC":
-			action = drbdutils.Connect
-		case "cd", "cD":
-			action = drbdutils.Disconnect
-		case "cm":
-			action = drbdutils.Connect
-			arg = append(arg, "--discard-my-data")
-			isDangerous = true
-		/* role */
-		case "rp", "rP":
-			action = drbdutils.Primary
-		case "rf":
-			action = drbdutils.Primary
-			arg = append(arg, "--force")
-			isDangerous = true
-		case "rs", "rS":
-			action = drbdutils.Secondary
-		/* state */
-		case "sd", "sD":
-			action = drbdutils.Down
-		case "su", "sU":
-			action = drbdutils.Up
-		/* meta data */
-		case "mc":
-			action = drbdutils.Create_md
-			arg = append(arg, "--force")
-			isDangerous = true
-		default:
-			valid = false
-			p.Text = "Aborting: Your input was not a valid command!"
+		f.confirmCommand(keyStr, confirmed, p)
+	}
+}
+
+// confirmCommand checks the command entered in commandstr, asks for its
+// confirmation if needed and runs it. keyStr is the key that was pressed to
+// answer the confirmation, if any.
+func (f *FancyTUI) confirmCommand(keyStr string, confirmed confirmed, p *termui.Par) {
+	utilscmd, expanded, err := f.pendingCommand()
+	valid := err == nil
+	var warnings []drbdadm.Problem
+	if valid {
+		warnings = f.preflight(utilscmd)
+	}
+	// Warnings and targets the user didn't select are confirmed even by
+	// experts.
+	confirmationRequired := valid && (utilscmd.Action.Dangerous && !f.expert || f.preview || len(warnings) > 0 || expanded)
+	if !valid {
+		p.Text = err.Error()
+		if r, ok := err.(rejection); ok {
+			entry := audit.NewEntry(r.cmd.Argv(), commandTargets(r.cmd))
+			entry.Rejected = r.reason
+			f.record(entry, p)
 		}
+		confirmed = yes
+	} else if !confirmationRequired {
+		confirmed = yes
+	}
 
-		if !isDangerous || f.expert {
-			confirmed = yes
+	if confirmed == unknown {
+		if f.preview || keyStr == "p" {
+			f.showPreview(utilscmd, p)
+			return
+		}
+		if len(warnings) > 0 {
+			p.Text = fmt.Sprintf("%s Run '%s' anyway? (y/n, p: preview) ", colRed("Warning: "+problemsText(warnings), true), utilscmd)
+			return
 		}
-		utilscmd, _ := drbdutils.NewDrbdCmd(cmd, action, res, arg...)
-		if confirmed == unknown {
-			p.Text = fmt.Sprintf("Dangerous command: '%s'. Are you sure? (y/n) ", utilscmd)
+		if expanded {
+			p.Text = fmt.Sprintf("'%s' runs on every unconnected peer: %s. Are you sure? (y/n, p: preview) ",
+				utilscmd.Action, strings.Join(commandTargets(utilscmd), ", "))
 			return
-		} else if confirmed == no {
-			p.Text = fmt.Sprintf("Aborting '%s'.", utilscmd)
 		}
+		p.Text = fmt.Sprintf("Dangerous command: '%s'. Are you sure? (y/n, p: preview) ", utilscmd)
+		return
+	} else if confirmed == no {
+		p.Text = fmt.Sprintf("Aborting '%s'.", utilscmd)
+		entry := audit.NewEntry(utilscmd.Argv(), commandTargets(utilscmd))
+		entry.ConfirmationRequired = true
+		entry.Declined = true
+		f.record(entry, p)
+	}
 
-		utilscmdOK := false
-		if valid && confirmed == yes {
-			last := string(commandstr[1])
-			if last == strings.ToUpper(last) {
-				res = []string{"all"}
-			}
-			utilscmd.SetTimeout(10 * time.Second)
-			p.Text = fmt.Sprintf("Executing '%s'... ", utilscmd)
-			termui.Render(p)
-			if comb, err := utilscmd.CombinedOutput(); err != nil {
-				p.Text += fmt.Sprintf("%s", comb)
-			} else {
-				p.Text += setOK()
-				utilscmdOK = true
+	if valid && confirmed == yes {
+		if len(utilscmd.Targets) > 1 {
+			// Run them one by one, so that one failing doesn't hide the others.
+			f.startBulk(utilscmd.Split(), confirmationRequired, p)
+			return
+		}
+		entry := audit.NewEntry(utilscmd.Argv(), commandTargets(utilscmd))
+		entry.ConfirmationRequired = confirmationRequired
+		f.startCommand(utilscmd, entry, p)
+		return
+	}
+
+	// Nothing is run, give the user time to read why.
+	msg := p.Text
+	f.finishCommand()
+	tmpFooterMsg(p, msg, 4*time.Second)
+}
+
+// finishCommand leaves the command mode after a command was run or aborted.
+func (f *FancyTUI) finishCommand() {
+	/* in the best case the command fixed the resource, so remove the filter */
+	f.overview.filterDanger = false
+
+	if f.dmode == overview && f.overview.locked {
+		f.toggleLocked()
+	}
+	f.reset()
+}
+
+// rejection is why a valid command is not run.
+type rejection struct {
+	cmd    *drbdadm.Command
+	reason string
+}
+
+func (r rejection) Error() string {
+	return fmt.Sprintf("Aborting '%s': %s!", r.cmd, r.reason)
+}
+
+// pendingCommand returns the command entered in commandstr, run on the
+// selected target or the ones it is retried on, or why it can't be run.
+// expanded tells whether the selected resources were replaced by their
+// unconnected peers.
+func (f *FancyTUI) pendingCommand() (cmd *drbdadm.Command, expanded bool, err error) {
+	var targets []drbdadm.Target
+	if f.retry != nil {
+		targets = append(targets, f.retry...)
+	} else if f.dmode == detail {
+		targets = append(targets, f.detail.Target())
+	} else if len(f.overview.tagres) > 0 {
+		var res []string
+		for k := range f.overview.tagres {
+			res = append(res, k)
+		}
+		sort.Strings(res)
+		for _, r := range res {
+			targets = append(targets, drbdadm.Target{Resource: r})
+		}
+	} else {
+		targets = append(targets, drbdadm.Target{Resource: f.overview.selres})
+	}
+	var entry *menuEntry
+	if len(commandstr) == 2 {
+		entry = findEntry(commandstr[:1], commandstr[1:], f.mode)
+	}
+	if entry == nil {
+		return nil, false, errors.New("Aborting: Your input was not a valid command!")
+	}
+	action := entry.action
+
+	last := string(commandstr[len(commandstr)-1])
+	if last == strings.ToUpper(last) && f.retry == nil {
+		targets = []drbdadm.Target{{Resource: "all"}}
+	}
+	if action.Scopes&drbdadm.ResourceScope == 0 && targets[0].Scope() == drbdadm.ResourceScope {
+		// Resources stand for their unconnected peers.
+		peers := f.unconnectedPeers(targets)
+		if len(peers) == 0 {
+			return nil, false, rejection{drbdadm.New(action, targets...), "there are no unconnected peers"}
+		}
+		targets = peers
+		expanded = true
+	}
+	for _, t := range targets {
+		if !action.Allows(t) {
+			return nil, false, rejection{drbdadm.New(action, targets...), fmt.Sprintf("'%s' can't be run on %s", action, t)}
+		}
+	}
+
+	cmd = drbdadm.New(action, targets...)
+	var blocking []drbdadm.Problem
+	for _, problem := range f.preflight(cmd) {
+		if problem.Blocking {
+			blocking = append(blocking, problem)
+		}
+	}
+	if len(blocking) > 0 {
+		return nil, false, rejection{cmd, problemsText(blocking)}
+	}
+
+	return cmd, expanded, nil
+}
+
+// preflight returns the problems cmd would cause in the current state of its
+// resources. All known resources are checked, not only the displayed ones.
+func (f *FancyTUI) preflight(cmd *drbdadm.Command) []drbdadm.Problem {
+	f.resources.RLock()
+	defer f.resources.RUnlock()
+
+	return drbdadm.Preflight(cmd, f.resources.Map)
+}
+
+// problemsText describes problems in a single line.
+func problemsText(problems []drbdadm.Problem) string {
+	var s []string
+	for _, problem := range problems {
+		s = append(s, problem.String())
+	}
+	return strings.Join(s, "; ")
+}
+
+// commandTargets returns the targets of cmd for the audit log.
+func commandTargets(cmd *drbdadm.Command) []string {
+	var targets []string
+	for _, t := range cmd.Targets {
+		targets = append(targets, t.String())
+	}
+	return targets
+}
+
+// record writes e to the audit log, a failure is shown in p.
+func (f *FancyTUI) record(e audit.Entry, p *termui.Par) {
+	if err := f.audit.Record(e); err != nil {
+		p.Text += " " + colRed(err.Error(), true)
+	}
+}
+
+// showOutput switches to the output view, which returns to the current view.
+func (f *FancyTUI) showOutput() {
+	if f.dmode != output {
+		f.outputFrom = f.dmode
+	}
+	f.dmode = output
+	f.output.UpdateGUI()
+}
+
+// showHistory lists the commands run in this session.
+func (f *FancyTUI) showHistory() {
+	var lines []string
+	for _, e := range f.audit.History() {
+		status := colGreen(e.Status(), false)
+		if e.Status() != "ok" {
+			status = colRed(e.Status(), false)
+		}
+		line := fmt.Sprintf("%s %s: %s", e.Time.Format("15:04:05"), strings.Join(e.Argv, " "), status)
+		if e.ConfirmationRequired {
+			line += " (confirmation required)"
+		}
+		lines = append(lines, line)
+		for _, l := range strings.Split(strings.TrimSpace(e.Output), "\n") {
+			if l != "" {
+				lines = append(lines, "    "+l)
 			}
 		}
+	}
+	if len(lines) == 0 {
+		lines = append(lines, "No commands were run yet.")
+	}
 
-		// hard sleep here, IMO it makes more sense than tmpFooterMsg()
-		termui.Render(p)
+	f.output.SetText("Command history", lines)
+	f.output.SetHelp("j/k: scroll | q: back")
+	f.showOutput()
+	// Show the latest commands.
+	f.output.Scroll(len(lines))
+}
 
-		sl := 4 * time.Second // give user time to read the error message
-		if utilscmdOK {
-			sl = 2 * time.Second // user is happy, make time shorter
+// showPreview runs a dry run of cmd in the background, showing its progress
+// in p, then shows what drbdadm would do and waits for the confirmation.
+// <esc> or q aborts cmd.
+func (f *FancyTUI) showPreview(cmd *drbdadm.Command, p *termui.Par) {
+	dry := *cmd
+	dry.DryRun = true
+	dry.Timeout = 10 * time.Second
+	ctx, cancel := context.WithCancel(context.Background())
+	f.running = &runningCommand{cancel: cancel}
+
+	go func() {
+		defer cancel()
+
+		var out []byte
+		var err error
+		done := make(chan struct{})
+		go func() {
+			out, err = dry.Run(ctx)
+			close(done)
+		}()
+
+		f.showProgress(p, func() string {
+			return fmt.Sprintf("Previewing '%s'...", cmd)
+		}, done)
+
+		f.Lock()
+		defer f.Unlock()
+		f.running = nil
+		if err == drbdadm.ErrCanceled {
+			p.Text = fmt.Sprintf("Aborting '%s'.", cmd)
+			entry := audit.NewEntry(cmd.Argv(), commandTargets(cmd))
+			entry.ConfirmationRequired = true
+			entry.Declined = true
+			f.record(entry, p)
+			msg := p.Text
+			f.finishCommand()
+			tmpFooterMsg(p, msg, 4*time.Second)
+			return
 		}
-		time.Sleep(sl)
+		f.previewDone(cmd, out, err)
+	}()
+}
 
-		/* in the best case the command fixed the resource, so remove the filter */
-		f.overview.filterDanger = false
+// previewDone shows the output of the dry run of cmd.
+func (f *FancyTUI) previewDone(cmd *drbdadm.Command, out []byte, err error) {
+	var lines []string
+	if warnings := f.preflight(cmd); len(warnings) > 0 {
+		for _, w := range warnings {
+			lines = append(lines, colRed("Warning: "+w.String(), true))
+		}
+		lines = append(lines, "")
+	}
+	lines = append(lines, strings.Split(strings.TrimRight(string(out), "\n"), "\n")...)
+	if err != nil {
+		lines = append(lines, "", colRed(fmt.Sprintf("The dry run failed: %v", err), true))
+	}
+	f.output.SetText(fmt.Sprintf("Dry run of '%s'", cmd), lines)
+	help := "y: run | n: abort | j/k: scroll"
+	if cmd.Action.Dangerous {
+		help = colRed("Dangerous command!", true) + " " + help
+	}
+	f.output.SetHelp(help)
+	f.showOutput()
+}
 
-		f.toggleLocked()
-		f.reset()
+// previewKey passes the answer to a preview on to the command.
+func (f *FancyTUI) previewKey(e termui.Event) {
+	k, _ := e.Data.(termui.EvtKbd)
+	if k.KeyStr != "y" && k.KeyStr != "n" {
+		return
+	}
+
+	f.dmode = f.outputFrom
+	p := f.overview.footer
+	if f.dmode == detail {
+		f.detail.UpdateGUI()
+		p = f.detail.footer
+	} else {
+		f.overview.UpdateGUI()
+	}
+	f.cmdMode(e, p)
+}
+
+// unconnectedPeers returns the connections of the resources res that are not
+// connected.
+func (f *FancyTUI) unconnectedPeers(res []drbdadm.Target) []drbdadm.Target {
+	f.resources.RLock()
+	defer f.resources.RUnlock()
+
+	var peers []drbdadm.Target
+	for _, r := range res {
+		b, ok := f.resources.Map[r.Resource]
+		if !ok {
+			continue
+		}
+		var names []string
+		b.RLock()
+		for name, c := range b.Connections {
+			if c.ConnectionStatus != "Connected" {
+				names = append(names, name)
+			}
+		}
+		b.RUnlock()
+		sort.Strings(names)
+		for _, name := range names {
+			peers = append(peers, drbdadm.Target{Resource: r.Resource, Peer: name})
+		}
 	}
+	return peers
 }
 
 func tmpFooterMsg(f *termui.Par, t string, d time.Duration) {
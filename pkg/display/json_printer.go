@@ -0,0 +1,93 @@
+/*
+ *drbdtop - statistics for DRBD
+ *Copyright © 2017 Hayley Swimelar and Roland Kammerer
+ *
+ *This program is free software; you can redistribute it and/or modify
+ *it under the terms of the GNU General Public License as published by
+ *the Free Software Foundation; either version 2 of the License, or
+ *(at your option) any later version.
+ *
+ *This program is distributed in the hope that it will be useful,
+ *but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ *GNU General Public License for more details.
+ *
+ *You should have received a copy of the GNU General Public License
+ *along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package display
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/LINBIT/drbdtop/pkg/resource"
+	"github.com/LINBIT/drbdtop/pkg/snapshot"
+	"github.com/LINBIT/drbdtop/pkg/update"
+)
+
+// JSONPrinter prints a snapshot of all resources as one JSON document per line
+// for every display update.
+type JSONPrinter struct {
+	resources *update.ResourceCollection
+	out       io.Writer
+	// Print a single snapshot and return.
+	once bool
+	// Input is read from a file, which only makes sense to print once it is consumed completely.
+	fromFile bool
+}
+
+// NewJSONPrinter returns a JSONPrinter writing to stdout. If once is set, it
+// returns after printing the first snapshot.
+func NewJSONPrinter(d time.Duration, once bool) JSONPrinter {
+	return JSONPrinter{
+		resources: update.NewResourceCollection(d),
+		out:       os.Stdout,
+		once:      once,
+		fromFile:  d == 0,
+	}
+}
+
+// Display prints snapshots until the input is exhausted.
+func (j *JSONPrinter) Display(event <-chan resource.Event, err <-chan error) {
+	j.resources.OrderBy(update.Name)
+	enc := json.NewEncoder(j.out)
+
+	print := func() {
+		j.resources.UpdateList()
+		if e := enc.Encode(snapshot.New(j.resources)); e != nil {
+			fmt.Fprintln(os.Stderr, e)
+		}
+	}
+
+	for {
+		select {
+		case evt := <-event:
+			switch evt.Target {
+			case resource.EOF:
+				if j.once {
+					print()
+				}
+				return
+			case resource.DisplayEvent:
+				if j.once && j.fromFile {
+					continue
+				}
+				print()
+				if j.once {
+					return
+				}
+			case resource.PruneEvent:
+				j.resources.Prune(evt)
+			default:
+				j.resources.Update(evt)
+			}
+		case e := <-err:
+			fmt.Fprintln(os.Stderr, e)
+		}
+	}
+}
@@ -179,6 +179,9 @@ func printLocalDisk(r *update.ByRes) {
 			convert.KiB2Human(float64(v.Size)),
 			convert.KiB2Human(float64(v.ReadKiB.Total)), convert.KiB2Human(v.ReadKiB.PerSecond),
 			convert.KiB2Human(float64(v.WrittenKiB.Total)), convert.KiB2Human(v.WrittenKiB.PerSecond))
+		fmt.Printf("\n\t\t\tread/Sec 1m/5m/15m:%s written/Sec 1m/5m/15m:%s ",
+			rateAverages(v.ReadKiB.Avg1m, v.ReadKiB.Avg5m, v.ReadKiB.Avg15m),
+			rateAverages(v.WrittenKiB.Avg1m, v.WrittenKiB.Avg5m, v.WrittenKiB.Avg15m))
 
 		fmt.Printf("\n")
 	}
@@ -213,6 +216,27 @@ func printConn(c *resource.Connection) {
 	}
 
 	fmt.Printf("\n")
+
+	if c.SplitBrain != nil && c.SplitBrain.Confirmed {
+		color.New(color.FgHiRed).Printf("\t\tSplit brain since %s: %s\n",
+			c.SplitBrain.Since.Format("2006-01-02 15:04:05"), c.SplitBrain)
+	}
+
+	var pathKeys []string
+	for k := range c.Paths {
+		pathKeys = append(pathKeys, k)
+	}
+	sort.Strings(pathKeys)
+	for _, k := range pathKeys {
+		p := c.Paths[k]
+		fmt.Printf("\t\tpath %s -> %s: ", p.Local, p.Peer)
+		if p.Established {
+			color.New(color.FgHiGreen).Printf("established")
+		} else {
+			fmt.Printf("not established")
+		}
+		fmt.Printf("\n")
+	}
 }
 
 func printPeerDev(r *update.ByRes, conn string) {
@@ -245,6 +269,10 @@ func printPeerDev(r *update.ByRes, conn string) {
 				(float64(v.OutOfSyncKiB.Current)/float64(r.Device.Volumes[k].Size))*100)
 		}
 
+		if v.Resync.Active {
+			fmt.Printf("\n\t\t\t%s", resyncProgress(&v.Resync))
+		}
+
 		if v.DiskState != "UpToDate" {
 			c := color.New(color.FgHiYellow)
 			c.Printf("\n\t\t\t%s", v.DiskState)
@@ -254,41 +282,56 @@ func printPeerDev(r *update.ByRes, conn string) {
 
 		fmt.Printf("\n")
 
-		fmt.Printf("\t\t\tSent: total:%s Per/Sec:%s\n",
-			convert.KiB2Human(float64(v.SentKiB.Total)), convert.KiB2Human(v.SentKiB.PerSecond))
+		fmt.Printf("\t\t\tSent: total:%s Per/Sec:%s 1m/5m/15m:%s\n",
+			convert.KiB2Human(float64(v.SentKiB.Total)), convert.KiB2Human(v.SentKiB.PerSecond),
+			rateAverages(v.SentKiB.Avg1m, v.SentKiB.Avg5m, v.SentKiB.Avg15m))
 
-		fmt.Printf("\t\t\tReceived: total:%s Per/Sec:%s\n",
-			convert.KiB2Human(float64(v.ReceivedKiB.Total)), convert.KiB2Human(v.ReceivedKiB.PerSecond))
+		fmt.Printf("\t\t\tReceived: total:%s Per/Sec:%s 1m/5m/15m:%s\n",
+			convert.KiB2Human(float64(v.ReceivedKiB.Total)), convert.KiB2Human(v.ReceivedKiB.PerSecond),
+			rateAverages(v.ReceivedKiB.Avg1m, v.ReceivedKiB.Avg5m, v.ReceivedKiB.Avg15m))
 
 		oosCl := dangerColor(v.OutOfSyncKiB.Current / uint64(1024)).SprintFunc()
 		oosAvgCl := dangerColor(uint64(v.OutOfSyncKiB.Avg) / uint64(1024)).SprintFunc()
 		oosMinCl := dangerColor(v.OutOfSyncKiB.Min / uint64(1024)).SprintFunc()
 		oosMaxCl := dangerColor(v.OutOfSyncKiB.Max / uint64(1024)).SprintFunc()
-		fmt.Printf("\t\t\tOutOfSync: current:%s average:%s min:%s max:%s\n",
+		oosP := v.OutOfSyncKiB.Percentiles()
+		oosP99Cl := dangerColor(oosP.P99 / uint64(1024)).SprintFunc()
+		fmt.Printf("\t\t\tOutOfSync: current:%s average:%s min:%s max:%s p50/p95/p99:%s/%s/%s\n",
 			oosCl(convert.KiB2Human(float64(v.OutOfSyncKiB.Current))),
 			oosAvgCl(convert.KiB2Human(v.OutOfSyncKiB.Avg)),
 			oosMinCl(convert.KiB2Human(float64(v.OutOfSyncKiB.Min))),
-			oosMaxCl(convert.KiB2Human(float64(v.OutOfSyncKiB.Max))))
+			oosMaxCl(convert.KiB2Human(float64(v.OutOfSyncKiB.Max))),
+			convert.KiB2Human(float64(oosP.P50)),
+			convert.KiB2Human(float64(oosP.P95)),
+			oosP99Cl(convert.KiB2Human(float64(oosP.P99))))
 
 		penCl := dangerColor(v.PendingWrites.Current).SprintFunc()
 		penAvgCl := dangerColor(uint64(v.PendingWrites.Avg)).SprintFunc()
 		penMinCl := dangerColor(v.PendingWrites.Min).SprintFunc()
 		penMaxCl := dangerColor(v.PendingWrites.Max).SprintFunc()
-		fmt.Printf("\t\t\tPendingWrites: current:%s average:%s min:%s max:%s\n",
+		penP := v.PendingWrites.Percentiles()
+		penP99Cl := dangerColor(penP.P99).SprintFunc()
+		fmt.Printf("\t\t\tPendingWrites: current:%s average:%s min:%s max:%s p50/p95/p99:%d/%d/%s\n",
 			penCl(v.PendingWrites.Current),
 			penAvgCl(fmt.Sprintf("%.1f", v.PendingWrites.Avg)),
 			penMinCl(v.PendingWrites.Min),
-			penMaxCl(v.PendingWrites.Max))
+			penMaxCl(v.PendingWrites.Max),
+			penP.P50, penP.P95,
+			penP99Cl(penP.P99))
 
 		unAckCl := dangerColor(v.UnackedWrites.Current).SprintFunc()
 		unAckAvgCl := dangerColor(uint64(v.UnackedWrites.Avg)).SprintFunc()
 		unAckMinCl := dangerColor(v.UnackedWrites.Min).SprintFunc()
 		unAckMaxCl := dangerColor(v.UnackedWrites.Max).SprintFunc()
-		fmt.Printf("\t\t\tUnackedWrites: current:%s average:%s min:%s max:%s\n",
+		unAckP := v.UnackedWrites.Percentiles()
+		unAckP99Cl := dangerColor(unAckP.P99).SprintFunc()
+		fmt.Printf("\t\t\tUnackedWrites: current:%s average:%s min:%s max:%s p50/p95/p99:%d/%d/%s\n",
 			unAckCl(v.UnackedWrites.Current),
 			unAckAvgCl(fmt.Sprintf("%.1f", v.UnackedWrites.Avg)),
 			unAckMinCl(v.UnackedWrites.Min),
-			unAckMaxCl(v.UnackedWrites.Max))
+			unAckMaxCl(v.UnackedWrites.Max),
+			unAckP.P50, unAckP.P95,
+			unAckP99Cl(unAckP.P99))
 
 		fmt.Printf("\n")
 	}
@@ -26,6 +26,10 @@ import (
 	"os/exec"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/LINBIT/drbdtop/pkg/convert"
+	"github.com/LINBIT/drbdtop/pkg/resource"
 )
 
 type version struct {
@@ -161,3 +165,81 @@ func dmesg(res string) ([]string, error) {
 
 	return strings.Split(string(buf), "\n"), nil
 }
+
+// resyncProgress describes the progress of a resync in a single line.
+func resyncProgress(r *resource.Resync) string {
+	s := fmt.Sprintf("resync %.1f%% done (%s of %s)", r.Done(),
+		convert.KiB2Human(float64(r.TotalKiB-r.RemainingKiB)), convert.KiB2Human(float64(r.TotalKiB)))
+
+	if r.Paused {
+		s += ", paused"
+	} else if r.ETA > 0 {
+		s += fmt.Sprintf(" at %s/s, ETA %s", convert.KiB2Human(r.KiBPerSecond), r.ETA.Round(time.Second))
+	} else {
+		s += ", ETA unknown"
+	}
+
+	return s + fmt.Sprintf(", started %s", r.StartTime.Format("2006-01-02 15:04:05"))
+}
+
+// resyncSlower reports whether a is expected to finish after b. Resyncs
+// without an ETA are the slowest.
+func resyncSlower(a, b *resource.Resync) bool {
+	if b.ETA == 0 {
+		return false
+	}
+	return a.ETA == 0 || a.ETA > b.ETA
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// optKiB2Human is convert.KiB2Human for values older DRBD versions do not report.
+func optKiB2Human(o resource.OptUint64) string {
+	if !o.Present {
+		return "n/a"
+	}
+	return convert.KiB2Human(float64(o.Value))
+}
+
+// resyncStats describes the resync counters reported by DRBD 9, it is empty
+// if there are none.
+func resyncStats(r *resource.ResyncStats) string {
+	var parts []string
+
+	if r.Done.Present {
+		parts = append(parts, fmt.Sprintf("done:%.2f%%", r.Done.Value))
+	}
+	if r.ETA.Present {
+		parts = append(parts, fmt.Sprintf("eta:%s", r.ETA.Value))
+	}
+	if r.WantKiBPerSecond.Present {
+		parts = append(parts, fmt.Sprintf("want:%s/s", convert.KiB2Human(float64(r.WantKiBPerSecond.Value))))
+	}
+	if r.TotalKiB.Present {
+		parts = append(parts, fmt.Sprintf("total:%s", convert.KiB2Human(float64(r.TotalKiB.Value))))
+	}
+	if r.SameChecksumKiB.Present {
+		parts = append(parts, fmt.Sprintf("same checksum:%s", convert.KiB2Human(float64(r.SameChecksumKiB.Value))))
+	}
+	if r.SinceStart.Present {
+		parts = append(parts, fmt.Sprintf("running:%s", r.SinceStart.Value))
+	}
+	if r.Paused.Present {
+		parts = append(parts, fmt.Sprintf("paused:%s", r.Paused.Value))
+	}
+	if r.Interval.Present {
+		parts = append(parts, fmt.Sprintf("interval:%s", r.Interval.Value))
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// rateAverages formats the 1, 5 and 15 minute averages of a rate in KiB/s.
+func rateAverages(avg1m, avg5m, avg15m float64) string {
+	return convert.KiB2Human(avg1m) + "/" + convert.KiB2Human(avg5m) + "/" + convert.KiB2Human(avg15m)
+}
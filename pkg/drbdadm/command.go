@@ -0,0 +1,272 @@
+/*
+ *drbdtop - statistics for DRBD
+ *Copyright © 2017 Hayley Swimelar and Roland Kammerer
+ *
+ *This program is free software; you can redistribute it and/or modify
+ *it under the terms of the GNU General Public License as published by
+ *the Free Software Foundation; either version 2 of the License, or
+ *(at your option) any later version.
+ *
+ *This program is distributed in the hope that it will be useful,
+ *but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ *GNU General Public License for more details.
+ *
+ *You should have received a copy of the GNU General Public License
+ *along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package drbdadm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Action is a drbdadm command that can be run on DRBD resources.
+type Action struct {
+	// The drbdadm subcommand, e.g. "invalidate-remote".
+	Name string
+	// Options that are always passed, e.g. "--force".
+	Options []string
+	// Dangerous actions can destroy data or block applications, they are only
+	// run after confirmation.
+	Dangerous bool
+	// The kinds of Targets the action can be run on.
+	Scopes Scope
+	// Operators can run the action, only admins otherwise.
+	Operator bool
+}
+
+func (a Action) String() string {
+	return strings.Join(append([]string{a.Name}, a.Options...), " ")
+}
+
+// Scope is a set of kinds of Targets.
+type Scope uint
+
+// Kinds of Targets.
+const (
+	ResourceScope Scope = 1 << iota
+	ConnectionScope
+	VolumeScope
+	PeerVolumeScope
+)
+
+const (
+	diskScopes       = ResourceScope | VolumeScope
+	connectionScopes = ResourceScope | ConnectionScope
+	peerDeviceScopes = ResourceScope | ConnectionScope | VolumeScope | PeerVolumeScope
+)
+
+// Actions offered by drbdtop.
+var (
+	Adjust           = Action{Name: "adjust", Scopes: ResourceScope, Operator: true}
+	Attach           = Action{Name: "attach", Scopes: diskScopes, Operator: true}
+	Detach           = Action{Name: "detach", Scopes: diskScopes}
+	Connect          = Action{Name: "connect", Scopes: connectionScopes, Operator: true}
+	Disconnect       = Action{Name: "disconnect", Scopes: connectionScopes}
+	DiscardMyData    = Action{Name: "connect", Options: []string{"--discard-my-data"}, Dangerous: true, Scopes: connectionScopes}
+	Primary          = Action{Name: "primary", Scopes: ResourceScope}
+	ForcePrimary     = Action{Name: "primary", Options: []string{"--force"}, Dangerous: true, Scopes: ResourceScope}
+	Secondary        = Action{Name: "secondary", Scopes: ResourceScope}
+	Up               = Action{Name: "up", Scopes: ResourceScope, Operator: true}
+	Down             = Action{Name: "down", Scopes: ResourceScope}
+	CreateMD         = Action{Name: "create-md", Options: []string{"--force"}, Dangerous: true, Scopes: diskScopes}
+	Verify           = Action{Name: "verify", Scopes: peerDeviceScopes, Operator: true}
+	Invalidate       = Action{Name: "invalidate", Dangerous: true, Scopes: diskScopes}
+	InvalidateRemote = Action{Name: "invalidate-remote", Dangerous: true, Scopes: peerDeviceScopes}
+	PauseSync        = Action{Name: "pause-sync", Scopes: peerDeviceScopes, Operator: true}
+	ResumeSync       = Action{Name: "resume-sync", Scopes: peerDeviceScopes, Operator: true}
+	Resize           = Action{Name: "resize", Dangerous: true, Scopes: diskScopes}
+	SuspendIO        = Action{Name: "suspend-io", Dangerous: true, Scopes: diskScopes}
+	ResumeIO         = Action{Name: "resume-io", Scopes: diskScopes, Operator: true}
+	NewCurrentUUID   = Action{Name: "new-current-uuid", Dangerous: true, Scopes: diskScopes}
+	ForgetPeer       = Action{Name: "forget-peer", Dangerous: true, Scopes: ConnectionScope}
+)
+
+// Allows reports whether the action can be run on t.
+func (a Action) Allows(t Target) bool {
+	return a.Scopes&t.Scope() != 0
+}
+
+// Target is what a Command is run on: a resource, one of its volumes, its
+// connection to a peer, or a volume of that connection.
+type Target struct {
+	Resource string
+	// Peer is the name of the connection, empty for the resource or a local volume.
+	Peer   string
+	Volume string
+}
+
+// String returns the target as drbdadm expects it, e.g. "r0:beta/1".
+func (t Target) String() string {
+	s := t.Resource
+	if t.Peer != "" {
+		s += ":" + t.Peer
+	}
+	if t.Volume != "" {
+		s += "/" + t.Volume
+	}
+	return s
+}
+
+// Scope returns the kind of the target.
+func (t Target) Scope() Scope {
+	switch {
+	case t.Peer != "" && t.Volume != "":
+		return PeerVolumeScope
+	case t.Peer != "":
+		return ConnectionScope
+	case t.Volume != "":
+		return VolumeScope
+	}
+	return ResourceScope
+}
+
+// Errors of Commands that were killed.
+var (
+	ErrCanceled = errors.New("canceled")
+	ErrTimeout  = errors.New("timed out")
+)
+
+// Command is an Action applied to one or more targets.
+type Command struct {
+	Action  Action
+	Targets []Target
+	// Time the command may run before it is killed, no limit if zero.
+	Timeout time.Duration
+	// Only print the drbdsetup and drbdmeta calls the command would make.
+	DryRun bool
+}
+
+// New returns a Command running a on targets.
+func New(a Action, targets ...Target) *Command {
+	return &Command{Action: a, Targets: targets}
+}
+
+// Argv returns the command line of the Command.
+func (c *Command) Argv() []string {
+	argv := []string{"drbdadm"}
+	if c.DryRun {
+		argv = append(argv, "-d")
+	}
+	argv = append(argv, c.Action.Name)
+	argv = append(argv, c.Action.Options...)
+	for _, t := range c.Targets {
+		argv = append(argv, t.String())
+	}
+	return argv
+}
+
+func (c *Command) String() string {
+	return strings.Join(c.Argv(), " ")
+}
+
+// CombinedOutput runs the Command and returns its combined stdout and stderr.
+func (c *Command) CombinedOutput() ([]byte, error) {
+	return c.Run(context.Background())
+}
+
+// Run runs the Command until it exits or ctx is done, and returns its
+// combined stdout and stderr.
+func (c *Command) Run(ctx context.Context) ([]byte, error) {
+	if c.Timeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	argv := c.Argv()
+	cmd := exec.Command(argv[0], argv[1:]...)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	// drbdadm runs drbdsetup and drbdmeta, which have to be killed along with
+	// it, they keep the output open otherwise.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	select {
+	case err := <-done:
+		return out.Bytes(), err
+	case <-ctx.Done():
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-done
+		if ctx.Err() == context.DeadlineExceeded {
+			return out.Bytes(), ErrTimeout
+		}
+		return out.Bytes(), ErrCanceled
+	}
+}
+
+// Split returns a Command for each target of c.
+func (c *Command) Split() []*Command {
+	var cmds []*Command
+	for _, t := range c.Targets {
+		cmd := *c
+		cmd.Targets = []Target{t}
+		cmds = append(cmds, &cmd)
+	}
+	return cmds
+}
+
+// Result is the outcome of running a Command.
+type Result struct {
+	Output []byte
+	Err    error
+}
+
+// RunAll runs cmds, at most parallel of them at a time, and returns their
+// Results in the same order. done, if not nil, is called for every Command
+// that is done, one at a time. Commands that are not started yet when ctx is
+// done fail with ErrCanceled.
+func RunAll(ctx context.Context, cmds []*Command, parallel int, done func(i int, r Result)) []Result {
+	results := make([]Result, len(cmds))
+	slots := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+
+	for i, c := range cmds {
+		wg.Add(1)
+		go func(i int, c *Command) {
+			defer wg.Done()
+
+			var r Result
+			select {
+			case slots <- struct{}{}:
+				if ctx.Err() != nil {
+					r.Err = ErrCanceled
+				} else {
+					r.Output, r.Err = c.Run(ctx)
+				}
+				<-slots
+			case <-ctx.Done():
+				r.Err = ErrCanceled
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			results[i] = r
+			if done != nil {
+				done(i, r)
+			}
+		}(i, c)
+	}
+
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,206 @@
+/*
+ *drbdtop - statistics for DRBD
+ *Copyright © 2017 Hayley Swimelar and Roland Kammerer
+ *
+ *This program is free software; you can redistribute it and/or modify
+ *it under the terms of the GNU General Public License as published by
+ *the Free Software Foundation; either version 2 of the License, or
+ *(at your option) any later version.
+ *
+ *This program is distributed in the hope that it will be useful,
+ *but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ *GNU General Public License for more details.
+ *
+ *You should have received a copy of the GNU General Public License
+ *along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package drbdadm
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestArgv(t *testing.T) {
+	r0 := Target{Resource: "r0"}
+	var tests = []struct {
+		cmd  *Command
+		argv []string
+	}{
+		{New(Adjust, r0), []string{"drbdadm", "adjust", "r0"}},
+		{New(Verify, r0, Target{Resource: "r1"}), []string{"drbdadm", "verify", "r0", "r1"}},
+		{New(DiscardMyData, r0), []string{"drbdadm", "connect", "--discard-my-data", "r0"}},
+		{New(InvalidateRemote, Target{Resource: "r0", Peer: "beta"}), []string{"drbdadm", "invalidate-remote", "r0:beta"}},
+		{New(CreateMD, Target{Resource: "all"}), []string{"drbdadm", "create-md", "--force", "all"}},
+		{&Command{Action: Adjust, Targets: []Target{r0}, DryRun: true}, []string{"drbdadm", "-d", "adjust", "r0"}},
+	}
+
+	for _, tt := range tests {
+		if argv := tt.cmd.Argv(); !reflect.DeepEqual(argv, tt.argv) {
+			t.Errorf("Expected %v, got %v", tt.argv, argv)
+		}
+	}
+
+	if s := New(ForcePrimary, r0).String(); s != "drbdadm primary --force r0" {
+		t.Errorf("Expected %q, got %q", "drbdadm primary --force r0", s)
+	}
+}
+
+func TestTarget(t *testing.T) {
+	var tests = []struct {
+		target Target
+		str    string
+		scope  Scope
+	}{
+		{Target{Resource: "r0"}, "r0", ResourceScope},
+		{Target{Resource: "r0", Peer: "beta"}, "r0:beta", ConnectionScope},
+		{Target{Resource: "r0", Volume: "1"}, "r0/1", VolumeScope},
+		{Target{Resource: "r0", Peer: "beta", Volume: "1"}, "r0:beta/1", PeerVolumeScope},
+	}
+
+	for _, tt := range tests {
+		if s := tt.target.String(); s != tt.str {
+			t.Errorf("Expected %q, got %q", tt.str, s)
+		}
+		if scope := tt.target.Scope(); scope != tt.scope {
+			t.Errorf("%s: expected scope %d, got %d", tt.str, tt.scope, scope)
+		}
+	}
+}
+
+func TestAllows(t *testing.T) {
+	conn := Target{Resource: "r0", Peer: "beta"}
+	vol := Target{Resource: "r0", Volume: "0"}
+	peerVol := Target{Resource: "r0", Peer: "beta", Volume: "0"}
+
+	if !Disconnect.Allows(conn) {
+		t.Error("Expected disconnect to work on a connection")
+	}
+	if Disconnect.Allows(vol) {
+		t.Error("Expected disconnect not to work on a volume")
+	}
+	if !Attach.Allows(vol) || Attach.Allows(conn) {
+		t.Error("Expected attach to work on volumes only")
+	}
+	if !InvalidateRemote.Allows(peerVol) {
+		t.Error("Expected invalidate-remote to work on a peer volume")
+	}
+	if ForgetPeer.Allows(Target{Resource: "r0"}) {
+		t.Error("Expected forget-peer not to work on a resource")
+	}
+}
+
+// fakeDrbdadm puts a drbdadm running script first in PATH.
+func fakeDrbdadm(t *testing.T, script string) func() {
+	dir, err := ioutil.TempDir("", "drbdtop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "drbdadm"), []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+	return func() {
+		os.Setenv("PATH", path)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestRun(t *testing.T) {
+	defer fakeDrbdadm(t, "echo \"$@\"; echo failed >&2; exit 10\n")()
+
+	out, err := New(Adjust, Target{Resource: "r0"}).CombinedOutput()
+	if err == nil {
+		t.Error("Expected an error for exit status 10")
+	}
+	if string(out) != "adjust r0\nfailed\n" {
+		t.Errorf("Expected the combined output, got %q", out)
+	}
+}
+
+func TestRunCancel(t *testing.T) {
+	// The child keeps the output open, it has to be killed, too.
+	defer fakeDrbdadm(t, "echo started; sleep 10; echo done\n")()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		cancel()
+	}()
+
+	start := time.Now()
+	out, err := New(Adjust, Target{Resource: "r0"}).Run(ctx)
+	if err != ErrCanceled {
+		t.Errorf("Expected the command to be killed, got %v", err)
+	}
+	if string(out) != "started\n" {
+		t.Errorf("Expected the output up to the cancellation, got %q", out)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("Expected the command to be killed right away, took %s", d)
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	defer fakeDrbdadm(t, "sleep 10\n")()
+
+	cmd := New(Adjust, Target{Resource: "r0"})
+	cmd.Timeout = 100 * time.Millisecond
+	if _, err := cmd.CombinedOutput(); err != ErrTimeout {
+		t.Errorf("Expected the command to time out, got %v", err)
+	}
+}
+
+func TestRunAll(t *testing.T) {
+	// Fails for r1.
+	defer fakeDrbdadm(t, "sleep 0.2; echo \"$2\"; [ \"$2\" != r1 ]\n")()
+
+	cmd := New(Adjust, Target{Resource: "r0"}, Target{Resource: "r1"}, Target{Resource: "r2"}, Target{Resource: "r3"})
+	cmds := cmd.Split()
+	if len(cmds) != 4 || cmds[1].String() != "drbdadm adjust r1" {
+		t.Fatalf("Expected a command per target, got %v", cmds)
+	}
+
+	var done []int
+	start := time.Now()
+	results := RunAll(context.Background(), cmds, 2, func(i int, r Result) {
+		done = append(done, i)
+	})
+
+	// Two at a time take at least twice as long as one.
+	if d := time.Since(start); d < 400*time.Millisecond {
+		t.Errorf("Expected at most 2 commands to run at a time, all 4 took %s", d)
+	}
+	if len(done) != 4 {
+		t.Errorf("Expected done to be called for all 4 commands, got %v", done)
+	}
+	for i, r := range results {
+		if string(r.Output) != cmds[i].Targets[0].Resource+"\n" {
+			t.Errorf("%s: expected its own output, got %q", cmds[i], r.Output)
+		}
+		if failed := r.Err != nil; failed != (i == 1) {
+			t.Errorf("%s: unexpected error %v", cmds[i], r.Err)
+		}
+	}
+}
+
+func TestRunAllCanceled(t *testing.T) {
+	defer fakeDrbdadm(t, "sleep 10\n")()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results := RunAll(ctx, New(Adjust, Target{Resource: "r0"}, Target{Resource: "r1"}).Split(), 1, nil)
+	for _, r := range results {
+		if r.Err != ErrCanceled {
+			t.Errorf("Expected the commands to be canceled, got %v", r.Err)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+/*
+ *drbdtop - statistics for DRBD
+ *Copyright © 2017 Hayley Swimelar and Roland Kammerer
+ *
+ *This program is free software; you can redistribute it and/or modify
+ *it under the terms of the GNU General Public License as published by
+ *the Free Software Foundation; either version 2 of the License, or
+ *(at your option) any later version.
+ *
+ *This program is distributed in the hope that it will be useful,
+ *but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ *GNU General Public License for more details.
+ *
+ *You should have received a copy of the GNU General Public License
+ *along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package drbdadm
+
+import "fmt"
+
+// Mode is a permission profile, it limits the Actions that can be run.
+type Mode int
+
+// Permission profiles, from the least to the most privileged.
+const (
+	// ReadOnly runs no Actions at all.
+	ReadOnly Mode = iota
+	// Operator runs the Actions that neither take anything down nor destroy
+	// data.
+	Operator
+	// Admin runs all Actions.
+	Admin
+)
+
+var modeNames = []string{"read-only", "operator", "admin"}
+
+// Modes returns the names of all Modes.
+func Modes() []string {
+	return append([]string{}, modeNames...)
+}
+
+// ParseMode returns the Mode called s.
+func ParseMode(s string) (Mode, error) {
+	for i, n := range modeNames {
+		if s == n {
+			return Mode(i), nil
+		}
+	}
+	return ReadOnly, fmt.Errorf("unknown mode %q", s)
+}
+
+func (m Mode) String() string {
+	if m < 0 || int(m) >= len(modeNames) {
+		return fmt.Sprintf("Mode(%d)", int(m))
+	}
+	return modeNames[m]
+}
+
+// Allows reports whether a can be run in the mode.
+func (m Mode) Allows(a Action) bool {
+	switch m {
+	case Admin:
+		return true
+	case Operator:
+		return a.Operator
+	}
+	return false
+}
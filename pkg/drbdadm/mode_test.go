@@ -0,0 +1,62 @@
+/*
+ *drbdtop - statistics for DRBD
+ *Copyright © 2017 Hayley Swimelar and Roland Kammerer
+ *
+ *This program is free software; you can redistribute it and/or modify
+ *it under the terms of the GNU General Public License as published by
+ *the Free Software Foundation; either version 2 of the License, or
+ *(at your option) any later version.
+ *
+ *This program is distributed in the hope that it will be useful,
+ *but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ *GNU General Public License for more details.
+ *
+ *You should have received a copy of the GNU General Public License
+ *along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package drbdadm
+
+import "testing"
+
+func TestParseMode(t *testing.T) {
+	for _, n := range Modes() {
+		m, err := ParseMode(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.String() != n {
+			t.Errorf("Expected %q, got %q", n, m)
+		}
+	}
+
+	if _, err := ParseMode("root"); err == nil {
+		t.Error("Expected an error for an unknown mode")
+	}
+}
+
+func TestModeAllows(t *testing.T) {
+	var tests = []struct {
+		mode    Mode
+		action  Action
+		allowed bool
+	}{
+		{ReadOnly, Adjust, false},
+		{ReadOnly, Verify, false},
+		{Operator, Adjust, true},
+		{Operator, Connect, true},
+		{Operator, ResumeSync, true},
+		{Operator, Disconnect, false},
+		{Operator, Down, false},
+		{Operator, ForcePrimary, false},
+		{Admin, Adjust, true},
+		{Admin, ForcePrimary, true},
+	}
+
+	for _, tt := range tests {
+		if allowed := tt.mode.Allows(tt.action); allowed != tt.allowed {
+			t.Errorf("Expected %s to allow '%s': %t, got %t", tt.mode, tt.action, tt.allowed, allowed)
+		}
+	}
+}
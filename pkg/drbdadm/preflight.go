@@ -0,0 +1,166 @@
+/*
+ *drbdtop - statistics for DRBD
+ *Copyright © 2017 Hayley Swimelar and Roland Kammerer
+ *
+ *This program is free software; you can redistribute it and/or modify
+ *it under the terms of the GNU General Public License as published by
+ *the Free Software Foundation; either version 2 of the License, or
+ *(at your option) any later version.
+ *
+ *This program is distributed in the hope that it will be useful,
+ *but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ *GNU General Public License for more details.
+ *
+ *You should have received a copy of the GNU General Public License
+ *along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package drbdadm
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/LINBIT/drbdtop/pkg/update"
+)
+
+// Problem is a reason not to run a Command, found in the current state of
+// one of its targets.
+type Problem struct {
+	Target Target
+	// Blocking problems stop the Command, the others are warnings.
+	Blocking bool
+	Reason   string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s: %s", p.Target, p.Reason)
+}
+
+// Preflight checks c against the current state of the resources, by name,
+// and returns the problems it would cause. Resources that are not known are
+// not checked.
+func Preflight(c *Command, resources map[string]*update.ByRes) []Problem {
+	var problems []Problem
+	for _, t := range c.Targets {
+		var names []string
+		if t.Resource == "all" {
+			for name := range resources {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+		} else {
+			names = []string{t.Resource}
+		}
+
+		for _, name := range names {
+			b, ok := resources[name]
+			if !ok {
+				continue
+			}
+			target := t
+			target.Resource = name
+			problems = append(problems, check(c.Action, target, b)...)
+		}
+	}
+	return problems
+}
+
+// Blocking reports whether any of the problems stops the Command.
+func Blocking(problems []Problem) bool {
+	for _, p := range problems {
+		if p.Blocking {
+			return true
+		}
+	}
+	return false
+}
+
+func check(a Action, t Target, b *update.ByRes) []Problem {
+	b.RLock()
+	defer b.RUnlock()
+
+	var problems []Problem
+	block := func(format string, args ...interface{}) {
+		problems = append(problems, Problem{Target: t, Blocking: true, Reason: fmt.Sprintf(format, args...)})
+	}
+	warn := func(format string, args ...interface{}) {
+		problems = append(problems, Problem{Target: t, Reason: fmt.Sprintf(format, args...)})
+	}
+
+	switch a.String() {
+	case ForcePrimary.String():
+		var primaries []string
+		for peer, c := range b.Connections {
+			if c.Role == "Primary" {
+				primaries = append(primaries, peer)
+			}
+		}
+		sort.Strings(primaries)
+		for _, peer := range primaries {
+			block("peer %s is Primary, a second forced Primary causes a split brain", peer)
+		}
+		for _, vol := range volumes(b, t) {
+			for _, peer := range upToDatePeers(b, vol) {
+				warn("peer %s has UpToDate data of volume %s, 'primary' without --force is enough", peer, vol)
+			}
+		}
+
+	case DiscardMyData.String():
+		if b.Res.Role == "Primary" {
+			block("the local node is Primary, its data is in use")
+		}
+		for _, vol := range volumes(b, t) {
+			if b.Device.Volumes[vol].DiskState == "UpToDate" && len(upToDatePeers(b, vol)) == 0 {
+				warn("volume %s is UpToDate here and on no connected peer, this may be the only good copy", vol)
+			}
+		}
+
+	case Down.String(), Detach.String():
+		for _, vol := range volumes(b, t) {
+			if b.Device.Volumes[vol].DiskState == "UpToDate" && len(upToDatePeers(b, vol)) == 0 {
+				warn("volume %s is the last UpToDate replica, no connected peer is UpToDate", vol)
+			}
+		}
+
+	case Secondary.String():
+		for _, vol := range volumes(b, t) {
+			if o := b.Device.Volumes[vol].Open; o.Present && o.Value {
+				block("volume %s is still open, e.g. mounted", vol)
+			}
+		}
+
+	case CreateMD.String():
+		for _, vol := range volumes(b, t) {
+			if d := b.Device.Volumes[vol].DiskState; d != "" && d != "Diskless" {
+				block("volume %s is attached (%s), its meta data is in use", vol, d)
+			}
+		}
+	}
+	return problems
+}
+
+// volumes returns the local volumes of b that t refers to.
+func volumes(b *update.ByRes, t Target) []string {
+	var vols []string
+	for vol := range b.Device.Volumes {
+		if t.Volume == "" || t.Volume == vol {
+			vols = append(vols, vol)
+		}
+	}
+	sort.Strings(vols)
+	return vols
+}
+
+// upToDatePeers returns the peers that have UpToDate data of vol.
+func upToDatePeers(b *update.ByRes, vol string) []string {
+	var peers []string
+	for peer, p := range b.PeerDevices {
+		if v, ok := p.Volumes[vol]; ok && v.DiskState == "UpToDate" {
+			peers = append(peers, peer)
+		}
+	}
+	sort.Strings(peers)
+	return peers
+}
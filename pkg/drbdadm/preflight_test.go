@@ -0,0 +1,88 @@
+/*
+ *drbdtop - statistics for DRBD
+ *Copyright © 2017 Hayley Swimelar and Roland Kammerer
+ *
+ *This program is free software; you can redistribute it and/or modify
+ *it under the terms of the GNU General Public License as published by
+ *the Free Software Foundation; either version 2 of the License, or
+ *(at your option) any later version.
+ *
+ *This program is distributed in the hope that it will be useful,
+ *but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ *GNU General Public License for more details.
+ *
+ *You should have received a copy of the GNU General Public License
+ *along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package drbdadm
+
+import (
+	"testing"
+
+	"github.com/LINBIT/drbdtop/pkg/resource"
+	"github.com/LINBIT/drbdtop/pkg/update"
+)
+
+// newTestRes returns a resource r0 in the state described by the events.
+func newTestRes(t *testing.T, events ...string) map[string]*update.ByRes {
+	b := update.NewByRes()
+	for _, e := range events {
+		evt, err := resource.NewEvent("2017-02-15T14:43:16.688437+00:00 exists " + e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.Update(evt)
+	}
+	return map[string]*update.ByRes{"r0": b}
+}
+
+func TestPreflight(t *testing.T) {
+	primary := "resource name:r0 role:Primary"
+	secondary := "resource name:r0 role:Secondary"
+	upToDate := "device name:r0 volume:0 minor:0 disk:UpToDate open:no"
+	open := "device name:r0 volume:0 minor:0 disk:UpToDate open:yes"
+	diskless := "device name:r0 volume:0 minor:0 disk:Diskless"
+	peerPrimary := "connection name:r0 peer-node-id:1 conn-name:beta connection:Connected role:Primary"
+	peerSecondary := "connection name:r0 peer-node-id:1 conn-name:beta connection:Connected role:Secondary"
+	peerUpToDate := "peer-device name:r0 peer-node-id:1 conn-name:beta volume:0 replication:Established peer-disk:UpToDate"
+	peerUnknown := "peer-device name:r0 peer-node-id:1 conn-name:beta volume:0 replication:Off peer-disk:DUnknown"
+
+	r0 := Target{Resource: "r0"}
+	var tests = []struct {
+		descr    string
+		cmd      *Command
+		events   []string
+		problems int
+		blocking bool
+	}{
+		{"force primary, peer primary", New(ForcePrimary, r0), []string{secondary, upToDate, peerPrimary, peerUpToDate}, 2, true},
+		{"force primary, peer up to date", New(ForcePrimary, r0), []string{secondary, upToDate, peerSecondary, peerUpToDate}, 1, false},
+		{"force primary, alone", New(ForcePrimary, r0), []string{secondary, upToDate, peerSecondary, peerUnknown}, 0, false},
+		{"discard my data on primary", New(DiscardMyData, r0), []string{primary, upToDate, peerSecondary, peerUpToDate}, 1, true},
+		{"discard my data, only copy", New(DiscardMyData, r0), []string{secondary, upToDate, peerSecondary, peerUnknown}, 1, false},
+		{"discard my data", New(DiscardMyData, r0), []string{secondary, upToDate, peerSecondary, peerUpToDate}, 0, false},
+		{"down last replica", New(Down, r0), []string{primary, upToDate, peerSecondary, peerUnknown}, 1, false},
+		{"down", New(Down, r0), []string{primary, upToDate, peerSecondary, peerUpToDate}, 0, false},
+		{"detach last replica", New(Detach, Target{Resource: "r0", Volume: "0"}), []string{primary, upToDate}, 1, false},
+		{"detach other volume", New(Detach, Target{Resource: "r0", Volume: "1"}), []string{primary, upToDate}, 0, false},
+		{"secondary while open", New(Secondary, r0), []string{primary, open}, 1, true},
+		{"secondary", New(Secondary, r0), []string{primary, upToDate}, 0, false},
+		{"create-md on attached", New(CreateMD, r0), []string{secondary, upToDate}, 1, true},
+		{"create-md", New(CreateMD, r0), []string{secondary, diskless}, 0, false},
+		{"all", New(CreateMD, Target{Resource: "all"}), []string{secondary, upToDate}, 1, true},
+		{"unknown resource", New(CreateMD, Target{Resource: "r1"}), []string{secondary, upToDate}, 0, false},
+		{"harmless", New(Adjust, r0), []string{primary, open}, 0, false},
+	}
+
+	for _, tt := range tests {
+		problems := Preflight(tt.cmd, newTestRes(t, tt.events...))
+		if len(problems) != tt.problems {
+			t.Errorf("%s: Expected %d problems, got %v", tt.descr, tt.problems, problems)
+		}
+		if blocking := Blocking(problems); blocking != tt.blocking {
+			t.Errorf("%s: Expected blocking %t, got %v", tt.descr, tt.blocking, problems)
+		}
+	}
+}
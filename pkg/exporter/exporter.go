@@ -0,0 +1,290 @@
+/*
+ *drbdtop - statistics for DRBD
+ *Copyright © 2017 Hayley Swimelar and Roland Kammerer
+ *
+ *This program is free software; you can redistribute it and/or modify
+ *it under the terms of the GNU General Public License as published by
+ *the Free Software Foundation; either version 2 of the License, or
+ *(at your option) any later version.
+ *
+ *This program is distributed in the hope that it will be useful,
+ *but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ *GNU General Public License for more details.
+ *
+ *You should have received a copy of the GNU General Public License
+ *along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package exporter
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LINBIT/drbdtop/pkg/resource"
+	"github.com/LINBIT/drbdtop/pkg/snapshot"
+	"github.com/LINBIT/drbdtop/pkg/update"
+)
+
+// ContentType of the Prometheus text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Exporter keeps a ResourceCollection up to date and serves it over HTTP in
+// the Prometheus text exposition format.
+type Exporter struct {
+	resources *update.ResourceCollection
+}
+
+// New returns an Exporter, d is the update interval of the collector feeding it.
+func New(d time.Duration) *Exporter {
+	e := &Exporter{resources: update.NewResourceCollection(d)}
+	e.resources.OrderBy(update.Name)
+	return e
+}
+
+// Update the exported resources with a new Event.
+func (e *Exporter) Update(evt resource.Event) {
+	switch evt.Target {
+	case resource.DisplayEvent, resource.EOF:
+		e.resources.UpdateList()
+	case resource.PruneEvent:
+		e.resources.Prune(evt)
+	default:
+		e.resources.Update(evt)
+	}
+}
+
+// Run updates the exported resources until the program exits. Errors are logged.
+func (e *Exporter) Run(events <-chan resource.Event, errors <-chan error) {
+	for {
+		select {
+		case evt := <-events:
+			e.Update(evt)
+		case err := <-errors:
+			log.Println(err)
+		}
+	}
+}
+
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	if err := write(w, gather(snapshot.New(e.resources))); err != nil {
+		log.Println(err)
+	}
+}
+
+type label struct {
+	name, value string
+}
+
+type sample struct {
+	labels []label
+	value  float64
+}
+
+type metric struct {
+	name, help string
+	// Type of the metric, "gauge" or "counter".
+	typ     string
+	samples []sample
+}
+
+// metrics keeps metrics in the order they were first added, Prometheus
+// expects all samples of a metric to be grouped together.
+type metrics struct {
+	order  []string
+	byName map[string]*metric
+}
+
+func (m *metrics) add(name, help string, value float64, labels ...label) {
+	m.addType(name, help, "gauge", value, labels...)
+}
+
+// addCounter adds a sample of a counter, a value that only goes up until it is
+// reset. Prometheus expects counter names to end in _total.
+func (m *metrics) addCounter(name, help string, value float64, labels ...label) {
+	m.addType(name, help, "counter", value, labels...)
+}
+
+func (m *metrics) addType(name, help, typ string, value float64, labels ...label) {
+	if m.byName == nil {
+		m.byName = make(map[string]*metric)
+	}
+	mt, ok := m.byName[name]
+	if !ok {
+		mt = &metric{name: name, help: help, typ: typ}
+		m.byName[name] = mt
+		m.order = append(m.order, name)
+	}
+	mt.samples = append(mt.samples, sample{labels: labels, value: value})
+}
+
+// addWindow adds the statistics of a gauge over the stats window as name_window,
+// with the kind of statistic in the "stat" label.
+func (m *metrics) addWindow(name, help string, s snapshot.Stats, scale float64, labels ...label) {
+	for _, st := range []struct {
+		stat  string
+		value float64
+	}{
+		{"min", float64(s.Min)},
+		{"max", float64(s.Max)},
+		{"avg", s.Avg},
+		{"p50", float64(s.P50)},
+		{"p95", float64(s.P95)},
+		{"p99", float64(s.P99)},
+	} {
+		m.add(name+"_window", help+" Statistics over the stats window.",
+			st.value*scale, append(labels[:len(labels):len(labels)], label{"stat", st.stat})...)
+	}
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func gather(s snapshot.Snapshot) *metrics {
+	m := &metrics{}
+
+	for _, r := range s.Resources {
+		res := label{"resource", r.Name}
+
+		m.add("drbdtop_resource_danger", "Aggregate danger score of the resource.",
+			float64(r.Danger), res)
+		m.add("drbdtop_resource_role", "Role of the resource, the current role has the value 1.",
+			1, res, label{"role", r.Role})
+		m.add("drbdtop_resource_unconfigured", "Whether the resource is configured, but down.",
+			boolToFloat(r.Unconfigured), res)
+		if r.Unconfigured {
+			continue
+		}
+
+		m.add("drbdtop_device_danger", "Danger score of the local device.",
+			float64(r.Device.Danger), res)
+
+		minors := make(map[string]string)
+		for _, v := range r.Device.Volumes {
+			minors[v.Volume] = v.Minor
+			vol := []label{res, {"volume", v.Volume}, {"minor", v.Minor}}
+
+			m.add("drbdtop_device_size_bytes", "Size of the local volume.",
+				float64(v.SizeKiB)*1024, vol...)
+			m.addCounter("drbdtop_device_read_bytes_total", "Data read from the local volume since drbdtop started watching it.",
+				float64(v.ReadKiB.Total)*1024, vol...)
+			m.add("drbdtop_device_read_bytes_per_second", "Rate of data read from the local volume.",
+				v.ReadKiB.PerSecond*1024, vol...)
+			m.addCounter("drbdtop_device_written_bytes_total", "Data written to the local volume since drbdtop started watching it.",
+				float64(v.WrittenKiB.Total)*1024, vol...)
+			m.add("drbdtop_device_written_bytes_per_second", "Rate of data written to the local volume.",
+				v.WrittenKiB.PerSecond*1024, vol...)
+			m.add("drbdtop_device_al_updates_per_second", "Rate of activity log updates of the local volume.",
+				v.ActivityLogUpdates.PerSecond, vol...)
+			m.add("drbdtop_device_bm_updates_per_second", "Rate of bitmap updates of the local volume.",
+				v.BitMapUpdates.PerSecond, vol...)
+			m.add("drbdtop_device_upper_pending", "Requests from the upper layers not yet answered by DRBD.",
+				float64(v.UpperPending.Current), vol...)
+			m.addWindow("drbdtop_device_upper_pending", "Requests from the upper layers not yet answered by DRBD.",
+				v.UpperPending, 1, vol...)
+			m.add("drbdtop_device_lower_pending", "Requests sent to the backing device not yet completed.",
+				float64(v.LowerPending.Current), vol...)
+			m.addWindow("drbdtop_device_lower_pending", "Requests sent to the backing device not yet completed.",
+				v.LowerPending, 1, vol...)
+			m.add("drbdtop_device_disk_state", "Disk state of the local volume, the current state has the value 1.",
+				1, append(vol, label{"disk_state", v.DiskState})...)
+			// DRBD 8.4 does not know about quorum.
+			if v.Quorum != "" {
+				m.add("drbdtop_device_quorum", "Whether the local volume has quorum.",
+					boolToFloat(!v.QuorumAlert), vol...)
+			}
+		}
+
+		for _, c := range r.Connections {
+			conn := []label{res, {"connection", c.Name}, {"peer_node_id", c.PeerNodeID}}
+
+			m.add("drbdtop_connection_danger", "Danger score of the connection and the peer device behind it.",
+				float64(c.Danger+c.PeerDevice.Danger), conn...)
+			m.add("drbdtop_connection_state", "State of the connection, the current state has the value 1.",
+				1, append(conn, label{"state", c.Status})...)
+			m.add("drbdtop_connection_role", "Role of the peer, the current role has the value 1.",
+				1, append(conn, label{"role", c.Role})...)
+			m.add("drbdtop_connection_congested", "Whether the connection is congested.",
+				boolToFloat(c.Congested != "" && c.Congested != "no"), conn...)
+			m.add("drbdtop_connection_split_brain", "Whether DRBD reported a split brain of the connection.",
+				boolToFloat(c.SplitBrain != nil && c.SplitBrain.Confirmed), conn...)
+
+			for _, v := range c.PeerDevice.Volumes {
+				vol := []label{res, {"connection", c.Name}, {"volume", v.Volume}, {"minor", minors[v.Volume]}}
+
+				m.add("drbdtop_peer_device_replication_state", "Replication state of the peer volume, the current state has the value 1.",
+					1, append(vol, label{"state", v.ReplicationStatus})...)
+				m.add("drbdtop_peer_device_disk_state", "Disk state of the peer volume, the current state has the value 1.",
+					1, append(vol, label{"disk_state", v.DiskState})...)
+				m.add("drbdtop_peer_device_out_of_sync_bytes", "Data that is out of sync with the peer volume.",
+					float64(v.OutOfSyncKiB.Current)*1024, vol...)
+				m.addWindow("drbdtop_peer_device_out_of_sync_bytes", "Data that is out of sync with the peer volume.",
+					v.OutOfSyncKiB, 1024, vol...)
+				m.add("drbdtop_peer_device_pending_writes", "Requests sent to the peer, but not yet answered.",
+					float64(v.PendingWrites.Current), vol...)
+				m.addWindow("drbdtop_peer_device_pending_writes", "Requests sent to the peer, but not yet answered.",
+					v.PendingWrites, 1, vol...)
+				m.add("drbdtop_peer_device_unacked_writes", "Requests received by the peer, but not yet answered.",
+					float64(v.UnackedWrites.Current), vol...)
+				m.addWindow("drbdtop_peer_device_unacked_writes", "Requests received by the peer, but not yet answered.",
+					v.UnackedWrites, 1, vol...)
+				m.addCounter("drbdtop_peer_device_sent_bytes_total", "Data sent to the peer since drbdtop started watching it.",
+					float64(v.SentKiB.Total)*1024, vol...)
+				m.add("drbdtop_peer_device_sent_bytes_per_second", "Rate of data sent to the peer.",
+					v.SentKiB.PerSecond*1024, vol...)
+				m.addCounter("drbdtop_peer_device_received_bytes_total", "Data received from the peer since drbdtop started watching it.",
+					float64(v.ReceivedKiB.Total)*1024, vol...)
+				m.add("drbdtop_peer_device_received_bytes_per_second", "Rate of data received from the peer.",
+					v.ReceivedKiB.PerSecond*1024, vol...)
+
+				if r := v.Resync; r != nil {
+					m.add("drbdtop_peer_device_resync_remaining_bytes", "Data the running resync still has to transfer.",
+						float64(r.RemainingKiB)*1024, vol...)
+					m.add("drbdtop_peer_device_resync_bytes_per_second", "Smoothed rate of the running resync.",
+						r.KiBPerSecond*1024, vol...)
+					m.add("drbdtop_peer_device_resync_eta_seconds", "Estimated time until the running resync is done, 0 if unknown.",
+						r.ETASeconds, vol...)
+					m.add("drbdtop_peer_device_resync_paused", "Whether the running resync is paused.",
+						boolToFloat(r.Paused), vol...)
+					m.add("drbdtop_peer_device_resync_start_time_seconds", "Time the running resync started at.",
+						float64(r.StartTime.Unix()), vol...)
+				}
+			}
+		}
+	}
+
+	return m
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func write(w io.Writer, m *metrics) error {
+	for _, name := range m.order {
+		mt := m.byName[name]
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", mt.name, mt.help, mt.name, mt.typ); err != nil {
+			return err
+		}
+		for _, s := range mt.samples {
+			var labels []string
+			for _, l := range s.labels {
+				labels = append(labels, l.name+`="`+labelValueEscaper.Replace(l.value)+`"`)
+			}
+			if _, err := fmt.Fprintf(w, "%s{%s} %s\n", mt.name, strings.Join(labels, ","),
+				strconv.FormatFloat(s.value, 'f', -1, 64)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
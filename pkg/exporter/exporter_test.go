@@ -0,0 +1,105 @@
+/*
+ *drbdtop - statistics for DRBD
+ *Copyright © 2017 Hayley Swimelar and Roland Kammerer
+ *
+ *This program is free software; you can redistribute it and/or modify
+ *it under the terms of the GNU General Public License as published by
+ *the Free Software Foundation; either version 2 of the License, or
+ *(at your option) any later version.
+ *
+ *This program is distributed in the hope that it will be useful,
+ *but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ *GNU General Public License for more details.
+ *
+ *You should have received a copy of the GNU General Public License
+ *along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package exporter
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/LINBIT/drbdtop/pkg/collect"
+	"github.com/LINBIT/drbdtop/pkg/resource"
+)
+
+func TestServeHTTP(t *testing.T) {
+	path := "testdata/events2.txt"
+	events := make(chan resource.Event, 5)
+	errors := make(chan error, 5)
+	go collect.FileCollector{Path: &path}.Collect(events, errors)
+
+	exp := New(0)
+	for evt := range events {
+		exp.Update(evt)
+		if evt.Target == resource.EOF {
+			break
+		}
+	}
+	select {
+	case err := <-errors:
+		t.Fatal(err)
+	default:
+	}
+
+	srv := httptest.NewServer(exp)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.Header.Get("Content-Type") != ContentType {
+		t.Errorf("Expected content type %q, got %q", ContentType, resp.Header.Get("Content-Type"))
+	}
+
+	lines := make(map[string]bool)
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		lines[scanner.Text()] = true
+	}
+
+	for _, l := range []string{
+		"# TYPE drbdtop_device_size_bytes gauge",
+		`drbdtop_resource_role{resource="r0",role="Primary"} 1`,
+		`drbdtop_device_size_bytes{resource="r0",volume="0",minor="1000"} 528330752`,
+		"# TYPE drbdtop_device_written_bytes_total counter",
+		`drbdtop_device_written_bytes_total{resource="r0",volume="0",minor="1000"} 4194304`,
+		`drbdtop_device_written_bytes_per_second{resource="r0",volume="0",minor="1000"} 4194304`,
+		`drbdtop_device_disk_state{resource="r0",volume="0",minor="1000",disk_state="UpToDate"} 1`,
+		`drbdtop_device_quorum{resource="r0",volume="0",minor="1000"} 1`,
+		`drbdtop_connection_state{resource="r0",connection="bravo",peer_node_id="2",state="StandAlone"} 1`,
+		`drbdtop_peer_device_replication_state{resource="r0",connection="alpha",volume="0",minor="1000",state="SyncSource"} 1`,
+		`drbdtop_peer_device_out_of_sync_bytes{resource="r0",connection="alpha",volume="0",minor="1000"} 2097152`,
+		`drbdtop_peer_device_pending_writes{resource="r0",connection="alpha",volume="0",minor="1000"} 1`,
+		`drbdtop_peer_device_unacked_writes{resource="r0",connection="alpha",volume="0",minor="1000"} 2`,
+		`drbdtop_peer_device_out_of_sync_bytes_window{resource="r0",connection="alpha",volume="0",minor="1000",stat="p99"} 2097152`,
+		`drbdtop_peer_device_pending_writes_window{resource="r0",connection="alpha",volume="0",minor="1000",stat="max"} 1`,
+	} {
+		if !lines[l] {
+			t.Errorf("Expected exported metrics to contain %q", l)
+		}
+	}
+}
+
+func TestWriteEscapesLabels(t *testing.T) {
+	m := &metrics{}
+	m.add("drbdtop_test", "Test metric.", 1, label{"resource", "a\"b\\c"})
+
+	rec := httptest.NewRecorder()
+	if err := write(rec, m); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "# HELP drbdtop_test Test metric.\n# TYPE drbdtop_test gauge\ndrbdtop_test{resource=\"a\\\"b\\\\c\"} 1\n"
+	if rec.Body.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, rec.Body.String())
+	}
+}
@@ -0,0 +1,100 @@
+/*
+ *drbdtop - statistics for DRBD
+ *Copyright © 2017 Hayley Swimelar and Roland Kammerer
+ *
+ *This program is free software; you can redistribute it and/or modify
+ *it under the terms of the GNU General Public License as published by
+ *the Free Software Foundation; either version 2 of the License, or
+ *(at your option) any later version.
+ *
+ *This program is distributed in the hope that it will be useful,
+ *but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ *GNU General Public License for more details.
+ *
+ *You should have received a copy of the GNU General Public License
+ *along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package resource
+
+import (
+	"strconv"
+	"time"
+)
+
+// The optional values below hold fields that only newer versions of DRBD
+// report. Present is false until an Event contained the field, and if its last
+// value could not be parsed. Events without the field, such as changes of a
+// single state, keep the previous value.
+
+// OptUint64 is an optional unsigned integer field.
+type OptUint64 struct {
+	Value   uint64
+	Present bool
+}
+
+func (o *OptUint64) set(fields map[string]string, key string) {
+	s, ok := fields[key]
+	if !ok {
+		return
+	}
+	*o = OptUint64{}
+	if v, err := strconv.ParseUint(s, 10, 64); err == nil {
+		*o = OptUint64{Value: v, Present: true}
+	}
+}
+
+// OptFloat64 is an optional decimal field.
+type OptFloat64 struct {
+	Value   float64
+	Present bool
+}
+
+func (o *OptFloat64) set(fields map[string]string, key string) {
+	s, ok := fields[key]
+	if !ok {
+		return
+	}
+	*o = OptFloat64{}
+	if v, err := strconv.ParseFloat(s, 64); err == nil {
+		*o = OptFloat64{Value: v, Present: true}
+	}
+}
+
+// OptBool is an optional "yes"/"no" field.
+type OptBool struct {
+	Value   bool
+	Present bool
+}
+
+func (o *OptBool) set(fields map[string]string, key string) {
+	s, ok := fields[key]
+	if !ok {
+		return
+	}
+	*o = OptBool{}
+	switch s {
+	case "yes":
+		*o = OptBool{Value: true, Present: true}
+	case "no":
+		*o = OptBool{Value: false, Present: true}
+	}
+}
+
+// OptDuration is an optional field counting time in a fixed unit.
+type OptDuration struct {
+	Value   time.Duration
+	Present bool
+}
+
+func (o *OptDuration) set(fields map[string]string, key string, unit time.Duration) {
+	s, ok := fields[key]
+	if !ok {
+		return
+	}
+	*o = OptDuration{}
+	if v, err := strconv.ParseUint(s, 10, 64); err == nil {
+		*o = OptDuration{Value: time.Duration(v) * unit, Present: true}
+	}
+}
@@ -21,6 +21,8 @@ package resource
 import (
 	"fmt"
 	"math"
+	"net"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -36,16 +38,21 @@ const DisplayEvent = "DisplayEvent"
 // PruneEvent is the sentinel to signal a prune operation
 const PruneEvent = "PruneEvent"
 
+// ResetEvent is the sentinel to signal that all known data has to be dropped
+const ResetEvent = "ResetEvent"
+
 type resKeys struct {
-	Name          string
-	Role          string
-	Suspended     string
-	WriteOrdering string
-	Unconfigured  string
+	Name           string
+	Role           string
+	Suspended      string
+	WriteOrdering  string
+	Unconfigured   string
+	MayPromote     string
+	PromotionScore string
 }
 
 // ResKeys is a data container for the field keys of resource Events.
-var ResKeys = resKeys{"name", "role", "suspended", "write-ordering", "unconfigured"}
+var ResKeys = resKeys{"name", "role", "suspended", "write-ordering", "unconfigured", "may_promote", "promotion_score"}
 
 type connKeys struct {
 	Name       string
@@ -54,10 +61,12 @@ type connKeys struct {
 	Connection string
 	Role       string
 	Congested  string
+	APInFlight string
+	RSInFlight string
 }
 
 // ConnKeys is a data container for the field keys of connection Events.
-var ConnKeys = connKeys{"name", "peer-node-id", "conn-name", "connection", "role", "congested"}
+var ConnKeys = connKeys{"name", "peer-node-id", "conn-name", "connection", "role", "congested", "ap-in-flight", "rs-in-flight"}
 
 type devKeys struct {
 	Name         string
@@ -75,10 +84,11 @@ type devKeys struct {
 	ALSuspended  string
 	Blocked      string
 	Quorum       string
+	Open         string
 }
 
 // DevKeys is a data container for the field keys of device Events.
-var DevKeys = devKeys{"name", "volume", "minor", "disk", "client", "size", "read", "written", "al-writes", "bm-writes", "upper-pending", "lower-pending", "al-suspended", "blocked", "quorum"}
+var DevKeys = devKeys{"name", "volume", "minor", "disk", "client", "size", "read", "written", "al-writes", "bm-writes", "upper-pending", "lower-pending", "al-suspended", "blocked", "quorum", "open"}
 
 type peerDevKeys struct {
 	Name            string
@@ -94,45 +104,31 @@ type peerDevKeys struct {
 	OutOfSync       string
 	Pending         string
 	Unacked         string
+	RsTotal         string
+	RsDtStartMs     string
+	RsPausedMs      string
+	RsSameCsum      string
+	RsDt0Ms         string
+	Want            string
+	Done            string
+	ETA             string
 }
 
 // PeerDevKeys is a data container for the field keys of device Events.
-var PeerDevKeys = peerDevKeys{"name", "peer-node-id", "conn-name", "volume", "replication", "peer-disk", "peer-client", "resync-suspended", "received", "sent", "out-of-sync", "pending", "unacked"}
-
-var connDangerScores = map[string]uint64{
-	"Connected":  0,
-	"SyncSource": 1,
-	"SyncTarget": 1,
-	"StandAlone": 30,
-
-	"default": 1,
-}
-
-var diskDangerScores = map[string]uint64{
-	"UpToDate":   0,
-	"Consistent": 1,
-	"Diskless":   16,
-	"Outdated":   1,
-	"DUnknown":   2,
-
-	"default": 1,
+var PeerDevKeys = peerDevKeys{"name", "peer-node-id", "conn-name", "volume", "replication", "peer-disk", "peer-client", "resync-suspended", "received", "sent", "out-of-sync", "pending", "unacked",
+	"rs-total", "rs-dt-start-ms", "rs-paused-ms", "rs-same-csum", "rs-dt0-ms", "want", "done", "eta"}
+
+type pathKeys struct {
+	Name        string
+	PeerNodeID  string
+	ConnName    string
+	Local       string
+	Peer        string
+	Established string
 }
 
-var roleDangerScores = map[string]uint64{
-	"Primary":   0,
-	"Secondary": 0,
-	"Unknown":   1,
-	"Down":      10,
-
-	"default": 1,
-}
-
-var quorumDangerScores = map[string]uint64 {
-	"yes":       0,
-	"no":        30,
-
-	"default":   0,
-}
+// PathKeys is a data container for the field keys of path Events.
+var PathKeys = pathKeys{"name", "peer-node-id", "conn-name", "local", "peer", "established"}
 
 var quorumLostKeyword = "no"
 
@@ -152,9 +148,38 @@ func (u *uptimer) updateTimes(t time.Time) {
 	u.Uptime = u.CurrentTime.Sub(u.StartTime)
 }
 
+// statsWindow is how far back the statistics of gauges look.
+var statsWindow = 15 * time.Minute
+
+// maxStatsSamples bounds the memory used by the statistics of a single gauge.
+const maxStatsSamples = 10000
+
+// SetStatsWindow sets how far back the statistics of gauges look.
+func SetStatsWindow(d time.Duration) {
+	statsWindow = d
+}
+
+// StatsWindow returns how far back the statistics of gauges look.
+func StatsWindow() time.Duration {
+	return statsWindow
+}
+
+type sample struct {
+	time  time.Time
+	value uint64
+	// Position of the sample in the sequence of all samples.
+	seq uint64
+}
+
+// minMaxAvgCurrent keeps statistics of a gauge over a sliding window. It is
+// updated for every event, so the minimum and maximum are kept in monotonic
+// queues and the percentiles are only calculated when asked for.
 type minMaxAvgCurrent struct {
-	updateCount int
-	total       uint64
+	samples []sample
+	// Samples that can still become the minimum or maximum, in order.
+	mins, maxs []sample
+	total      uint64
+	seq        uint64
 
 	Min     uint64
 	Max     uint64
@@ -162,64 +187,186 @@ type minMaxAvgCurrent struct {
 	Current uint64
 }
 
+// Percentiles are the nearest rank percentiles of a gauge.
+type Percentiles struct {
+	P50 uint64
+	P95 uint64
+	P99 uint64
+}
+
 func newMinMaxAvgCurrent() *minMaxAvgCurrent {
-	return &minMaxAvgCurrent{Min: math.MaxUint64}
+	return &minMaxAvgCurrent{}
 }
 
-func (m *minMaxAvgCurrent) calculate(s string) {
-	i, _ := strconv.ParseUint(s, 10, 64)
+func (m *minMaxAvgCurrent) calculate(t time.Time, s string) {
+	i, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		// Events without statistics don't tell us anything new.
+		return
+	}
 
-	m.updateCount++
-	m.total += i
+	m.Current = i
+	m.add(sample{time: t, value: i, seq: m.seq})
+	m.seq++
+
+	// Drop samples that left the window.
+	drop := 0
+	start := t.Add(-statsWindow)
+	for drop < len(m.samples)-1 && m.samples[drop].time.Before(start) {
+		drop++
+	}
+	if over := len(m.samples) - maxStatsSamples; over > drop {
+		drop = over
+	}
+	for _, d := range m.samples[:drop] {
+		m.total -= d.value
+	}
+	m.samples = m.samples[drop:]
+	first := m.samples[0].seq
+	for m.mins[0].seq < first {
+		m.mins = m.mins[1:]
+	}
+	for m.maxs[0].seq < first {
+		m.maxs = m.maxs[1:]
+	}
 
-	if i < m.Min {
-		m.Min = i
+	m.update()
+}
+
+// add appends a sample to the window.
+func (m *minMaxAvgCurrent) add(x sample) {
+	m.samples = append(m.samples, x)
+	m.total += x.value
+	for len(m.mins) > 0 && m.mins[len(m.mins)-1].value >= x.value {
+		m.mins = m.mins[:len(m.mins)-1]
+	}
+	m.mins = append(m.mins, x)
+	for len(m.maxs) > 0 && m.maxs[len(m.maxs)-1].value <= x.value {
+		m.maxs = m.maxs[:len(m.maxs)-1]
 	}
-	if i > m.Max {
-		m.Max = i
+	m.maxs = append(m.maxs, x)
+}
+
+// reset starts the statistics over with the current value.
+func (m *minMaxAvgCurrent) reset() {
+	if len(m.samples) == 0 {
+		return
 	}
+	last := m.samples[len(m.samples)-1]
+	m.samples, m.mins, m.maxs, m.total = nil, nil, nil, 0
+	m.add(last)
+	m.update()
+}
 
-	m.Avg = float64(m.total) / float64(m.updateCount)
+func (m *minMaxAvgCurrent) update() {
+	m.Min = m.mins[0].value
+	m.Max = m.maxs[0].value
+	m.Avg = float64(m.total) / float64(len(m.samples))
+}
 
-	m.Current = i
+// Percentiles returns the percentiles of the samples in the window. They are
+// calculated on every call, which sorts the whole window.
+func (m *minMaxAvgCurrent) Percentiles() Percentiles {
+	if len(m.samples) == 0 {
+		return Percentiles{}
+	}
+	values := make([]uint64, len(m.samples))
+	for n, s := range m.samples {
+		values[n] = s.value
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+	return Percentiles{
+		P50: percentile(values, 50),
+		P95: percentile(values, 95),
+		P99: percentile(values, 99),
+	}
 }
 
+// percentile returns the nearest rank percentile p of the sorted values.
+func percentile(sorted []uint64, p int) uint64 {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// Time constants of the moving averages of a rate, just like the load average.
+var rateWindows = [3]time.Duration{time.Minute, 5 * time.Minute, 15 * time.Minute}
+
 type rate struct {
 	initial uint64
 	last    uint64
 	new     bool
-
-	Previous  *previousFloat64
+	// Uptime at the last sample and what was counted since then.
+	lastTime time.Duration
+	pending  uint64
+	// Set once the averages have their first rate.
+	averaged bool
+
+	// Rates of the last samples.
+	Previous *previousFloat64
+	// Rate between the last two samples.
 	PerSecond float64
-	Total     uint64
+	// Exponentially weighted moving averages of the rate over 1, 5 and 15 minutes.
+	Avg1m  float64
+	Avg5m  float64
+	Avg15m float64
+	Total  uint64
 }
 
 func (r *rate) calculate(t time.Duration, s string) {
-	i, _ := strconv.ParseUint(s, 10, 64)
+	i, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		// Events without statistics don't tell us anything new.
+		return
+	}
 
 	// We have not been calculated before, set initial value
 	// to account for the fact that we are seeing a partial dataset.
 	if r.new {
 		r.initial = i
+		r.last = i
+		r.lastTime = t
 		r.new = false
+		r.Previous.Push(0)
+		return
 	}
-	// A connection flapped and we're seeing a new dataset,
-	// reset initial to 0 and adjust total to account for old data.
+
+	// A connection flapped and the counter started over from zero,
+	// everything it counted since then is new.
+	delta := i - r.last
 	if i < r.last {
-		r.initial = 0
-		r.Total = (r.last - i)
-	} else {
-		r.Total = (i - r.initial)
+		delta = i
 	}
-
+	r.Total += delta
+	r.pending += delta
 	r.last = i
 
-	rate := float64(r.Total) / t.Seconds()
-	if math.IsNaN(rate) {
-		rate = 0
+	elapsed := t - r.lastTime
+	if elapsed <= 0 {
+		// Several Events at the same time, the next interval accounts for them.
+		return
 	}
+	r.lastTime = t
+
+	rate := float64(r.pending) / elapsed.Seconds()
+	r.pending = 0
+	r.PerSecond = rate
 	r.Previous.Push(rate)
-	r.PerSecond = r.Previous.Values[len(r.Previous.Values)-1]
+
+	// The averages start out with the first rate, rather than climbing up from zero.
+	avgs := [3]*float64{&r.Avg1m, &r.Avg5m, &r.Avg15m}
+	for n, w := range rateWindows {
+		if !r.averaged {
+			*avgs[n] = rate
+			continue
+		}
+		decay := math.Exp(-elapsed.Seconds() / w.Seconds())
+		*avgs[n] = *avgs[n]*decay + rate*(1-decay)
+	}
+	r.averaged = true
 }
 
 // Preserve maxLen number of float64s, old values drop off from the front
@@ -246,6 +393,33 @@ type Event struct {
 	Target string
 	// Key/Value pairs separated by a ":"
 	Fields map[string]string
+	// Node the Event was collected from, empty for the local node.
+	Node string
+}
+
+// String returns the Event in the format of drbdsetup events2 --timestamps.
+func (e Event) String() string {
+	var b strings.Builder
+
+	b.WriteString(e.TimeStamp.Format("2006-01-02T15:04:05.000000-07:00"))
+	b.WriteString(" " + e.EventType + " " + e.Target)
+
+	// The name always comes first, just like drbdsetup does it.
+	if name, ok := e.Fields[ResKeys.Name]; ok {
+		b.WriteString(" " + ResKeys.Name + ":" + name)
+	}
+	var keys []string
+	for k := range e.Fields {
+		if k != ResKeys.Name {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		b.WriteString(" " + k + ":" + e.Fields[k])
+	}
+
+	return b.String()
 }
 
 // NewEvent parses the normal string output of drbdsetup events2 and returns an Event.
@@ -297,12 +471,10 @@ func NewEvent(e string) (Event, error) {
 	eTarget := e[:end]
 
 	// Chop off the target and the following space from the start of the string and use the rest of it.
-	// Now only the fields should be left.
+	// Now only the fields should be left. Events such as "destroy resource name:r0" have
+	// a single field, which is handled after the loop.
 	e = e[end+1:]
 	end = strings.Index(e, " ")
-	if end < 0 {
-		return Event{Fields: fields}, fmt.Errorf("Couldn't parse event fields from %q", original)
-	}
 
 	// Loop until we can't find the next kvPair.
 	for end != -1 {
@@ -354,6 +526,12 @@ func NewPruneEvent() Event {
 	return Event{Target: PruneEvent}
 }
 
+// NewResetEvent returns a special Event signaling that all known data is invalid, e.g.
+// because a replay jumped back in time
+func NewResetEvent() Event {
+	return Event{Target: ResetEvent}
+}
+
 // NewUnconfiguredRes returns a special Event signaling that this resource is down(unconfigured).
 func NewUnconfiguredRes(name string) Event {
 	return Event{
@@ -382,6 +560,10 @@ type Resource struct {
 	Suspended     string
 	WriteOrdering string
 	Unconfigured  bool
+	// Whether the resource could be promoted to Primary and how much
+	// it is preferred over its peers to become Primary.
+	MayPromote     OptBool
+	PromotionScore OptUint64
 
 	// Calulated Values
 	Danger uint64
@@ -396,6 +578,8 @@ func (r *Resource) Update(e Event) {
 	r.Role = e.Fields[ResKeys.Role]
 	r.Suspended = e.Fields[ResKeys.Suspended]
 	r.WriteOrdering = e.Fields[ResKeys.WriteOrdering]
+	r.MayPromote.set(e.Fields, ResKeys.MayPromote)
+	r.PromotionScore.set(e.Fields, ResKeys.PromotionScore)
 	if _, ok := e.Fields[ResKeys.Unconfigured]; ok {
 		r.Unconfigured = true
 	} else {
@@ -403,12 +587,7 @@ func (r *Resource) Update(e Event) {
 	}
 	r.updateTimes(e.TimeStamp)
 
-	i, ok := roleDangerScores[r.Role]
-	if !ok {
-		r.Danger = roleDangerScores["default"]
-	} else {
-		r.Danger = i
-	}
+	r.Danger = scoring.state(roles, r.Name, "", r.Role)
 }
 
 // Connection represents the connection from the local resource to a remote resource.
@@ -423,6 +602,13 @@ type Connection struct {
 	ConnectionHint string
 	Role           string
 	Congested      string
+	// Application and resync data sent to the peer, but not yet acknowledged.
+	APInFlightKiB OptUint64
+	RSInFlightKiB OptUint64
+	// Network paths of the connection, keyed by local and peer address.
+	Paths map[string]*Path
+	// Set if the connection is, or may be, split brained.
+	SplitBrain *SplitBrain
 
 	// Calculated Values
 	Danger uint64
@@ -433,36 +619,87 @@ func (c *Connection) Update(e Event) {
 	c.Lock()
 	defer c.Unlock()
 
+	prev := c.ConnectionStatus
 	c.Resource = e.Fields[ConnKeys.Name]
 	c.PeerNodeID = e.Fields[ConnKeys.PeerNodeID]
 	c.ConnectionName = e.Fields[ConnKeys.ConnName]
 	c.ConnectionStatus = e.Fields[ConnKeys.Connection]
+	c.updateSplitBrain(e, prev)
 	c.Role = e.Fields[ConnKeys.Role]
 	c.Congested = e.Fields[ConnKeys.Congested]
+	c.APInFlightKiB.set(e.Fields, ConnKeys.APInFlight)
+	c.RSInFlightKiB.set(e.Fields, ConnKeys.RSInFlight)
 	c.updateTimes(e.TimeStamp)
 	c.setDanger()
 	c.connStatusExplanation()
 }
 
-func (c *Connection) setDanger() {
-	var score uint64
+// UpdatePath updates the connection's Path described by a path Event.
+func (c *Connection) UpdatePath(e Event) {
+	c.Lock()
+	defer c.Unlock()
 
-	i, ok := connDangerScores[c.ConnectionStatus]
-	if !ok {
-		score += connDangerScores["default"]
-	} else {
-		score += i
+	if c.Paths == nil {
+		c.Paths = make(map[string]*Path)
 	}
 
-	i, ok = roleDangerScores[c.Role]
+	key := pathKey(e)
+	path, ok := c.Paths[key]
 	if !ok {
-		score += roleDangerScores["default"]
-	} else {
-		score += i
+		path = &Path{}
+		c.Paths[key] = path
 	}
+	path.Update(e)
 
-	if c.Congested != "no" {
-		score++
+	c.setDanger()
+}
+
+// DestroyPath removes the Path an Event with the EventType "destroy" refers to.
+func (c *Connection) DestroyPath(e Event) {
+	c.Lock()
+	defer c.Unlock()
+
+	delete(c.Paths, pathKey(e))
+	c.setDanger()
+}
+
+// PrunePaths removes paths that haven't been updated since t.
+func (c *Connection) PrunePaths(t time.Time) {
+	c.Lock()
+	defer c.Unlock()
+
+	for k, p := range c.Paths {
+		if p.CurrentTime.Before(t) {
+			delete(c.Paths, k)
+		}
+	}
+	c.setDanger()
+}
+
+func pathKey(e Event) string {
+	return e.Fields[PathKeys.Local] + " " + e.Fields[PathKeys.Peer]
+}
+
+func (c *Connection) setDanger() {
+	var score uint64
+
+	status := c.ConnectionStatus
+	if c.SplitBrain != nil && c.SplitBrain.Confirmed {
+		status = "SplitBrain"
+	}
+	score += scoring.state(connectionStates, c.Resource, c.ConnectionName, status)
+	score += scoring.state(roles, c.Resource, c.ConnectionName, c.Role)
+	score += scoring.state(congested, c.Resource, c.ConnectionName, c.Congested)
+
+	if len(c.Paths) > 0 {
+		established := "no"
+		for _, p := range c.Paths {
+			if p.Established {
+				established = "yes"
+				break
+			}
+		}
+		score += scoring.state(paths, c.Resource, c.ConnectionName, established)
 	}
 
 	c.Danger = score
@@ -471,6 +708,10 @@ func (c *Connection) setDanger() {
 func (c *Connection) connStatusExplanation() {
 	switch c.ConnectionStatus {
 	case "StandAlone":
+		if c.SplitBrain != nil {
+			c.ConnectionHint = c.splitBrainHint()
+			break
+		}
 		c.ConnectionHint = fmt.Sprintf("dropped connection or disconnected manually. try running drbdadm connect %s", c.Resource)
 	case "Disconnecting":
 		c.ConnectionHint = fmt.Sprintf("disconnecting from %s", c.ConnectionName)
@@ -496,6 +737,62 @@ func (c *Connection) connStatusExplanation() {
 	}
 }
 
+// Address is a network address as reported by DRBD, e.g. "ipv4:10.0.0.1:7000"
+// or "ipv6:[fe80::1]:7000".
+type Address struct {
+	Family string
+	Host   string
+	Port   string
+}
+
+// ParseAddress parses a DRBD network address.
+func ParseAddress(s string) (Address, error) {
+	i := strings.Index(s, ":")
+	if i < 0 {
+		return Address{}, fmt.Errorf("Couldn't parse address family from %q", s)
+	}
+
+	host, port, err := net.SplitHostPort(s[i+1:])
+	if err != nil {
+		return Address{}, fmt.Errorf("Couldn't parse address from %q: %v", s, err)
+	}
+
+	return Address{Family: s[:i], Host: host, Port: port}, nil
+}
+
+func (a Address) String() string {
+	// Unparsable addresses are kept verbatim in Host.
+	if a.Family == "" {
+		return a.Host
+	}
+	return a.Family + ":" + net.JoinHostPort(a.Host, a.Port)
+}
+
+// Path represents a single network path between the local node and a peer.
+type Path struct {
+	uptimer
+	Local       Address
+	Peer        Address
+	Established bool
+}
+
+// Update the Path with a new Event.
+func (p *Path) Update(e Event) {
+	// Keep the unparsed address around rather than losing it altogether.
+	if a, err := ParseAddress(e.Fields[PathKeys.Local]); err == nil {
+		p.Local = a
+	} else {
+		p.Local = Address{Host: e.Fields[PathKeys.Local]}
+	}
+	if a, err := ParseAddress(e.Fields[PathKeys.Peer]); err == nil {
+		p.Peer = a
+	} else {
+		p.Peer = Address{Host: e.Fields[PathKeys.Peer]}
+	}
+	p.Established = e.Fields[PathKeys.Established] == "yes"
+	p.updateTimes(e.TimeStamp)
+}
+
 // Device represents a local DRBD virtual block device.
 type Device struct {
 	sync.RWMutex
@@ -535,6 +832,7 @@ func (d *Device) Update(e Event) {
 	vol.Quorum = e.Fields[DevKeys.Quorum]
 	vol.ActivityLogSuspended = e.Fields[DevKeys.ALSuspended]
 	vol.Blocked = e.Fields[DevKeys.Blocked]
+	vol.Open.set(e.Fields, DevKeys.Open)
 
 	if vol.Quorum == quorumLostKeyword {
 		vol.QuorumAlert = true
@@ -551,27 +849,41 @@ func (d *Device) Update(e Event) {
 	vol.WrittenKiB.calculate(vol.uptimer.Uptime, e.Fields[DevKeys.Written])
 	vol.ActivityLogUpdates.calculate(vol.uptimer.Uptime, e.Fields[DevKeys.ALWrites])
 	vol.BitMapUpdates.calculate(vol.uptimer.Uptime, e.Fields[DevKeys.BMWrites])
-	vol.UpperPending.calculate(e.Fields[DevKeys.UpperPending])
-	vol.LowerPending.calculate(e.Fields[DevKeys.LowerPending])
+	vol.UpperPending.calculate(e.TimeStamp, e.Fields[DevKeys.UpperPending])
+	vol.LowerPending.calculate(e.TimeStamp, e.Fields[DevKeys.LowerPending])
+
+	d.setDanger()
+}
+
+// Destroy removes the volume an Event with the EventType "destroy" refers to.
+func (d *Device) Destroy(e Event) {
+	d.Lock()
+	defer d.Unlock()
 
+	delete(d.Volumes, e.Fields[DevKeys.Volume])
 	d.setDanger()
 }
 
+// ResetStats starts the statistics of all volumes over.
+func (d *Device) ResetStats() {
+	d.Lock()
+	defer d.Unlock()
+
+	for _, v := range d.Volumes {
+		v.UpperPending.reset()
+		v.LowerPending.reset()
+	}
+}
+
 func (d *Device) setDanger() {
 	var score uint64
 
 	for _, v := range d.Volumes {
-		i, ok := diskDangerScores[v.DiskState]
-		if !ok {
-			score += diskDangerScores["default"]
-			// If we're diskless on purpose, then everything is normal.
-		} else if !(v.DiskState == "Diskless" && v.Client == "yes") {
-			score += i
-		}
-		i, ok = quorumDangerScores[v.Quorum]
-		if ok {
-			score += i
+		// If we're diskless on purpose, then everything is normal.
+		if !(v.DiskState == "Diskless" && v.Client == "yes") {
+			score += scoring.state(diskStates, d.Resource, "", v.DiskState)
 		}
+		score += scoring.state(quorum, d.Resource, "", v.Quorum)
 	}
 
 	d.Danger = score
@@ -590,6 +902,8 @@ type DevVolume struct {
 	ActivityLogSuspended string
 	Blocked              string
 	QuorumAlert          bool
+	// Whether the device is opened, e.g. mounted.
+	Open OptBool
 
 	// Calculated Values
 	ReadKiB            *rate
@@ -683,31 +997,52 @@ func (p *PeerDevice) Update(e Event) {
 	vol.Client = e.Fields[PeerDevKeys.PeerClient]
 	vol.ResyncSuspended = e.Fields[PeerDevKeys.ResyncSuspended]
 
-	vol.OutOfSyncKiB.calculate(e.Fields[PeerDevKeys.OutOfSync])
-	vol.PendingWrites.calculate(e.Fields[PeerDevKeys.Pending])
-	vol.UnackedWrites.calculate(e.Fields[PeerDevKeys.Unacked])
+	vol.OutOfSyncKiB.calculate(e.TimeStamp, e.Fields[PeerDevKeys.OutOfSync])
+	vol.PendingWrites.calculate(e.TimeStamp, e.Fields[PeerDevKeys.Pending])
+	vol.UnackedWrites.calculate(e.TimeStamp, e.Fields[PeerDevKeys.Unacked])
 
 	vol.ReceivedKiB.calculate(vol.Uptime, e.Fields[PeerDevKeys.Received])
 	vol.SentKiB.calculate(vol.Uptime, e.Fields[PeerDevKeys.Sent])
 
+	vol.Resync.update(e.TimeStamp, vol.ReplicationStatus, vol.ResyncSuspended, e.Fields[PeerDevKeys.OutOfSync])
+	vol.Resync.useReported(e.TimeStamp, e.Fields)
+	vol.ResyncStats.update(e.Fields, vol.ReplicationStatus)
+
 	p.setDanger()
 }
 
+// Destroy removes the volume an Event with the EventType "destroy" refers to.
+func (p *PeerDevice) Destroy(e Event) {
+	p.Lock()
+	defer p.Unlock()
+
+	delete(p.Volumes, e.Fields[PeerDevKeys.Volume])
+	p.setDanger()
+}
+
+// ResetStats starts the statistics of all volumes over.
+func (p *PeerDevice) ResetStats() {
+	p.Lock()
+	defer p.Unlock()
+
+	for _, v := range p.Volumes {
+		v.OutOfSyncKiB.reset()
+		v.PendingWrites.reset()
+		v.UnackedWrites.reset()
+	}
+}
+
 func (p *PeerDevice) setDanger() {
 	var score uint64
 
 	for _, v := range p.Volumes {
-		i, ok := diskDangerScores[v.DiskState]
-		if !ok {
-			score += diskDangerScores["default"]
-		} else if !(v.DiskState == "Diskless" && v.Client == "yes") {
-			score += i
+		if !(v.DiskState == "Diskless" && v.Client == "yes") {
+			score += scoring.state(diskStates, p.Resource, p.ConnectionName, v.DiskState)
 		}
 
-		// Resources can be up to 1 PiB, so this will be at most 12.
-		if v.OutOfSyncKiB.Current != 0 {
-			score += uint64(math.Log(float64(v.OutOfSyncKiB.Current)))
-		}
+		score += scoring.threshold(outOfSyncKiB, p.Resource, p.ConnectionName, v.OutOfSyncKiB.Current)
+		score += scoring.threshold(pendingWrites, p.Resource, p.ConnectionName, v.PendingWrites.Current)
+		score += scoring.threshold(unackedWrites, p.Resource, p.ConnectionName, v.UnackedWrites.Current)
 	}
 
 	p.Danger = score
@@ -768,6 +1103,30 @@ type PeerDevVol struct {
 
 	ReceivedKiB *rate
 	SentKiB     *rate
+
+	Resync Resync
+
+	// Resync statistics as reported by DRBD 9.
+	ResyncStats ResyncStats
+}
+
+// ResyncStats are the resync counters of a peer volume reported by DRBD 9.
+// Done, ETA and Want are only reported while a resync is running.
+type ResyncStats struct {
+	// Data the resync has to transfer in total.
+	TotalKiB OptUint64
+	// Time since the resync started and how long it has been paused.
+	SinceStart OptDuration
+	Paused     OptDuration
+	// Data that did not have to be sent, because the checksums matched.
+	SameChecksumKiB OptUint64
+	// Time between the last two resync rate measurements.
+	Interval OptDuration
+	// Target rate of the resync controller.
+	WantKiBPerSecond OptUint64
+	// Percentage of the resync that is done.
+	Done OptFloat64
+	ETA  OptDuration
 }
 
 // NewPeerDevVol returns a PeerDevVol with internal structs initialized.
@@ -782,6 +1141,23 @@ func NewPeerDevVol(maxLen int) *PeerDevVol {
 	}
 }
 
+func (r *ResyncStats) update(fields map[string]string, replication string) {
+	if !isResyncState(replication) {
+		// Left over from the last resync.
+		r.WantKiBPerSecond = OptUint64{}
+		r.Done = OptFloat64{}
+		r.ETA = OptDuration{}
+	}
+	r.TotalKiB.set(fields, PeerDevKeys.RsTotal)
+	r.SinceStart.set(fields, PeerDevKeys.RsDtStartMs, time.Millisecond)
+	r.Paused.set(fields, PeerDevKeys.RsPausedMs, time.Millisecond)
+	r.SameChecksumKiB.set(fields, PeerDevKeys.RsSameCsum)
+	r.Interval.set(fields, PeerDevKeys.RsDt0Ms, time.Millisecond)
+	r.WantKiBPerSecond.set(fields, PeerDevKeys.Want)
+	r.Done.set(fields, PeerDevKeys.Done)
+	r.ETA.set(fields, PeerDevKeys.ETA, time.Second)
+}
+
 // Significantly faster than using time.Parse since we have a fixed format: "2006-01-02T15:04:05.000000-07:00"
 // Adapted from http://stackoverflow.com/questions/27216457/best-way-of-parsing-date-and-time-in-golang
 func fastTimeParse(date string) (time.Time, error) {
@@ -19,6 +19,7 @@
 package resource
 
 import (
+	"math"
 	"reflect"
 	"strconv"
 	"testing"
@@ -61,8 +62,9 @@ func TestUpdateTime(t *testing.T) {
 
 func TestMaxAvgCurrent(t *testing.T) {
 	stats := newMinMaxAvgCurrent()
+	now := time.Date(2017, 2, 15, 14, 43, 16, 0, time.UTC)
 
-	stats.calculate("5")
+	stats.calculate(now, "5")
 
 	if stats.Max != 5 {
 		t.Errorf("Expected Max to be %d, got %d", 5, stats.Max)
@@ -77,7 +79,7 @@ func TestMaxAvgCurrent(t *testing.T) {
 		t.Errorf("Expected Current to be %d, got %d", 5, stats.Current)
 	}
 
-	stats.calculate("10")
+	stats.calculate(now.Add(time.Second), "10")
 
 	if stats.Max != 10 {
 		t.Errorf("Expected Max to be %d, got %d", 10, stats.Max)
@@ -93,6 +95,81 @@ func TestMaxAvgCurrent(t *testing.T) {
 	}
 }
 
+func TestMaxAvgCurrentWindow(t *testing.T) {
+	defer SetStatsWindow(statsWindow)
+	SetStatsWindow(time.Minute)
+
+	stats := newMinMaxAvgCurrent()
+	if stats.Min != 0 {
+		t.Errorf("Expected Min to be %d without samples, got %d", 0, stats.Min)
+	}
+
+	now := time.Date(2017, 2, 15, 14, 43, 16, 0, time.UTC)
+	// A spike that leaves the window.
+	stats.calculate(now, "1000")
+	for i := 1; i <= 100; i++ {
+		stats.calculate(now.Add(time.Minute+time.Duration(i)*time.Second/10), strconv.Itoa(i))
+	}
+
+	if stats.Max != 100 {
+		t.Errorf("Expected the spike to leave the window, Max is %d", stats.Max)
+	}
+	if stats.Min != 1 {
+		t.Errorf("Expected Min to be %d, got %d", 1, stats.Min)
+	}
+	if stats.Avg != 50.5 {
+		t.Errorf("Expected Avg to be %f, got %f", 50.5, stats.Avg)
+	}
+	if p := stats.Percentiles(); p != (Percentiles{P50: 50, P95: 95, P99: 99}) {
+		t.Errorf("Expected percentiles 50/95/99, got %d/%d/%d", p.P50, p.P95, p.P99)
+	}
+
+	// Samples without a value are ignored.
+	stats.calculate(now.Add(2*time.Minute), "")
+	if stats.Current != 100 {
+		t.Errorf("Expected Current to be %d, got %d", 100, stats.Current)
+	}
+
+	stats.reset()
+	if stats.Min != 100 || stats.Max != 100 || stats.Avg != 100 || stats.Percentiles().P50 != 100 {
+		t.Errorf("Expected the statistics to start over with the current value, got %+v", stats)
+	}
+}
+
+func TestMaxAvgCurrentSliding(t *testing.T) {
+	defer SetStatsWindow(statsWindow)
+	SetStatsWindow(10 * time.Second)
+
+	stats := newMinMaxAvgCurrent()
+	now := time.Date(2017, 2, 15, 14, 43, 16, 0, time.UTC)
+	var values []uint64
+	for i := 0; i < 200; i++ {
+		v := uint64((i * 37) % 101)
+		values = append(values, v)
+		stats.calculate(now.Add(time.Duration(i)*time.Second), strconv.FormatUint(v, 10))
+
+		// The window covers the last 11 samples.
+		window := values
+		if len(window) > 11 {
+			window = window[len(window)-11:]
+		}
+		min, max, total := window[0], window[0], uint64(0)
+		for _, w := range window {
+			if w < min {
+				min = w
+			}
+			if w > max {
+				max = w
+			}
+			total += w
+		}
+		avg := float64(total) / float64(len(window))
+		if stats.Min != min || stats.Max != max || stats.Avg != avg {
+			t.Fatalf("Sample %d: expected min/max/avg %d/%d/%f, got %d/%d/%f", i, min, max, avg, stats.Min, stats.Max, stats.Avg)
+		}
+	}
+}
+
 func TestRate(t *testing.T) {
 	r := &rate{Previous: &previousFloat64{maxLen: 5}, new: true}
 
@@ -133,7 +210,12 @@ func TestRate(t *testing.T) {
 	if r.Total != 100 {
 		t.Errorf("Expected total to be %d, got %d", 100, r.Total)
 	}
+	// The averages start out with the first rate.
+	if r.Avg1m != 100 || r.Avg5m != 100 || r.Avg15m != 100 {
+		t.Errorf("Expected averages to be %d, got %f/%f/%f", 100, r.Avg1m, r.Avg5m, r.Avg15m)
+	}
 
+	// The rate is the one of the last interval, not the average since the start.
 	r.calculate(time.Second*2, "200")
 
 	if r.initial != 100 {
@@ -142,11 +224,17 @@ func TestRate(t *testing.T) {
 	if r.last != 200 {
 		t.Errorf("Expected current to be %d, got %d", 200, r.last)
 	}
-	if !reflect.DeepEqual(r.Previous.Values, []float64{0, 100, 50}) {
-		t.Errorf("Expected Previous.Values to be %v, got %v", []float64{0, 100, 50}, r.Previous.Values)
+	if !reflect.DeepEqual(r.Previous.Values, []float64{0, 100, 0}) {
+		t.Errorf("Expected Previous.Values to be %v, got %v", []float64{0, 100, 0}, r.Previous.Values)
 	}
-	if r.PerSecond != float64(50) {
-		t.Errorf("Expected PerSecond to be %d, got %f", 100, r.PerSecond)
+	if r.PerSecond != 0 {
+		t.Errorf("Expected PerSecond to be %d, got %f", 0, r.PerSecond)
+	}
+	if avg := 100 * math.Exp(-1.0/60); math.Abs(r.Avg1m-avg) > 1e-9 {
+		t.Errorf("Expected Avg1m to be %f, got %f", avg, r.Avg1m)
+	}
+	if !(r.Avg1m < r.Avg5m && r.Avg5m < r.Avg15m && r.Avg15m < 100) {
+		t.Errorf("Expected longer averages to decay slower, got %f/%f/%f", r.Avg1m, r.Avg5m, r.Avg15m)
 	}
 	if r.Total != 100 {
 		t.Errorf("Expected total to be %d, got %d", 100, r.Total)
@@ -169,6 +257,25 @@ func TestRate(t *testing.T) {
 	if r.Total != 150 {
 		t.Errorf("Failed to reset total value, total is %d, expected %d: %v", r.Total, 150, r)
 	}
+	if r.PerSecond != 50 {
+		t.Errorf("Expected PerSecond after a counter reset to be %d, got %f", 50, r.PerSecond)
+	}
+
+	// Several samples at the same time and samples without statistics don't
+	// change the rate.
+	r.calculate(time.Second*4, "60")
+	r.calculate(time.Second*5, "")
+	if r.PerSecond != 50 || r.Total != 160 {
+		t.Errorf("Expected PerSecond %d and total %d, got %f and %d", 50, 160, r.PerSecond, r.Total)
+	}
+	r.calculate(time.Second*5, "70")
+	if r.PerSecond != 20 {
+		t.Errorf("Expected PerSecond to include the sample at the same time, got %f", r.PerSecond)
+	}
+	r.calculate(time.Second*6, "70")
+	if r.PerSecond != 0 || r.Total != 170 {
+		t.Errorf("Expected PerSecond %d and total %d, got %f and %d", 0, 170, r.PerSecond, r.Total)
+	}
 }
 
 func TestPreviousFloat64(t *testing.T) {
@@ -380,6 +487,153 @@ func TestPeerDeviceUpdate(t *testing.T) {
 	}
 }
 
+// Output of drbdsetup events2 --timestamps --statistics --now from DRBD 9.1 and 9.2.
+const (
+	drbd91Resource   = "2021-03-10T09:31:04.412343+01:00 exists resource name:r0 role:Secondary suspended:no force-io-failures:no may_promote:yes promotion_score:10101 write-ordering:flush"
+	drbd91Connection = "2021-03-10T09:31:04.412343+01:00 exists connection name:r0 peer-node-id:1 conn-name:alpha connection:Connected role:Secondary congested:no ap-in-flight:0 rs-in-flight:0"
+	drbd91Device     = "2021-03-10T09:31:04.412343+01:00 exists device name:r0 volume:0 minor:1000 disk:UpToDate client:no quorum:yes size:1048576 read:8741 written:524288 al-writes:17 bm-writes:0 upper-pending:0 lower-pending:0 al-suspended:no blocked:no"
+	drbd91PeerDevice = "2021-03-10T09:31:04.412343+01:00 exists peer-device name:r0 peer-node-id:1 conn-name:alpha volume:0 replication:Established peer-disk:UpToDate peer-client:no resync-suspended:no received:0 sent:1048576 out-of-sync:0 pending:0 unacked:0 rs-total:1048576 rs-dt-start-ms:50004 rs-paused-ms:0 rs-same-csum:0 rs-dt0-ms:0 rs-db0-sectors:0 rs-dt1-ms:0 rs-db1-sectors:0 rs-failed:0"
+
+	drbd92Resource   = "2023-06-21T14:02:11.904113+02:00 exists resource name:r0 role:Primary suspended:no force-io-failures:no may_promote:no promotion_score:10102 write-ordering:flush"
+	drbd92Connection = "2023-06-21T14:02:11.904113+02:00 exists connection name:r0 peer-node-id:1 conn-name:alpha connection:Connected role:Secondary congested:no ap-in-flight:1024 rs-in-flight:4096"
+	drbd92Device     = "2023-06-21T14:02:11.904113+02:00 exists device name:r0 volume:0 minor:1000 backing_dev:/dev/drbdpool/r0_00000 disk:UpToDate client:no quorum:yes open:yes size:1048576 read:8741 written:524288 al-writes:17 bm-writes:0 upper-pending:0 lower-pending:0 al-suspended:no blocked:no"
+	drbd92PeerDevice = "2023-06-21T14:02:11.904113+02:00 exists peer-device name:r0 peer-node-id:1 conn-name:alpha volume:0 replication:SyncSource peer-disk:Inconsistent peer-client:no resync-suspended:no received:0 sent:262144 out-of-sync:786432 pending:0 unacked:0 done:25.00 eta:12 dbdt1:21560 rs-total:1048576 rs-dt-start-ms:12053 rs-paused-ms:250 rs-same-csum:4096 rs-dt0-ms:3000 rs-db0-sectors:131072 rs-dt1-ms:3000 rs-db1-sectors:131072 rs-failed:0 want:20480"
+)
+
+func newTestEvent(t *testing.T, s string) Event {
+	e, err := NewEvent(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestDrbd9Statistics(t *testing.T) {
+	// DRBD 9.1
+	res := Resource{}
+	res.Update(newTestEvent(t, drbd91Resource))
+	if res.MayPromote != (OptBool{Value: true, Present: true}) {
+		t.Errorf("Expected may_promote to be yes, got %+v", res.MayPromote)
+	}
+	if res.PromotionScore != (OptUint64{Value: 10101, Present: true}) {
+		t.Errorf("Expected promotion_score to be 10101, got %+v", res.PromotionScore)
+	}
+
+	conn := Connection{}
+	conn.Update(newTestEvent(t, drbd91Connection))
+	if conn.APInFlightKiB != (OptUint64{Value: 0, Present: true}) || conn.RSInFlightKiB != (OptUint64{Value: 0, Present: true}) {
+		t.Errorf("Expected nothing in flight, got %+v/%+v", conn.APInFlightKiB, conn.RSInFlightKiB)
+	}
+
+	dev := NewDevice()
+	dev.Update(newTestEvent(t, drbd91Device))
+	if dev.Volumes["0"].Open.Present {
+		t.Errorf("Expected open to be absent, got %+v", dev.Volumes["0"].Open)
+	}
+
+	peerDev := NewPeerDevice()
+	peerDev.Update(newTestEvent(t, drbd91PeerDevice))
+	rs := peerDev.Volumes["0"].ResyncStats
+	expected := ResyncStats{
+		TotalKiB:        OptUint64{Value: 1048576, Present: true},
+		SinceStart:      OptDuration{Value: 50004 * time.Millisecond, Present: true},
+		Paused:          OptDuration{Value: 0, Present: true},
+		SameChecksumKiB: OptUint64{Value: 0, Present: true},
+		Interval:        OptDuration{Value: 0, Present: true},
+	}
+	if rs != expected {
+		t.Errorf("Expected resync statistics %+v, got %+v", expected, rs)
+	}
+
+	// DRBD 9.2
+	res.Update(newTestEvent(t, drbd92Resource))
+	if res.MayPromote != (OptBool{Value: false, Present: true}) {
+		t.Errorf("Expected may_promote to be no, got %+v", res.MayPromote)
+	}
+	if res.PromotionScore.Value != 10102 {
+		t.Errorf("Expected promotion_score to be 10102, got %+v", res.PromotionScore)
+	}
+
+	conn.Update(newTestEvent(t, drbd92Connection))
+	if conn.APInFlightKiB.Value != 1024 || conn.RSInFlightKiB.Value != 4096 {
+		t.Errorf("Expected 1024/4096 in flight, got %+v/%+v", conn.APInFlightKiB, conn.RSInFlightKiB)
+	}
+
+	dev.Update(newTestEvent(t, drbd92Device))
+	if dev.Volumes["0"].Open != (OptBool{Value: true, Present: true}) {
+		t.Errorf("Expected open to be yes, got %+v", dev.Volumes["0"].Open)
+	}
+
+	peerDev.Update(newTestEvent(t, drbd92PeerDevice))
+	rs = peerDev.Volumes["0"].ResyncStats
+	expected = ResyncStats{
+		TotalKiB:         OptUint64{Value: 1048576, Present: true},
+		SinceStart:       OptDuration{Value: 12053 * time.Millisecond, Present: true},
+		Paused:           OptDuration{Value: 250 * time.Millisecond, Present: true},
+		SameChecksumKiB:  OptUint64{Value: 4096, Present: true},
+		Interval:         OptDuration{Value: 3000 * time.Millisecond, Present: true},
+		WantKiBPerSecond: OptUint64{Value: 20480, Present: true},
+		Done:             OptFloat64{Value: 25, Present: true},
+		ETA:              OptDuration{Value: 12 * time.Second, Present: true},
+	}
+	if rs != expected {
+		t.Errorf("Expected resync statistics %+v, got %+v", expected, rs)
+	}
+
+	// A resync that is done leaves only its totals behind.
+	peerDev.Update(newTestEvent(t, "2023-06-21T14:02:20.000000+02:00 change peer-device name:r0 peer-node-id:1 conn-name:alpha "+
+		"volume:0 replication:Established peer-disk:UpToDate"))
+	rs = peerDev.Volumes["0"].ResyncStats
+	if rs.Done.Present || rs.ETA.Present || rs.WantKiBPerSecond.Present {
+		t.Errorf("Expected no resync progress, got %+v", rs)
+	}
+	if rs.TotalKiB != (OptUint64{Value: 1048576, Present: true}) {
+		t.Errorf("Expected rs-total to be kept, got %+v", rs.TotalKiB)
+	}
+
+	// Older versions report none of the fields.
+	res = Resource{}
+	res.Update(newTestEvent(t, "2017-02-15T12:57:53.000000-08:00 exists resource name:r0 role:Primary suspended:no write-ordering:flush"))
+	if res.MayPromote.Present || res.PromotionScore.Present {
+		t.Errorf("Expected may_promote and promotion_score to be absent, got %+v/%+v", res.MayPromote, res.PromotionScore)
+	}
+	peerDev = NewPeerDevice()
+	peerDev.Update(newTestEvent(t, "2017-02-15T14:43:16.688437+00:00 exists peer-device name:r0 conn-name:alpha "+
+		"volume:0 replication:Established peer-disk:UpToDate resync-suspended:no received:0 sent:0 out-of-sync:0 pending:0 unacked:0"))
+	if rs := peerDev.Volumes["0"].ResyncStats; rs != (ResyncStats{}) {
+		t.Errorf("Expected no resync statistics, got %+v", rs)
+	}
+}
+
+func TestOptionalFieldsKept(t *testing.T) {
+	dev := NewDevice()
+	dev.Update(newTestEvent(t, drbd92Device))
+
+	// Changes only carry the fields that changed.
+	dev.Update(newTestEvent(t, "2023-06-21T14:02:12.000000+02:00 change device name:r0 volume:0 minor:1000 disk:Outdated"))
+	if dev.Volumes["0"].Open != (OptBool{Value: true, Present: true}) {
+		t.Errorf("Expected open to still be yes, got %+v", dev.Volumes["0"].Open)
+	}
+
+	dev.Update(newTestEvent(t, "2023-06-21T14:02:13.000000+02:00 change device name:r0 volume:0 minor:1000 open:no"))
+	if dev.Volumes["0"].Open != (OptBool{Value: false, Present: true}) {
+		t.Errorf("Expected open to be no, got %+v", dev.Volumes["0"].Open)
+	}
+
+	conn := Connection{}
+	conn.Update(newTestEvent(t, drbd92Connection))
+	conn.Update(newTestEvent(t, "2023-06-21T14:02:12.000000+02:00 change connection name:r0 peer-node-id:1 conn-name:alpha connection:Connected role:Primary"))
+	if conn.APInFlightKiB.Value != 1024 || conn.RSInFlightKiB.Value != 4096 {
+		t.Errorf("Expected 1024/4096 to still be in flight, got %+v/%+v", conn.APInFlightKiB, conn.RSInFlightKiB)
+	}
+
+	// A value that can't be parsed is not reported.
+	conn.Update(newTestEvent(t, "2023-06-21T14:02:13.000000+02:00 change connection name:r0 peer-node-id:1 conn-name:alpha ap-in-flight:lots"))
+	if conn.APInFlightKiB.Present {
+		t.Errorf("Expected ap-in-flight to be absent, got %+v", conn.APInFlightKiB)
+	}
+}
+
 func TestNewEvent(t *testing.T) {
 
 	resTimeStamp0, err := fastTimeParse("2017-02-22T19:53:58.445263-08:00")
@@ -467,6 +721,32 @@ func TestNewEvent(t *testing.T) {
 	}
 }
 
+func TestEventString(t *testing.T) {
+	e := Event{
+		TimeStamp: time.Date(2017, 2, 22, 19, 53, 58, 445263000, time.FixedZone("", -8*60*60)),
+		EventType: "exists",
+		Target:    "resource",
+		Fields: map[string]string{
+			ResKeys.Unconfigured: "true",
+			ResKeys.Name:         "test3",
+			ResKeys.Role:         "Down",
+		},
+	}
+
+	expected := "2017-02-22T19:53:58.445263-08:00 exists resource name:test3 role:Down unconfigured:true"
+	if out := e.String(); out != expected {
+		t.Errorf("Expected: %q Got: %q", expected, out)
+	}
+
+	parsed, err := NewEvent(e.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed.Fields, e.Fields) || parsed.EventType != e.EventType || parsed.Target != e.Target {
+		t.Errorf("Expected %v to survive a round trip, got %v", e, parsed)
+	}
+}
+
 func TestConnectionDanger(t *testing.T) {
 	conn := Connection{}
 	event, err := NewEvent("2017-02-15T14:43:16.688437+00:00 exists connection " +
@@ -498,6 +778,87 @@ func TestConnectionDanger(t *testing.T) {
 	}
 }
 
+func TestParseAddress(t *testing.T) {
+	var addressTests = []struct {
+		in  string
+		out Address
+	}{
+		{"ipv4:10.43.70.115:7000", Address{Family: "ipv4", Host: "10.43.70.115", Port: "7000"}},
+		{"ipv6:[fe80::1]:7789", Address{Family: "ipv6", Host: "fe80::1", Port: "7789"}},
+	}
+	for _, tt := range addressTests {
+		a, err := ParseAddress(tt.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a != tt.out {
+			t.Errorf("ParseAddress: Expected %q to parse into %v, got %v", tt.in, tt.out, a)
+		}
+		if a.String() != tt.in {
+			t.Errorf("Expected %v to print as %q, got %q", a, tt.in, a.String())
+		}
+	}
+
+	if _, err := ParseAddress("10.43.70.115"); err == nil {
+		t.Error("Expected an address without family and port not to parse")
+	}
+}
+
+func TestConnectionPaths(t *testing.T) {
+	conn := Connection{}
+	event, err := NewEvent("2017-02-15T14:43:16.688437+00:00 exists connection " +
+		"name:test0 conn-name:peer connection:Connecting role:Unknown congested:no")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Update(event)
+	danger := conn.Danger
+
+	event, err = NewEvent("2017-02-15T14:43:16.688437+00:00 exists path name:test0 peer-node-id:1 " +
+		"conn-name:peer local:ipv4:10.0.0.1:7000 peer:ipv4:10.0.0.2:7000 established:no")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.UpdatePath(event)
+
+	if len(conn.Paths) != 1 {
+		t.Fatalf("Expected connection to have %d path, got %d", 1, len(conn.Paths))
+	}
+	for _, p := range conn.Paths {
+		if p.Local.Host != "10.0.0.1" || p.Peer.Host != "10.0.0.2" {
+			t.Errorf("Expected path from %q to %q, got %v -> %v", "10.0.0.1", "10.0.0.2", p.Local, p.Peer)
+		}
+	}
+	if conn.Danger != danger+1 {
+		t.Errorf("Expected connection without established paths to have a danger level of %d, got %d", danger+1, conn.Danger)
+	}
+
+	event, err = NewEvent("2017-02-15T14:43:17.688437+00:00 change path name:test0 peer-node-id:1 " +
+		"conn-name:peer local:ipv4:10.0.0.1:7000 peer:ipv4:10.0.0.2:7000 established:yes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.UpdatePath(event)
+
+	if len(conn.Paths) != 1 {
+		t.Fatalf("Expected connection to have %d path, got %d", 1, len(conn.Paths))
+	}
+	if conn.Danger != danger {
+		t.Errorf("Expected connection with an established path to have a danger level of %d, got %d", danger, conn.Danger)
+	}
+
+	event, err = NewEvent("2017-02-15T14:43:18.688437+00:00 destroy path name:test0 peer-node-id:1 " +
+		"conn-name:peer local:ipv4:10.0.0.1:7000 peer:ipv4:10.0.0.2:7000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.DestroyPath(event)
+
+	if len(conn.Paths) != 0 {
+		t.Errorf("Expected path to be destroyed, got %d paths", len(conn.Paths))
+	}
+}
+
 func TestDeviceDanger(t *testing.T) {
 	dev := NewDevice()
 
@@ -0,0 +1,157 @@
+/*
+ *drbdtop - statistics for DRBD
+ *Copyright © 2017 Hayley Swimelar and Roland Kammerer
+ *
+ *This program is free software; you can redistribute it and/or modify
+ *it under the terms of the GNU General Public License as published by
+ *the Free Software Foundation; either version 2 of the License, or
+ *(at your option) any later version.
+ *
+ *This program is distributed in the hope that it will be useful,
+ *but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ *GNU General Public License for more details.
+ *
+ *You should have received a copy of the GNU General Public License
+ *along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package resource
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// resyncSmoothing is the weight of a new sample in the smoothed resync rate.
+const resyncSmoothing = 0.2
+
+// Resync tracks the progress of a resynchronization between a local volume
+// and one of its peers, based on how fast the out-of-sync data shrinks.
+type Resync struct {
+	// Active is set while the replication state is one of the sync states.
+	Active bool
+	// Paused is set if the resync is suspended, e.g. because of a dependency
+	// or by the user.
+	Paused    bool
+	StartTime time.Time
+	// Data the resync has to transfer in total, as reported by DRBD 9. Else
+	// the out-of-sync data when drbdtop first saw the resync, which grows if
+	// the resync has more work to do than it started out with.
+	TotalKiB uint64
+	// Out-of-sync data left.
+	RemainingKiB uint64
+	// Smoothed rate of the resync.
+	KiBPerSecond float64
+	// Estimated time until the resync is done, zero if unknown.
+	ETA time.Duration
+
+	lastTime time.Time
+	lastKiB  uint64
+	sampled  bool
+}
+
+// isResyncState reports whether a replication state is part of a resync.
+func isResyncState(replication string) bool {
+	switch replication {
+	case "SyncSource", "SyncTarget", "PausedSyncS", "PausedSyncT":
+		return true
+	}
+	return false
+}
+
+// update the progress at time t. Events without out-of-sync statistics only
+// update the state of the resync.
+func (r *Resync) update(t time.Time, replication, suspended, outOfSync string) {
+	if !isResyncState(replication) {
+		*r = Resync{}
+		return
+	}
+
+	r.Paused = strings.HasPrefix(replication, "Paused") || (suspended != "" && suspended != "no")
+
+	if outOfSync == "" {
+		r.setETA()
+		return
+	}
+	oos, err := strconv.ParseUint(outOfSync, 10, 64)
+	if err != nil {
+		return
+	}
+
+	if !r.Active {
+		*r = Resync{
+			Active:       true,
+			Paused:       r.Paused,
+			StartTime:    t,
+			TotalKiB:     oos,
+			RemainingKiB: oos,
+			lastTime:     t,
+			lastKiB:      oos,
+		}
+		r.setETA()
+		return
+	}
+
+	if oos > r.TotalKiB {
+		r.TotalKiB = oos
+	}
+	r.RemainingKiB = oos
+
+	elapsed := t.Sub(r.lastTime).Seconds()
+	if elapsed <= 0 {
+		r.setETA()
+		return
+	}
+
+	// While paused, nothing is expected to happen, keep the last rate around
+	// for when the resync continues.
+	if !r.Paused {
+		var current float64
+		if oos < r.lastKiB {
+			current = float64(r.lastKiB-oos) / elapsed
+		}
+		if r.sampled {
+			r.KiBPerSecond += resyncSmoothing * (current - r.KiBPerSecond)
+		} else {
+			r.KiBPerSecond = current
+			r.sampled = true
+		}
+	}
+	r.lastTime = t
+	r.lastKiB = oos
+
+	r.setETA()
+}
+
+// useReported takes the start and the total of a running resync from the
+// statistics DRBD 9 reports at time t, if any. They also cover the part of the
+// resync before drbdtop saw it.
+func (r *Resync) useReported(t time.Time, fields map[string]string) {
+	if !r.Active {
+		return
+	}
+	if total, err := strconv.ParseUint(fields[PeerDevKeys.RsTotal], 10, 64); err == nil && total >= r.RemainingKiB {
+		r.TotalKiB = total
+	}
+	if ms, err := strconv.ParseUint(fields[PeerDevKeys.RsDtStartMs], 10, 64); err == nil {
+		r.StartTime = t.Add(-time.Duration(ms) * time.Millisecond)
+	}
+}
+
+func (r *Resync) setETA() {
+	if r.Paused || r.KiBPerSecond <= 0 {
+		r.ETA = 0
+		return
+	}
+	r.ETA = time.Duration(float64(r.RemainingKiB) / r.KiBPerSecond * float64(time.Second))
+}
+
+// Done returns the percentage of the resync that is done.
+func (r *Resync) Done() float64 {
+	if r.TotalKiB == 0 {
+		return 100
+	}
+	return float64(r.TotalKiB-r.RemainingKiB) / float64(r.TotalKiB) * 100
+}
@@ -0,0 +1,108 @@
+/*
+ *drbdtop - statistics for DRBD
+ *Copyright © 2017 Hayley Swimelar and Roland Kammerer
+ *
+ *This program is free software; you can redistribute it and/or modify
+ *it under the terms of the GNU General Public License as published by
+ *the Free Software Foundation; either version 2 of the License, or
+ *(at your option) any later version.
+ *
+ *This program is distributed in the hope that it will be useful,
+ *but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ *GNU General Public License for more details.
+ *
+ *You should have received a copy of the GNU General Public License
+ *along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package resource
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResync(t *testing.T) {
+	start := time.Date(2017, 2, 15, 14, 43, 16, 0, time.UTC)
+	r := Resync{}
+
+	r.update(start, "Established", "no", "0")
+	if r.Active {
+		t.Fatal("Expected no resync while established")
+	}
+
+	r.update(start, "SyncSource", "no", "100000")
+	if !r.Active || !r.StartTime.Equal(start) || r.TotalKiB != 100000 {
+		t.Fatalf("Expected a resync of 100000KiB started at %s, got %+v", start, r)
+	}
+	if r.ETA != 0 {
+		t.Errorf("Expected an unknown ETA without a rate, got %s", r.ETA)
+	}
+
+	r.update(start.Add(10*time.Second), "SyncSource", "no", "90000")
+	if r.KiBPerSecond != 1000 {
+		t.Errorf("Expected 1000KiB/s, got %f", r.KiBPerSecond)
+	}
+	if r.ETA != 90*time.Second {
+		t.Errorf("Expected an ETA of 90s, got %s", r.ETA)
+	}
+	if r.Done() != 10 {
+		t.Errorf("Expected 10%% done, got %f", r.Done())
+	}
+
+	// Events without statistics do not count as samples.
+	r.update(start.Add(15*time.Second), "SyncSource", "no", "")
+	if r.KiBPerSecond != 1000 || r.RemainingKiB != 90000 {
+		t.Errorf("Expected an event without statistics to be ignored, got %+v", r)
+	}
+
+	// The rate is smoothed.
+	r.update(start.Add(20*time.Second), "SyncSource", "no", "70000")
+	if r.KiBPerSecond != 1200 {
+		t.Errorf("Expected a smoothed rate of 1200KiB/s, got %f", r.KiBPerSecond)
+	}
+
+	r.update(start.Add(30*time.Second), "PausedSyncS", "no", "70000")
+	if !r.Paused || r.ETA != 0 || r.KiBPerSecond != 1200 {
+		t.Errorf("Expected a paused resync to keep its rate without an ETA, got %+v", r)
+	}
+
+	r.update(start.Add(40*time.Second), "SyncSource", "user", "70000")
+	if !r.Paused {
+		t.Error("Expected a resync suspended by the user to be paused")
+	}
+
+	r.update(start.Add(50*time.Second), "Established", "no", "0")
+	if r.Active || r.TotalKiB != 0 {
+		t.Errorf("Expected a finished resync to be reset, got %+v", r)
+	}
+}
+
+func TestResyncReported(t *testing.T) {
+	now := time.Date(2017, 2, 15, 14, 43, 16, 0, time.UTC)
+	r := Resync{}
+
+	// The resync started a minute before drbdtop saw it.
+	r.update(now, "SyncTarget", "no", "40000")
+	r.useReported(now, map[string]string{"rs-total": "100000", "rs-dt-start-ms": "60000"})
+	if start := now.Add(-time.Minute); !r.StartTime.Equal(start) || r.TotalKiB != 100000 {
+		t.Fatalf("Expected a resync of 100000KiB started at %s, got %+v", start, r)
+	}
+	if r.Done() != 60 {
+		t.Errorf("Expected 60%% done, got %f", r.Done())
+	}
+
+	// Without the statistics the estimates are kept.
+	r.update(now.Add(10*time.Second), "SyncTarget", "no", "30000")
+	r.useReported(now.Add(10*time.Second), map[string]string{})
+	if !r.StartTime.Equal(now.Add(-time.Minute)) || r.TotalKiB != 100000 {
+		t.Errorf("Expected the reported start and total to be kept, got %+v", r)
+	}
+
+	r.update(now.Add(20*time.Second), "Established", "no", "0")
+	r.useReported(now.Add(20*time.Second), map[string]string{"rs-total": "100000", "rs-dt-start-ms": "80000"})
+	if r.Active || r.TotalKiB != 0 || !r.StartTime.IsZero() {
+		t.Errorf("Expected no resync after it finished, got %+v", r)
+	}
+}
@@ -0,0 +1,221 @@
+/*
+ *drbdtop - statistics for DRBD
+ *Copyright © 2017 Hayley Swimelar and Roland Kammerer
+ *
+ *This program is free software; you can redistribute it and/or modify
+ *it under the terms of the GNU General Public License as published by
+ *the Free Software Foundation; either version 2 of the License, or
+ *(at your option) any later version.
+ *
+ *This program is distributed in the hope that it will be useful,
+ *but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ *GNU General Public License for more details.
+ *
+ *You should have received a copy of the GNU General Public License
+ *along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package resource
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+)
+
+// Threshold assigns a danger score to values of a numeric metric that are at least Min.
+type Threshold struct {
+	Min   uint64 `json:"min"`
+	Score uint64 `json:"score"`
+}
+
+// Scores are the danger scores of states and numeric metrics. State scores are
+// looked up by the state's name, the "default" key is used for unlisted states.
+type Scores struct {
+	ConnectionStates map[string]uint64 `json:"connection_states,omitempty"`
+	DiskStates       map[string]uint64 `json:"disk_states,omitempty"`
+	Roles            map[string]uint64 `json:"roles,omitempty"`
+	Quorum           map[string]uint64 `json:"quorum,omitempty"`
+	Congested        map[string]uint64 `json:"congested,omitempty"`
+	// Keyed by whether any of the paths of a connection is established ("yes" or "no").
+	Paths map[string]uint64 `json:"paths,omitempty"`
+
+	// The highest threshold a value reaches determines its score.
+	OutOfSyncKiB  []Threshold `json:"out_of_sync_kib,omitempty"`
+	PendingWrites []Threshold `json:"pending_writes,omitempty"`
+	UnackedWrites []Threshold `json:"unacked_writes,omitempty"`
+}
+
+// ScoringProfile is the set of Scores used to calculate danger, with optional
+// overrides for specific resources and connection names. A connection name
+// override takes precedence over a resource override.
+type ScoringProfile struct {
+	Scores
+	Resources       map[string]*Scores `json:"resources,omitempty"`
+	ConnectionNames map[string]*Scores `json:"connection_names,omitempty"`
+}
+
+// DefaultScoringProfile returns the built in ScoringProfile.
+func DefaultScoringProfile() *ScoringProfile {
+	return &ScoringProfile{
+		Scores: Scores{
+			ConnectionStates: map[string]uint64{
+				"Connected":  0,
+				"SyncSource": 1,
+				"SyncTarget": 1,
+				"StandAlone": 30,
+				"SplitBrain": 100,
+
+				"default": 1,
+			},
+			DiskStates: map[string]uint64{
+				"UpToDate":   0,
+				"Consistent": 1,
+				"Diskless":   16,
+				"Outdated":   1,
+				"DUnknown":   2,
+
+				"default": 1,
+			},
+			Roles: map[string]uint64{
+				"Primary":   0,
+				"Secondary": 0,
+				"Unknown":   1,
+				"Down":      10,
+
+				"default": 1,
+			},
+			Quorum: map[string]uint64{
+				"yes": 0,
+				"no":  30,
+
+				"default": 0,
+			},
+			Congested: map[string]uint64{
+				"no": 0,
+
+				"default": 1,
+			},
+			Paths: map[string]uint64{
+				"yes": 0,
+				"no":  1,
+			},
+			OutOfSyncKiB: logThresholds(),
+		},
+	}
+}
+
+// logThresholds scores values with their natural logarithm, rounded down.
+// Resources can be up to 1 PiB, so this will be at most 12 in practice.
+func logThresholds() []Threshold {
+	var t []Threshold
+	for score := 1; score <= 44; score++ {
+		t = append(t, Threshold{Min: uint64(math.Ceil(math.Exp(float64(score)))), Score: uint64(score)})
+	}
+	return t
+}
+
+var scoring = DefaultScoringProfile()
+
+// SetScoringProfile replaces the ScoringProfile used for all danger calculations.
+// It is meant to be called once, before any Events are processed.
+func SetScoringProfile(p *ScoringProfile) {
+	for _, s := range p.allScores() {
+		s.sortThresholds()
+	}
+	scoring = p
+}
+
+// LoadScoringProfile reads a JSON encoded ScoringProfile from path. Anything not
+// set in the file keeps its default value.
+func LoadScoringProfile(path string) (*ScoringProfile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	p := DefaultScoringProfile()
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(p); err != nil {
+		return nil, fmt.Errorf("Couldn't parse scoring profile %q: %v", path, err)
+	}
+
+	return p, nil
+}
+
+func (p *ScoringProfile) allScores() []*Scores {
+	all := []*Scores{&p.Scores}
+	for _, s := range p.Resources {
+		all = append(all, s)
+	}
+	for _, s := range p.ConnectionNames {
+		all = append(all, s)
+	}
+	return all
+}
+
+func (s *Scores) sortThresholds() {
+	for _, t := range [][]Threshold{s.OutOfSyncKiB, s.PendingWrites, s.UnackedWrites} {
+		sort.Slice(t, func(i, j int) bool { return t[i].Min < t[j].Min })
+	}
+}
+
+// chain returns the Scores that apply to a resource and connection, the most specific first.
+func (p *ScoringProfile) chain(res, conn string) []*Scores {
+	c := make([]*Scores, 0, 3)
+	if s, ok := p.ConnectionNames[conn]; ok && conn != "" {
+		c = append(c, s)
+	}
+	if s, ok := p.Resources[res]; ok {
+		c = append(c, s)
+	}
+	return append(c, &p.Scores)
+}
+
+func (p *ScoringProfile) state(get func(*Scores) map[string]uint64, res, conn, state string) uint64 {
+	chain := p.chain(res, conn)
+	for _, s := range chain {
+		if i, ok := get(s)[state]; ok {
+			return i
+		}
+	}
+	for _, s := range chain {
+		if i, ok := get(s)["default"]; ok {
+			return i
+		}
+	}
+	return 0
+}
+
+func (p *ScoringProfile) threshold(get func(*Scores) []Threshold, res, conn string, value uint64) uint64 {
+	for _, s := range p.chain(res, conn) {
+		t := get(s)
+		if t == nil {
+			continue
+		}
+		var score uint64
+		for _, th := range t {
+			if value < th.Min {
+				break
+			}
+			score = th.Score
+		}
+		return score
+	}
+	return 0
+}
+
+func connectionStates(s *Scores) map[string]uint64 { return s.ConnectionStates }
+func diskStates(s *Scores) map[string]uint64       { return s.DiskStates }
+func roles(s *Scores) map[string]uint64            { return s.Roles }
+func quorum(s *Scores) map[string]uint64           { return s.Quorum }
+func congested(s *Scores) map[string]uint64        { return s.Congested }
+func paths(s *Scores) map[string]uint64            { return s.Paths }
+func outOfSyncKiB(s *Scores) []Threshold           { return s.OutOfSyncKiB }
+func pendingWrites(s *Scores) []Threshold          { return s.PendingWrites }
+func unackedWrites(s *Scores) []Threshold          { return s.UnackedWrites }
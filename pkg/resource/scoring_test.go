@@ -0,0 +1,146 @@
+/*
+ *drbdtop - statistics for DRBD
+ *Copyright © 2017 Hayley Swimelar and Roland Kammerer
+ *
+ *This program is free software; you can redistribute it and/or modify
+ *it under the terms of the GNU General Public License as published by
+ *the Free Software Foundation; either version 2 of the License, or
+ *(at your option) any later version.
+ *
+ *This program is distributed in the hope that it will be useful,
+ *but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ *GNU General Public License for more details.
+ *
+ *You should have received a copy of the GNU General Public License
+ *along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package resource
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDefaultOutOfSyncScores(t *testing.T) {
+	p := DefaultScoringProfile()
+
+	for _, oos := range []uint64{0, 1, 2, 3, 20, 21, 1023, 1024, 1 << 40, 1 << 50} {
+		expected := uint64(0)
+		if oos != 0 {
+			expected = uint64(math.Log(float64(oos)))
+		}
+		score := p.threshold(outOfSyncKiB, "r0", "peer", oos)
+		if score != expected {
+			t.Errorf("Expected %d KiB out of sync to score %d, got %d", oos, expected, score)
+		}
+	}
+}
+
+func TestScoringProfileOverrides(t *testing.T) {
+	p := DefaultScoringProfile()
+	p.Resources = map[string]*Scores{
+		"dr": {DiskStates: map[string]uint64{"Outdated": 0}},
+	}
+	p.ConnectionNames = map[string]*Scores{
+		"backup": {
+			DiskStates:    map[string]uint64{"default": 5},
+			PendingWrites: []Threshold{{Min: 10, Score: 2}, {Min: 100, Score: 20}},
+		},
+	}
+
+	var stateTests = []struct {
+		res, conn, state string
+		out              uint64
+	}{
+		{"r0", "peer", "Outdated", 1},
+		{"dr", "peer", "Outdated", 0},
+		{"dr", "peer", "UpToDate", 0},
+		// An override's default only applies to states nobody scores explicitly.
+		{"r0", "backup", "Outdated", 1},
+		{"r0", "backup", "Inconsistent", 5},
+		{"dr", "backup", "Outdated", 0},
+	}
+	for _, tt := range stateTests {
+		score := p.state(diskStates, tt.res, tt.conn, tt.state)
+		if score != tt.out {
+			t.Errorf("Expected %s on %s/%s to score %d, got %d", tt.state, tt.res, tt.conn, tt.out, score)
+		}
+	}
+
+	var thresholdTests = []struct {
+		conn  string
+		value uint64
+		out   uint64
+	}{
+		{"peer", 1000, 0},
+		{"backup", 9, 0},
+		{"backup", 10, 2},
+		{"backup", 500, 20},
+	}
+	for _, tt := range thresholdTests {
+		score := p.threshold(pendingWrites, "r0", tt.conn, tt.value)
+		if score != tt.out {
+			t.Errorf("Expected %d pending writes on %s to score %d, got %d", tt.value, tt.conn, tt.out, score)
+		}
+	}
+}
+
+func TestLoadScoringProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "drbdtop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "scoring.json")
+	profile := `{
+		"congested": {"default": 30},
+		"unacked_writes": [{"min": 1000, "score": 10}, {"min": 100, "score": 1}],
+		"connection_names": {"dr-site": {"disk_states": {"Outdated": 0}}}
+	}`
+	if err := ioutil.WriteFile(path, []byte(profile), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := LoadScoringProfile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Keys not set in the file keep their defaults.
+	if p.Congested["no"] != 0 || p.Congested["default"] != 30 {
+		t.Errorf("Expected congestion scores to be merged with the defaults, got %v", p.Congested)
+	}
+	if p.ConnectionStates["StandAlone"] != 30 {
+		t.Errorf("Expected StandAlone to keep its default score, got %d", p.ConnectionStates["StandAlone"])
+	}
+
+	SetScoringProfile(p)
+	defer SetScoringProfile(DefaultScoringProfile())
+
+	dev := NewPeerDevice()
+	event, err := NewEvent("2017-03-27T12:39:29.346495-07:00 exists peer-device " +
+		"name:r0 peer-node-id:1 conn-name:dr-site volume:0 replication:Established " +
+		"peer-disk:Outdated peer-client:no resync-suspended:no received:0 sent:6278868 " +
+		"out-of-sync:0 pending:0 unacked:150")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev.Update(event)
+
+	if dev.Danger != 1 {
+		t.Errorf("Expected an Outdated DR peer with 150 unacked writes to have a danger level of %d, got %d", 1, dev.Danger)
+	}
+
+	if err := ioutil.WriteFile(path, []byte(`{"conection_states": {}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadScoringProfile(path); err == nil {
+		t.Error("Expected a misspelled key to be rejected")
+	}
+}
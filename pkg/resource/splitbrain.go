@@ -0,0 +1,158 @@
+/*
+ *drbdtop - statistics for DRBD
+ *Copyright © 2017 Hayley Swimelar and Roland Kammerer
+ *
+ *This program is free software; you can redistribute it and/or modify
+ *it under the terms of the GNU General Public License as published by
+ *the Free Software Foundation; either version 2 of the License, or
+ *(at your option) any later version.
+ *
+ *This program is distributed in the hope that it will be useful,
+ *but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ *GNU General Public License for more details.
+ *
+ *You should have received a copy of the GNU General Public License
+ *along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package resource
+
+import (
+	"fmt"
+	"time"
+)
+
+type helperKeys struct {
+	Name       string
+	PeerNodeID string
+	ConnName   string
+	Volume     string
+	Helper     string
+}
+
+// HelperKeys is a data container for the field keys of helper Events.
+var HelperKeys = helperKeys{"name", "peer-node-id", "conn-name", "volume", "helper"}
+
+type splitBrainKeys struct {
+	Name     string
+	ConnName string
+	Reason   string
+}
+
+// SplitBrainKeys is a data container for the field keys of split-brain Events,
+// which drbdtop generates itself.
+var SplitBrainKeys = splitBrainKeys{"name", "conn-name", "reason"}
+
+// Reasons for assuming a split brain.
+const (
+	// DRBD called the split-brain handler.
+	SplitBrainHelper = "helper"
+	// The kernel log reports a split brain.
+	SplitBrainKernel = "kernel"
+	// The kernel log reports that the data generation UUIDs conflict.
+	SplitBrainUUIDs = "uuids"
+	// The connection went StandAlone on its own. Could be a split brain, but
+	// also a manual disconnect.
+	SplitBrainStandAlone = "standalone"
+)
+
+// SplitBrain describes why a connection is thought to be split brained.
+type SplitBrain struct {
+	Since  time.Time
+	Reason string
+	// Set if DRBD reported the split brain, rather than it being guessed
+	// from the connection going StandAlone.
+	Confirmed bool
+}
+
+func (s *SplitBrain) String() string {
+	switch s.Reason {
+	case SplitBrainHelper:
+		return "DRBD called the split-brain handler"
+	case SplitBrainKernel:
+		return "the kernel log reports a split brain"
+	case SplitBrainUUIDs:
+		return "the data generation UUIDs conflict"
+	case SplitBrainStandAlone:
+		return "the connection went StandAlone"
+	}
+	return s.Reason
+}
+
+// NewSplitBrainEvent returns an Event reporting a split brain of the
+// connection conn of the resource res that was found outside of the events2
+// stream, e.g. in the kernel log.
+func NewSplitBrainEvent(res, conn, reason string) Event {
+	return Event{
+		TimeStamp: time.Now(),
+		EventType: "exists",
+		Target:    "split-brain",
+		Fields: map[string]string{
+			SplitBrainKeys.Name:     res,
+			SplitBrainKeys.ConnName: conn,
+			SplitBrainKeys.Reason:   reason,
+		},
+	}
+}
+
+// IsSplitBrain reports whether e reports a split brain, e.g. because DRBD
+// called the split-brain handler.
+func IsSplitBrain(e Event) bool {
+	switch e.Target {
+	case "helper":
+		helper := e.Fields[HelperKeys.Helper]
+		return e.EventType == "call" && (helper == "split-brain" || helper == "initial-split-brain")
+	case "split-brain":
+		return true
+	}
+	return false
+}
+
+// SetSplitBrain marks the Connection as split brained, as reported by the
+// Event e for which IsSplitBrain is true. Connected connections are not
+// split brained anymore, which is the case for reports about the past. A
+// report that the connection went StandAlone is only a suspicion, which
+// doesn't replace what is known already.
+func (c *Connection) SetSplitBrain(e Event) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.ConnectionStatus == "Connected" {
+		return
+	}
+
+	reason := SplitBrainHelper
+	if e.Target == "split-brain" {
+		reason = e.Fields[SplitBrainKeys.Reason]
+	}
+	confirmed := reason != SplitBrainStandAlone
+	if !confirmed && c.SplitBrain != nil {
+		return
+	}
+	c.SplitBrain = &SplitBrain{Since: e.TimeStamp, Reason: reason, Confirmed: confirmed}
+	c.setDanger()
+	c.connStatusExplanation()
+}
+
+// updateSplitBrain updates the split brain state after the connection
+// changed from the state prev.
+func (c *Connection) updateSplitBrain(e Event, prev string) {
+	switch {
+	case c.ConnectionStatus == "Connected":
+		c.SplitBrain = nil
+	case c.ConnectionStatus == "StandAlone" && e.EventType == "change" &&
+		prev != "" && prev != "StandAlone" && c.SplitBrain == nil:
+		c.SplitBrain = &SplitBrain{Since: e.TimeStamp, Reason: SplitBrainStandAlone}
+	}
+}
+
+// splitBrainHint explains the state of a split brained connection.
+func (c *Connection) splitBrainHint() string {
+	if c.SplitBrain.Confirmed {
+		return fmt.Sprintf("split brain with %s: %s. resolve it by discarding the data of one side instead of connecting",
+			c.ConnectionName, c.SplitBrain)
+	}
+	return fmt.Sprintf("dropped connection to %s. check the kernel log for a split brain before running drbdadm connect %s",
+		c.ConnectionName, c.Resource)
+}
@@ -0,0 +1,118 @@
+/*
+ *drbdtop - statistics for DRBD
+ *Copyright © 2017 Hayley Swimelar and Roland Kammerer
+ *
+ *This program is free software; you can redistribute it and/or modify
+ *it under the terms of the GNU General Public License as published by
+ *the Free Software Foundation; either version 2 of the License, or
+ *(at your option) any later version.
+ *
+ *This program is distributed in the hope that it will be useful,
+ *but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ *GNU General Public License for more details.
+ *
+ *You should have received a copy of the GNU General Public License
+ *along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package resource
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSplitBrainHelper(t *testing.T) {
+	conn := Connection{}
+	conn.Update(newTestEvent(t, "2017-02-15T14:43:16.688437+00:00 exists connection "+
+		"name:test0 conn-name:peer connection:Connected role:Secondary congested:no"))
+
+	helper := newTestEvent(t, "2017-02-15T14:43:17.688437+00:00 call helper "+
+		"name:test0 peer-node-id:1 conn-name:peer volume:0 helper:split-brain")
+	if !IsSplitBrain(helper) {
+		t.Fatalf("Expected %q to report a split brain", helper)
+	}
+
+	// Connected connections can't be split brained.
+	conn.SetSplitBrain(helper)
+	if conn.SplitBrain != nil {
+		t.Errorf("Expected connected connection not to be split brained, got %v", conn.SplitBrain)
+	}
+
+	conn.Update(newTestEvent(t, "2017-02-15T14:43:18.688437+00:00 change connection "+
+		"name:test0 conn-name:peer connection:StandAlone"))
+	if conn.SplitBrain == nil || conn.SplitBrain.Confirmed {
+		t.Fatalf("Expected an unconfirmed split brain after going StandAlone, got %v", conn.SplitBrain)
+	}
+	if conn.SplitBrain.Reason != SplitBrainStandAlone {
+		t.Errorf("Expected reason %q, got %q", SplitBrainStandAlone, conn.SplitBrain.Reason)
+	}
+	standAloneDanger := conn.Danger
+
+	conn.SetSplitBrain(helper)
+	if conn.SplitBrain == nil || !conn.SplitBrain.Confirmed {
+		t.Fatalf("Expected a confirmed split brain, got %v", conn.SplitBrain)
+	}
+	if conn.SplitBrain.Reason != SplitBrainHelper {
+		t.Errorf("Expected reason %q, got %q", SplitBrainHelper, conn.SplitBrain.Reason)
+	}
+	if conn.Danger <= standAloneDanger {
+		t.Errorf("Expected a split brain to be more dangerous than %d, got %d", standAloneDanger, conn.Danger)
+	}
+	if !strings.Contains(conn.ConnectionHint, "split brain") {
+		t.Errorf("Expected the hint to mention the split brain, got %q", conn.ConnectionHint)
+	}
+
+	conn.Update(newTestEvent(t, "2017-02-15T14:43:19.688437+00:00 change connection "+
+		"name:test0 conn-name:peer connection:Connected"))
+	if conn.SplitBrain != nil {
+		t.Errorf("Expected the split brain to be resolved after connecting, got %v", conn.SplitBrain)
+	}
+}
+
+func TestSplitBrainStandAlone(t *testing.T) {
+	// A connection that already is StandAlone when drbdtop starts tells
+	// nothing about how it got there.
+	conn := Connection{}
+	conn.Update(newTestEvent(t, "2017-02-15T14:43:16.688437+00:00 exists connection "+
+		"name:test0 conn-name:peer connection:StandAlone role:Unknown congested:no"))
+	if conn.SplitBrain != nil {
+		t.Errorf("Expected no split brain for an existing StandAlone connection, got %v", conn.SplitBrain)
+	}
+
+	// Unless a server suspected it when the connection went StandAlone.
+	conn.SetSplitBrain(NewSplitBrainEvent("test0", "peer", SplitBrainStandAlone))
+	if conn.SplitBrain == nil || conn.SplitBrain.Confirmed {
+		t.Fatalf("Expected an unconfirmed split brain, got %v", conn.SplitBrain)
+	}
+
+	// Suspicions don't replace reports.
+	conn.SetSplitBrain(NewSplitBrainEvent("test0", "peer", SplitBrainKernel))
+	conn.SetSplitBrain(NewSplitBrainEvent("test0", "peer", SplitBrainStandAlone))
+	if !conn.SplitBrain.Confirmed || conn.SplitBrain.Reason != SplitBrainKernel {
+		t.Errorf("Expected the reported split brain to be kept, got %v", conn.SplitBrain)
+	}
+}
+
+func TestIsSplitBrain(t *testing.T) {
+	var tests = []struct {
+		in  string
+		out bool
+	}{
+		{"2017-02-15T14:43:16.688437+00:00 call helper name:test0 peer-node-id:1 conn-name:peer volume:0 helper:split-brain", true},
+		{"2017-02-15T14:43:16.688437+00:00 call helper name:test0 peer-node-id:1 conn-name:peer volume:0 helper:initial-split-brain", true},
+		{"2017-02-15T14:43:16.688437+00:00 response helper name:test0 peer-node-id:1 conn-name:peer volume:0 helper:split-brain status:0", false},
+		{"2017-02-15T14:43:16.688437+00:00 call helper name:test0 peer-node-id:1 conn-name:peer volume:0 helper:before-resync-target", false},
+	}
+
+	for _, tt := range tests {
+		if got := IsSplitBrain(newTestEvent(t, tt.in)); got != tt.out {
+			t.Errorf("IsSplitBrain(%q): expected %t, got %t", tt.in, tt.out, got)
+		}
+	}
+
+	if !IsSplitBrain(NewSplitBrainEvent("test0", "peer", SplitBrainKernel)) {
+		t.Error("Expected a split-brain Event to report a split brain")
+	}
+}
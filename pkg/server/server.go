@@ -0,0 +1,370 @@
+/*
+ *drbdtop - statistics for DRBD
+ *Copyright © 2017 Hayley Swimelar and Roland Kammerer
+ *
+ *This program is free software; you can redistribute it and/or modify
+ *it under the terms of the GNU General Public License as published by
+ *the Free Software Foundation; either version 2 of the License, or
+ *(at your option) any later version.
+ *
+ *This program is distributed in the hope that it will be useful,
+ *but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ *GNU General Public License for more details.
+ *
+ *You should have received a copy of the GNU General Public License
+ *along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/LINBIT/drbdtop/pkg/collect"
+	"github.com/LINBIT/drbdtop/pkg/resource"
+)
+
+// Number of events queued for a client before it is considered too slow and
+// disconnected.
+const clientBacklog = 4096
+
+// Server streams collected events to clients in the format of a recording,
+// which collect.Remote reads. Every client first gets the current state,
+// then every event as it is collected.
+//
+// The state is kept as the latest event of every resource, connection,
+// device, peer device and path rather than as a ResourceCollection, so that
+// clients can build their own model and statistics from it. Split brains of a
+// connection are kept as its latest split-brain report.
+type Server struct {
+	sync.Mutex
+	state   map[string]map[string]resource.Event
+	clients map[*client]bool
+}
+
+type client struct {
+	conn   net.Conn
+	events chan resource.Event
+}
+
+// New returns a Server without any state and clients.
+func New() *Server {
+	return &Server{
+		state:   make(map[string]map[string]resource.Event),
+		clients: make(map[*client]bool),
+	}
+}
+
+// Listen listens on an address as understood by collect.SplitAddress. A stale
+// unix socket is replaced and the new one can be connected to by everyone, so
+// that unprivileged users can watch the state of DRBD.
+func Listen(address string) (net.Listener, error) {
+	network, addr := collect.SplitAddress(address)
+	if network == "unix" {
+		if fi, err := os.Stat(addr); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			if conn, err := net.Dial(network, addr); err == nil {
+				conn.Close()
+				return nil, fmt.Errorf("Couldn't listen on %s: another server is running", address)
+			}
+			os.Remove(addr)
+		}
+	}
+
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't listen on %s: %v", address, err)
+	}
+	if network == "unix" {
+		if err := os.Chmod(addr, 0666); err != nil {
+			l.Close()
+			return nil, fmt.Errorf("Couldn't listen on %s: %v", address, err)
+		}
+	}
+	return l, nil
+}
+
+// Run keeps the state up to date and forwards events to all clients until
+// the program exits. Errors are logged.
+func (s *Server) Run(events <-chan resource.Event, errors <-chan error) {
+	for {
+		select {
+		case evt := <-events:
+			s.Update(evt)
+		case err := <-errors:
+			log.Println(err)
+		}
+	}
+}
+
+// Update the state with a new Event and forward it to all clients.
+func (s *Server) Update(evt resource.Event) {
+	s.Lock()
+	defer s.Unlock()
+
+	switch evt.Target {
+	case resource.EOF:
+		return
+	case resource.DisplayEvent:
+	case resource.PruneEvent:
+		s.prune(evt.TimeStamp)
+	case resource.ResetEvent:
+		s.state = make(map[string]map[string]resource.Event)
+	default:
+		s.update(evt)
+	}
+
+	for c := range s.clients {
+		select {
+		case c.events <- evt:
+		default:
+			log.Printf("disconnecting %s, it can't keep up", c.conn.RemoteAddr())
+			s.disconnect(c)
+		}
+	}
+}
+
+func (s *Server) update(evt resource.Event) {
+	name := evt.Fields[resource.ResKeys.Name]
+	if evt.Target == "helper" || evt.Target == "split-brain" {
+		s.updateSplitBrain(name, evt)
+		return
+	}
+	key := stateKey(evt)
+	if name == "" || key == "" {
+		return
+	}
+
+	if evt.EventType == "destroy" {
+		s.destroy(name, evt)
+		return
+	}
+
+	if _, ok := s.state[name]; !ok {
+		s.state[name] = make(map[string]resource.Event)
+	}
+	if evt.Target == "connection" {
+		s.updateConnection(name, evt)
+	}
+	s.state[name][key] = evt
+}
+
+// updateConnection keeps the split brain of a connection up to date before
+// evt is stored. A connection that drops to StandAlone may have split
+// brained, clients can't tell from its latest event alone.
+func (s *Server) updateConnection(name string, evt resource.Event) {
+	conn := evt.Fields[resource.ConnKeys.ConnName]
+	objs := s.state[name]
+	prev, known := objs["connection/"+conn]
+	key := "split-brain/" + conn
+
+	switch status := evt.Fields[resource.ConnKeys.Connection]; {
+	case status == "Connected":
+		delete(objs, key)
+	case status == "StandAlone" && evt.EventType == "change" && known &&
+		prev.Fields[resource.ConnKeys.Connection] != "StandAlone":
+		if _, ok := objs[key]; !ok {
+			sb := resource.NewSplitBrainEvent(name, conn, resource.SplitBrainStandAlone)
+			sb.TimeStamp = evt.TimeStamp
+			objs[key] = sb
+		}
+	}
+}
+
+// updateSplitBrain keeps the latest split brain report of a connection that
+// is not connected, calls of the split-brain handler are kept as
+// split-brain events.
+func (s *Server) updateSplitBrain(name string, evt resource.Event) {
+	if !resource.IsSplitBrain(evt) {
+		return
+	}
+	conn := evt.Fields[resource.SplitBrainKeys.ConnName]
+	c, ok := s.state[name]["connection/"+conn]
+	if !ok || c.Fields[resource.ConnKeys.Connection] == "Connected" {
+		return
+	}
+
+	if evt.Target == "helper" {
+		sb := resource.NewSplitBrainEvent(name, conn, resource.SplitBrainHelper)
+		sb.TimeStamp = evt.TimeStamp
+		evt = sb
+	}
+	s.state[name][stateKey(evt)] = evt
+}
+
+// destroy removes the object evt destroys, including everything that can't
+// outlive it.
+func (s *Server) destroy(name string, evt resource.Event) {
+	switch evt.Target {
+	case "resource":
+		delete(s.state, name)
+	case "connection":
+		conn := evt.Fields[resource.ConnKeys.ConnName]
+		for key, e := range s.state[name] {
+			if (e.Target == "connection" || e.Target == "peer-device" || e.Target == "path" || e.Target == "split-brain") &&
+				e.Fields[resource.ConnKeys.ConnName] == conn {
+				delete(s.state[name], key)
+			}
+		}
+	default:
+		delete(s.state[name], stateKey(evt))
+	}
+}
+
+// prune removes everything that wasn't updated since t, the way
+// ResourceCollection.Prune does. Split brains last as long as their
+// connection.
+func (s *Server) prune(t time.Time) {
+	for name, objs := range s.state {
+		for key, e := range objs {
+			if e.Target != "split-brain" && e.TimeStamp.Before(t) {
+				delete(objs, key)
+			}
+		}
+		for key, e := range objs {
+			if e.Target != "split-brain" {
+				continue
+			}
+			if _, ok := objs["connection/"+e.Fields[resource.SplitBrainKeys.ConnName]]; !ok {
+				delete(objs, key)
+			}
+		}
+		if len(objs) == 0 {
+			delete(s.state, name)
+		}
+	}
+}
+
+// stateKey identifies the object an Event is about, it is empty for events
+// that are not part of the state.
+func stateKey(evt resource.Event) string {
+	f := evt.Fields
+	switch evt.Target {
+	case "resource":
+		return "resource"
+	case "device":
+		return "device/" + f[resource.DevKeys.Volume]
+	case "connection":
+		return "connection/" + f[resource.ConnKeys.ConnName]
+	case "peer-device":
+		return "peer-device/" + f[resource.PeerDevKeys.ConnName] + "/" + f[resource.PeerDevKeys.Volume]
+	case "path":
+		return "path/" + f[resource.PathKeys.ConnName] + "/" + f[resource.PathKeys.Local] + "/" + f[resource.PathKeys.Peer]
+	case "split-brain":
+		return "split-brain/" + f[resource.SplitBrainKeys.ConnName]
+	}
+	return ""
+}
+
+// targetOrder sorts the state so that objects follow the objects they belong to.
+var targetOrder = map[string]int{"resource": 0, "device": 1, "connection": 2, "path": 3, "peer-device": 4, "split-brain": 5}
+
+// snapshot returns the current state as a list of events.
+func (s *Server) snapshot() []resource.Event {
+	var evts []resource.Event
+	for _, objs := range s.state {
+		for _, e := range objs {
+			evts = append(evts, e)
+		}
+	}
+	sort.Slice(evts, func(i, j int) bool {
+		a, b := evts[i], evts[j]
+		if an, bn := a.Fields[resource.ResKeys.Name], b.Fields[resource.ResKeys.Name]; an != bn {
+			return an < bn
+		}
+		if targetOrder[a.Target] != targetOrder[b.Target] {
+			return targetOrder[a.Target] < targetOrder[b.Target]
+		}
+		return stateKey(a) < stateKey(b)
+	})
+	return evts
+}
+
+// Serve accepts clients on l until it fails.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return fmt.Errorf("Couldn't accept clients: %v", err)
+		}
+		go s.serve(conn)
+	}
+}
+
+func (s *Server) serve(conn net.Conn) {
+	defer conn.Close()
+
+	rec, err := collect.NewRecorder(conn)
+	if err != nil {
+		return
+	}
+
+	c := &client{conn: conn, events: make(chan resource.Event, clientBacklog)}
+	s.Lock()
+	state := s.snapshot()
+	s.clients[c] = true
+	s.Unlock()
+
+	// Notice clients that hang up, they never send anything.
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			if _, err := conn.Read(buf); err != nil {
+				s.Lock()
+				s.disconnect(c)
+				s.Unlock()
+				return
+			}
+		}
+	}()
+
+	err = func() error {
+		for _, e := range state {
+			if err := rec.Event(e); err != nil {
+				return err
+			}
+		}
+		if err := rec.Cycle(time.Now()); err != nil {
+			return err
+		}
+
+		for e := range c.events {
+			var err error
+			switch e.Target {
+			case resource.DisplayEvent:
+				err = rec.Cycle(time.Now())
+			case resource.PruneEvent:
+				err = rec.Prune(e.TimeStamp)
+			case resource.ResetEvent:
+				err = rec.Reset()
+			default:
+				err = rec.Event(e)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}()
+	if err != nil {
+		s.Lock()
+		s.disconnect(c)
+		s.Unlock()
+	}
+}
+
+// disconnect removes a client, the caller has to hold the lock.
+func (s *Server) disconnect(c *client) {
+	if !s.clients[c] {
+		return
+	}
+	delete(s.clients, c)
+	close(c.events)
+	c.conn.Close()
+}
@@ -0,0 +1,173 @@
+/*
+ *drbdtop - statistics for DRBD
+ *Copyright © 2017 Hayley Swimelar and Roland Kammerer
+ *
+ *This program is free software; you can redistribute it and/or modify
+ *it under the terms of the GNU General Public License as published by
+ *the Free Software Foundation; either version 2 of the License, or
+ *(at your option) any later version.
+ *
+ *This program is distributed in the hope that it will be useful,
+ *but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ *GNU General Public License for more details.
+ *
+ *You should have received a copy of the GNU General Public License
+ *along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/LINBIT/drbdtop/pkg/resource"
+)
+
+func newTestEvent(t *testing.T, s string) resource.Event {
+	evt, err := resource.NewEvent(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return evt
+}
+
+func stateTargets(s *Server) []string {
+	var targets []string
+	for _, e := range s.snapshot() {
+		targets = append(targets, e.Fields[resource.ResKeys.Name]+" "+stateKey(e))
+	}
+	return targets
+}
+
+func TestServerState(t *testing.T) {
+	s := New()
+	for _, l := range []string{
+		"2017-02-15T14:43:16.688437+00:00 exists peer-device name:r0 conn-name:peer volume:0 replication:Established peer-disk:UpToDate",
+		"2017-02-15T14:43:16.688437+00:00 exists connection name:r0 conn-name:peer connection:Connected role:Secondary",
+		"2017-02-15T14:43:16.688437+00:00 exists device name:r0 volume:0 minor:0 disk:UpToDate",
+		"2017-02-15T14:43:16.688437+00:00 exists resource name:r0 role:Secondary suspended:no write-ordering:flush",
+		"2017-02-15T14:43:16.688437+00:00 exists resource name:r1 role:Secondary suspended:no write-ordering:flush",
+		"2017-02-15T14:43:17.688437+00:00 change resource name:r0 role:Primary",
+		"2017-02-15T14:43:17.688437+00:00 exists -",
+	} {
+		s.Update(newTestEvent(t, l))
+	}
+
+	expected := "r0 resource|r0 device/0|r0 connection/peer|r0 peer-device/peer/0|r1 resource"
+	if got := strings.Join(stateTargets(s), "|"); got != expected {
+		t.Errorf("Expected the state %q, got %q", expected, got)
+	}
+	if role := s.snapshot()[0].Fields[resource.ResKeys.Role]; role != "Primary" {
+		t.Errorf("Expected the latest role of r0, got %q", role)
+	}
+
+	s.Update(newTestEvent(t, "2017-02-15T14:43:18.688437+00:00 destroy connection name:r0 conn-name:peer"))
+	expected = "r0 resource|r0 device/0|r1 resource"
+	if got := strings.Join(stateTargets(s), "|"); got != expected {
+		t.Errorf("Expected the state %q after destroying the connection, got %q", expected, got)
+	}
+
+	pruneEvent := resource.NewPruneEvent()
+	pruneEvent.TimeStamp = newTestEvent(t, "2017-02-15T14:43:17.000000+00:00 exists -").TimeStamp
+	s.Update(pruneEvent)
+	expected = "r0 resource"
+	if got := strings.Join(stateTargets(s), "|"); got != expected {
+		t.Errorf("Expected the state %q after pruning, got %q", expected, got)
+	}
+
+	s.Update(resource.NewResetEvent())
+	if got := stateTargets(s); len(got) != 0 {
+		t.Errorf("Expected no state after a reset, got %q", got)
+	}
+}
+
+func TestServerStateSplitBrain(t *testing.T) {
+	s := New()
+	for _, l := range []string{
+		"2017-02-15T14:43:16.688437+00:00 exists resource name:r0 role:Primary suspended:no write-ordering:flush",
+		"2017-02-15T14:43:16.688437+00:00 exists connection name:r0 conn-name:alpha connection:Connected role:Secondary",
+		"2017-02-15T14:43:16.688437+00:00 exists connection name:r0 conn-name:beta connection:Connected role:Secondary",
+		"2017-02-15T14:43:17.688437+00:00 change connection name:r0 conn-name:alpha connection:StandAlone role:Unknown",
+		"2017-02-15T14:43:17.688437+00:00 change connection name:r0 conn-name:beta connection:StandAlone role:Unknown",
+		"2017-02-15T14:43:17.688437+00:00 call helper name:r0 peer-node-id:1 conn-name:beta volume:0 helper:split-brain",
+		// Unknown connections are ignored.
+		"2017-02-15T14:43:17.688437+00:00 call helper name:r0 peer-node-id:2 conn-name:gamma volume:0 helper:split-brain",
+	} {
+		s.Update(newTestEvent(t, l))
+	}
+
+	expected := "r0 resource|r0 connection/alpha|r0 connection/beta|r0 split-brain/alpha|r0 split-brain/beta"
+	if got := strings.Join(stateTargets(s), "|"); got != expected {
+		t.Fatalf("Expected the state %q, got %q", expected, got)
+	}
+	reasons := make(map[string]string)
+	for _, e := range s.snapshot() {
+		if e.Target == "split-brain" {
+			reasons[e.Fields[resource.SplitBrainKeys.ConnName]] = e.Fields[resource.SplitBrainKeys.Reason]
+		}
+	}
+	if reasons["alpha"] != resource.SplitBrainStandAlone || reasons["beta"] != resource.SplitBrainHelper {
+		t.Errorf("Expected alpha to be suspected and beta to be reported, got %v", reasons)
+	}
+
+	// Split brains last as long as their connection.
+	pruneEvent := resource.NewPruneEvent()
+	pruneEvent.TimeStamp = newTestEvent(t, "2017-02-15T14:43:17.000000+00:00 exists -").TimeStamp
+	s.Update(pruneEvent)
+	expected = "r0 connection/alpha|r0 connection/beta|r0 split-brain/alpha|r0 split-brain/beta"
+	if got := strings.Join(stateTargets(s), "|"); got != expected {
+		t.Errorf("Expected the state %q after pruning, got %q", expected, got)
+	}
+
+	s.Update(newTestEvent(t, "2017-02-15T14:43:18.688437+00:00 change connection name:r0 conn-name:alpha connection:Connected role:Secondary"))
+	s.Update(newTestEvent(t, "2017-02-15T14:43:18.688437+00:00 destroy connection name:r0 conn-name:beta"))
+	expected = "r0 connection/alpha"
+	if got := strings.Join(stateTargets(s), "|"); got != expected {
+		t.Errorf("Expected the state %q after the split brains were resolved, got %q", expected, got)
+	}
+}
+
+func TestServe(t *testing.T) {
+	l, err := Listen("tcp:127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	s := New()
+	s.Update(newTestEvent(t, "2017-02-15T14:43:16.688437+00:00 exists resource name:r0 role:Primary suspended:no write-ordering:flush"))
+	go s.Serve(l)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	next := func() string {
+		if !scanner.Scan() {
+			t.Fatalf("Expected another line, got %v", scanner.Err())
+		}
+		return scanner.Text()
+	}
+
+	if line := next(); line != "# drbdtop recording" {
+		t.Errorf("Expected the header of a recording, got %q", line)
+	}
+	if line := next(); !strings.Contains(line, "resource name:r0") {
+		t.Errorf("Expected the state of r0, got %q", line)
+	}
+	if line := next(); !strings.HasPrefix(line, "# drbdtop cycle ") {
+		t.Errorf("Expected the state to be displayed, got %q", line)
+	}
+
+	// The client is registered before it gets the state, so it gets the change.
+	s.Update(newTestEvent(t, "2017-02-15T14:43:17.688437+00:00 change resource name:r0 role:Secondary"))
+	if line := next(); !strings.Contains(line, "role:Secondary") {
+		t.Errorf("Expected the change of r0, got %q", line)
+	}
+}
@@ -0,0 +1,349 @@
+/*
+ *drbdtop - statistics for DRBD
+ *Copyright © 2017 Hayley Swimelar and Roland Kammerer
+ *
+ *This program is free software; you can redistribute it and/or modify
+ *it under the terms of the GNU General Public License as published by
+ *the Free Software Foundation; either version 2 of the License, or
+ *(at your option) any later version.
+ *
+ *This program is distributed in the hope that it will be useful,
+ *but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ *GNU General Public License for more details.
+ *
+ *You should have received a copy of the GNU General Public License
+ *along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package snapshot
+
+import (
+	"sort"
+	"time"
+
+	"github.com/LINBIT/drbdtop/pkg/resource"
+	"github.com/LINBIT/drbdtop/pkg/update"
+)
+
+// Version of the snapshot schema. Bump it whenever fields are renamed, removed
+// or change their meaning, adding fields is fine.
+const Version = 2
+
+// Snapshot is a point in time copy of an update.ResourceCollection that is
+// meant to be serialized for other programs.
+type Snapshot struct {
+	Version int       `json:"version"`
+	Time    time.Time `json:"time"`
+	// How far back the Stats of gauges look.
+	StatsWindowSeconds float64    `json:"stats_window_seconds"`
+	Resources          []Resource `json:"resources"`
+}
+
+// Resource is the snapshot of a single update.ByRes.
+type Resource struct {
+	Name          string `json:"name"`
+	Role          string `json:"role"`
+	Suspended     string `json:"suspended"`
+	WriteOrdering string `json:"write_ordering"`
+	Unconfigured  bool   `json:"unconfigured"`
+	// Danger of the resource itself.
+	ResourceDanger uint64 `json:"resource_danger"`
+	// Aggregate danger score from all connections, peer devices, and the local device.
+	Danger      uint64       `json:"danger"`
+	Device      Device       `json:"device"`
+	Connections []Connection `json:"connections"`
+}
+
+// Device is the snapshot of the local device of a resource.
+type Device struct {
+	Danger  uint64         `json:"danger"`
+	Volumes []DeviceVolume `json:"volumes"`
+}
+
+// DeviceVolume is the snapshot of a single local volume.
+type DeviceVolume struct {
+	Volume               string `json:"volume"`
+	Minor                string `json:"minor"`
+	DiskState            string `json:"disk_state"`
+	DiskHint             string `json:"disk_hint"`
+	Client               string `json:"client"`
+	Quorum               string `json:"quorum"`
+	QuorumAlert          bool   `json:"quorum_alert"`
+	SizeKiB              uint64 `json:"size_kib"`
+	ActivityLogSuspended string `json:"al_suspended"`
+	Blocked              string `json:"blocked"`
+
+	ReadKiB            Rate  `json:"read_kib"`
+	WrittenKiB         Rate  `json:"written_kib"`
+	ActivityLogUpdates Rate  `json:"al_updates"`
+	BitMapUpdates      Rate  `json:"bm_updates"`
+	UpperPending       Stats `json:"upper_pending"`
+	LowerPending       Stats `json:"lower_pending"`
+}
+
+// Connection is the snapshot of a connection and the peer device behind it.
+type Connection struct {
+	Name       string     `json:"name"`
+	PeerNodeID string     `json:"peer_node_id"`
+	Status     string     `json:"status"`
+	Hint       string     `json:"hint"`
+	Role       string     `json:"role"`
+	Congested  string     `json:"congested"`
+	Danger     uint64     `json:"danger"`
+	Paths      []Path     `json:"paths"`
+	PeerDevice PeerDevice `json:"peer_device"`
+	// Set if the connection is, or may be, split brained.
+	SplitBrain *SplitBrain `json:"split_brain,omitempty"`
+}
+
+// SplitBrain describes why a connection is thought to be split brained.
+type SplitBrain struct {
+	Since     time.Time `json:"since"`
+	Reason    string    `json:"reason"`
+	Confirmed bool      `json:"confirmed"`
+}
+
+// Path is the snapshot of a single network path of a connection.
+type Path struct {
+	Local       string `json:"local"`
+	Peer        string `json:"peer"`
+	Established bool   `json:"established"`
+}
+
+// PeerDevice is the snapshot of the device of a peer.
+type PeerDevice struct {
+	Danger  uint64             `json:"danger"`
+	Volumes []PeerDeviceVolume `json:"volumes"`
+}
+
+// PeerDeviceVolume is the snapshot of a single volume of a peer.
+type PeerDeviceVolume struct {
+	Volume            string `json:"volume"`
+	ReplicationStatus string `json:"replication_status"`
+	ReplicationHint   string `json:"replication_hint"`
+	DiskState         string `json:"disk_state"`
+	DiskHint          string `json:"disk_hint"`
+	Client            string `json:"client"`
+	ResyncSuspended   string `json:"resync_suspended"`
+
+	OutOfSyncKiB  Stats `json:"out_of_sync_kib"`
+	PendingWrites Stats `json:"pending_writes"`
+	UnackedWrites Stats `json:"unacked_writes"`
+	ReceivedKiB   Rate  `json:"received_kib"`
+	SentKiB       Rate  `json:"sent_kib"`
+
+	// Resync is only set while the volume is being resynchronized.
+	Resync *Resync `json:"resync,omitempty"`
+}
+
+// Resync is the progress of a resynchronization.
+type Resync struct {
+	Paused       bool      `json:"paused"`
+	StartTime    time.Time `json:"start_time"`
+	TotalKiB     uint64    `json:"total_kib"`
+	RemainingKiB uint64    `json:"remaining_kib"`
+	DonePercent  float64   `json:"done_percent"`
+	KiBPerSecond float64   `json:"kib_per_second"`
+	// Estimated seconds until the resync is done, 0 if unknown.
+	ETASeconds float64 `json:"eta_seconds"`
+}
+
+// Rate is a counter and how fast it grows.
+type Rate struct {
+	Total     uint64  `json:"total"`
+	PerSecond float64 `json:"per_second"`
+	// Moving averages of PerSecond over 1, 5 and 15 minutes.
+	Avg1m  float64 `json:"avg_1m"`
+	Avg5m  float64 `json:"avg_5m"`
+	Avg15m float64 `json:"avg_15m"`
+}
+
+// Stats are the statistics of a gauge over the last Snapshot.StatsWindowSeconds.
+type Stats struct {
+	Current uint64  `json:"current"`
+	Min     uint64  `json:"min"`
+	Max     uint64  `json:"max"`
+	Avg     float64 `json:"avg"`
+	P50     uint64  `json:"p50"`
+	P95     uint64  `json:"p95"`
+	P99     uint64  `json:"p99"`
+}
+
+// New takes a Snapshot of all resources in rc, in the order of rc.List.
+// The caller is expected to call rc.UpdateList beforehand.
+func New(rc *update.ResourceCollection) Snapshot {
+	rc.RLock()
+	defer rc.RUnlock()
+
+	s := Snapshot{
+		Version:            Version,
+		Time:               time.Now(),
+		StatsWindowSeconds: resource.StatsWindow().Seconds(),
+		Resources:          []Resource{},
+	}
+	for _, r := range rc.List {
+		s.Resources = append(s.Resources, NewResource(r))
+	}
+	return s
+}
+
+// NewResource takes a snapshot of a single resource.
+func NewResource(r *update.ByRes) Resource {
+	r.RLock()
+	defer r.RUnlock()
+
+	res := Resource{
+		Name:           r.Res.Name,
+		Role:           r.Res.Role,
+		Suspended:      r.Res.Suspended,
+		WriteOrdering:  r.Res.WriteOrdering,
+		Unconfigured:   r.Res.Unconfigured,
+		ResourceDanger: r.Res.Danger,
+		Danger:         r.Danger,
+		Device:         newDevice(r.Device),
+		Connections:    []Connection{},
+	}
+
+	// Peer devices are listed under their connection, make sure we don't
+	// lose one that arrived before its connection.
+	names := make(map[string]bool)
+	for k := range r.Connections {
+		names[k] = true
+	}
+	for k := range r.PeerDevices {
+		names[k] = true
+	}
+	var keys []string
+	for k := range names {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		conn := Connection{
+			Name:       k,
+			Paths:      []Path{},
+			PeerDevice: PeerDevice{Volumes: []PeerDeviceVolume{}},
+		}
+		if c, ok := r.Connections[k]; ok {
+			conn.PeerNodeID = c.PeerNodeID
+			conn.Status = c.ConnectionStatus
+			conn.Hint = c.ConnectionHint
+			conn.Role = c.Role
+			conn.Congested = c.Congested
+			conn.Danger = c.Danger
+			conn.Paths = newPaths(c)
+			if sb := c.SplitBrain; sb != nil {
+				conn.SplitBrain = &SplitBrain{Since: sb.Since, Reason: sb.Reason, Confirmed: sb.Confirmed}
+			}
+		}
+		if p, ok := r.PeerDevices[k]; ok {
+			conn.PeerDevice = newPeerDevice(p)
+		}
+		res.Connections = append(res.Connections, conn)
+	}
+
+	return res
+}
+
+func newDevice(d *resource.Device) Device {
+	dev := Device{Danger: d.Danger, Volumes: []DeviceVolume{}}
+
+	var keys []string
+	for k := range d.Volumes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := d.Volumes[k]
+		dev.Volumes = append(dev.Volumes, DeviceVolume{
+			Volume:               k,
+			Minor:                v.Minor,
+			DiskState:            v.DiskState,
+			DiskHint:             v.DiskHint,
+			Client:               v.Client,
+			Quorum:               v.Quorum,
+			QuorumAlert:          v.QuorumAlert,
+			SizeKiB:              v.Size,
+			ActivityLogSuspended: v.ActivityLogSuspended,
+			Blocked:              v.Blocked,
+
+			ReadKiB:            Rate{Total: v.ReadKiB.Total, PerSecond: v.ReadKiB.PerSecond, Avg1m: v.ReadKiB.Avg1m, Avg5m: v.ReadKiB.Avg5m, Avg15m: v.ReadKiB.Avg15m},
+			WrittenKiB:         Rate{Total: v.WrittenKiB.Total, PerSecond: v.WrittenKiB.PerSecond, Avg1m: v.WrittenKiB.Avg1m, Avg5m: v.WrittenKiB.Avg5m, Avg15m: v.WrittenKiB.Avg15m},
+			ActivityLogUpdates: Rate{Total: v.ActivityLogUpdates.Total, PerSecond: v.ActivityLogUpdates.PerSecond, Avg1m: v.ActivityLogUpdates.Avg1m, Avg5m: v.ActivityLogUpdates.Avg5m, Avg15m: v.ActivityLogUpdates.Avg15m},
+			BitMapUpdates:      Rate{Total: v.BitMapUpdates.Total, PerSecond: v.BitMapUpdates.PerSecond, Avg1m: v.BitMapUpdates.Avg1m, Avg5m: v.BitMapUpdates.Avg5m, Avg15m: v.BitMapUpdates.Avg15m},
+			UpperPending:       newStats(v.UpperPending.Current, v.UpperPending.Min, v.UpperPending.Max, v.UpperPending.Avg, v.UpperPending.Percentiles()),
+			LowerPending:       newStats(v.LowerPending.Current, v.LowerPending.Min, v.LowerPending.Max, v.LowerPending.Avg, v.LowerPending.Percentiles()),
+		})
+	}
+	return dev
+}
+
+func newPaths(c *resource.Connection) []Path {
+	paths := []Path{}
+
+	var keys []string
+	for k := range c.Paths {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		p := c.Paths[k]
+		paths = append(paths, Path{
+			Local:       p.Local.String(),
+			Peer:        p.Peer.String(),
+			Established: p.Established,
+		})
+	}
+	return paths
+}
+
+func newPeerDevice(p *resource.PeerDevice) PeerDevice {
+	dev := PeerDevice{Danger: p.Danger, Volumes: []PeerDeviceVolume{}}
+
+	var keys []string
+	for k := range p.Volumes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := p.Volumes[k]
+		dev.Volumes = append(dev.Volumes, PeerDeviceVolume{
+			Volume:            k,
+			ReplicationStatus: v.ReplicationStatus,
+			ReplicationHint:   v.ReplicationHint,
+			DiskState:         v.DiskState,
+			DiskHint:          v.DiskHint,
+			Client:            v.Client,
+			ResyncSuspended:   v.ResyncSuspended,
+
+			OutOfSyncKiB:  newStats(v.OutOfSyncKiB.Current, v.OutOfSyncKiB.Min, v.OutOfSyncKiB.Max, v.OutOfSyncKiB.Avg, v.OutOfSyncKiB.Percentiles()),
+			PendingWrites: newStats(v.PendingWrites.Current, v.PendingWrites.Min, v.PendingWrites.Max, v.PendingWrites.Avg, v.PendingWrites.Percentiles()),
+			UnackedWrites: newStats(v.UnackedWrites.Current, v.UnackedWrites.Min, v.UnackedWrites.Max, v.UnackedWrites.Avg, v.UnackedWrites.Percentiles()),
+			ReceivedKiB:   Rate{Total: v.ReceivedKiB.Total, PerSecond: v.ReceivedKiB.PerSecond, Avg1m: v.ReceivedKiB.Avg1m, Avg5m: v.ReceivedKiB.Avg5m, Avg15m: v.ReceivedKiB.Avg15m},
+			SentKiB:       Rate{Total: v.SentKiB.Total, PerSecond: v.SentKiB.PerSecond, Avg1m: v.SentKiB.Avg1m, Avg5m: v.SentKiB.Avg5m, Avg15m: v.SentKiB.Avg15m},
+			Resync:        newResync(&v.Resync),
+		})
+	}
+	return dev
+}
+
+func newResync(r *resource.Resync) *Resync {
+	if !r.Active {
+		return nil
+	}
+	return &Resync{
+		Paused:       r.Paused,
+		StartTime:    r.StartTime,
+		TotalKiB:     r.TotalKiB,
+		RemainingKiB: r.RemainingKiB,
+		DonePercent:  r.Done(),
+		KiBPerSecond: r.KiBPerSecond,
+		ETASeconds:   r.ETA.Seconds(),
+	}
+}
+
+func newStats(current, min, max uint64, avg float64, p resource.Percentiles) Stats {
+	return Stats{Current: current, Min: min, Max: max, Avg: avg, P50: p.P50, P95: p.P95, P99: p.P99}
+}
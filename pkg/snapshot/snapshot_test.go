@@ -0,0 +1,89 @@
+/*
+ *drbdtop - statistics for DRBD
+ *Copyright © 2017 Hayley Swimelar and Roland Kammerer
+ *
+ *This program is free software; you can redistribute it and/or modify
+ *it under the terms of the GNU General Public License as published by
+ *the Free Software Foundation; either version 2 of the License, or
+ *(at your option) any later version.
+ *
+ *This program is distributed in the hope that it will be useful,
+ *but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ *GNU General Public License for more details.
+ *
+ *You should have received a copy of the GNU General Public License
+ *along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package snapshot
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/LINBIT/drbdtop/pkg/resource"
+	"github.com/LINBIT/drbdtop/pkg/update"
+)
+
+func TestNew(t *testing.T) {
+	rc := update.NewResourceCollection(0) // Turn off pruning with zero.
+	for _, s := range []string{
+		"2017-02-15T14:43:16.688437+00:00 exists resource name:test1 role:Secondary suspended:no write-ordering:flush",
+		"2017-02-15T14:43:16.688437+00:00 exists resource name:test0 role:Primary suspended:no write-ordering:flush",
+		"2017-02-15T14:43:16.688437+00:00 exists connection name:test0 peer-node-id:1 conn-name:peer connection:Connected role:Secondary congested:no",
+		"2017-02-15T14:43:16.688437+00:00 exists path name:test0 peer-node-id:1 conn-name:peer local:ipv4:10.0.0.1:7000 peer:ipv4:10.0.0.2:7000 established:yes",
+		"2017-02-15T14:43:16.688437+00:00 exists device name:test0 volume:0 minor:0 disk:UpToDate client:no size:4056 read:1340 written:16 al-writes:1 bm-writes:0 upper-pending:0 lower-pending:0 al-suspended:no blocked:no",
+		"2017-02-15T14:43:16.688437+00:00 exists peer-device name:test0 peer-node-id:1 conn-name:peer volume:0 replication:SyncSource peer-disk:Inconsistent resync-suspended:no received:0 sent:2050743348 out-of-sync:2048 pending:0 unacked:3",
+	} {
+		evt, err := resource.NewEvent(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rc.Update(evt)
+	}
+	rc.UpdateList()
+
+	s := New(rc)
+
+	if s.Version != Version {
+		t.Errorf("Expected version %d, got %d", Version, s.Version)
+	}
+	if len(s.Resources) != 2 || s.Resources[0].Name != "test0" {
+		t.Fatalf("Expected test0 and test1 in name order, got %v", s.Resources)
+	}
+
+	r := s.Resources[0]
+	if len(r.Device.Volumes) != 1 || r.Device.Volumes[0].SizeKiB != 4056 {
+		t.Errorf("Expected a single volume with a size of %d, got %v", 4056, r.Device.Volumes)
+	}
+	if len(r.Connections) != 1 {
+		t.Fatalf("Expected a single connection, got %v", r.Connections)
+	}
+	c := r.Connections[0]
+	if c.Name != "peer" || c.Status != "Connected" {
+		t.Errorf("Expected connection to %q to be %q, got %q: %q", "peer", "Connected", c.Name, c.Status)
+	}
+	if len(c.Paths) != 1 || c.Paths[0].Local != "ipv4:10.0.0.1:7000" || !c.Paths[0].Established {
+		t.Errorf("Expected an established path from %q, got %v", "ipv4:10.0.0.1:7000", c.Paths)
+	}
+	if len(c.PeerDevice.Volumes) != 1 {
+		t.Fatalf("Expected a single peer device volume, got %v", c.PeerDevice.Volumes)
+	}
+	pv := c.PeerDevice.Volumes[0]
+	if pv.OutOfSyncKiB.Current != 2048 || pv.UnackedWrites.Max != 3 {
+		t.Errorf("Expected %d KiB out of sync and %d unacked writes, got %v", 2048, 3, pv)
+	}
+	if r.Danger == 0 || r.Danger != rc.Map["test0"].Danger {
+		t.Errorf("Expected danger to be %d, got %d", rc.Map["test0"].Danger, r.Danger)
+	}
+
+	// Empty lists are serialized as [] rather than null.
+	if s.Resources[1].Device.Volumes == nil || s.Resources[1].Connections == nil {
+		t.Error("Expected empty lists instead of null for resources without devices and connections")
+	}
+
+	if _, err := json.Marshal(s); err != nil {
+		t.Error(err)
+	}
+}
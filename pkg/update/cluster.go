@@ -0,0 +1,264 @@
+/*
+ *drbdtop - statistics for DRBD
+ *Copyright © 2017 Hayley Swimelar and Roland Kammerer
+ *
+ *This program is free software; you can redistribute it and/or modify
+ *it under the terms of the GNU General Public License as published by
+ *the Free Software Foundation; either version 2 of the License, or
+ *(at your option) any later version.
+ *
+ *This program is distributed in the hope that it will be useful,
+ *but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ *GNU General Public License for more details.
+ *
+ *You should have received a copy of the GNU General Public License
+ *along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package update
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/LINBIT/drbdtop/pkg/resource"
+	"github.com/facette/natsort"
+)
+
+// Cluster keeps a ResourceCollection for every node of a cluster and lines
+// up what the nodes know about each resource.
+type Cluster struct {
+	sync.RWMutex
+	Nodes []string
+	// Resources known to any node, sorted by name. Rebuilt by UpdateList.
+	List  []*ClusterRes
+	nodes map[string]*ResourceCollection
+}
+
+// NewCluster returns a new *Cluster for the given nodes. Every node's data is
+// pruned separately, using d the same way as NewResourceCollection does.
+func NewCluster(d time.Duration, nodes []string) *Cluster {
+	c := &Cluster{
+		Nodes: nodes,
+		nodes: make(map[string]*ResourceCollection),
+	}
+	for _, n := range nodes {
+		c.nodes[n] = NewResourceCollection(d)
+	}
+	return c
+}
+
+// Update the node the Event was collected from. Events from unknown nodes are
+// ignored, ResetEvents without a node reset every node.
+func (c *Cluster) Update(e resource.Event) {
+	c.RLock()
+	defer c.RUnlock()
+
+	if e.Node == "" && e.Target == resource.ResetEvent {
+		for _, rc := range c.nodes {
+			rc.Update(e)
+		}
+		return
+	}
+	if rc, ok := c.nodes[e.Node]; ok {
+		rc.Update(e)
+	}
+}
+
+// Prune the node the PruneEvent was generated for.
+func (c *Cluster) Prune(e resource.Event) {
+	c.RLock()
+	defer c.RUnlock()
+
+	if rc, ok := c.nodes[e.Node]; ok {
+		rc.Prune(e)
+	}
+}
+
+// UpdateList rebuilds List from the current state of all nodes.
+func (c *Cluster) UpdateList() {
+	c.Lock()
+	defer c.Unlock()
+
+	byName := make(map[string]*ClusterRes)
+	for _, n := range c.Nodes {
+		rc := c.nodes[n]
+		rc.RLock()
+		for name, b := range rc.Map {
+			cr, ok := byName[name]
+			if !ok {
+				cr = &ClusterRes{Name: name, Nodes: make(map[string]*NodeView)}
+				byName[name] = cr
+			}
+			cr.Nodes[n] = newNodeView(b)
+		}
+		rc.RUnlock()
+	}
+
+	c.List = make([]*ClusterRes, 0, len(byName))
+	for _, cr := range byName {
+		cr.Disagreements = disagreements(c.Nodes, cr.Nodes)
+		c.List = append(c.List, cr)
+	}
+	sort.Slice(c.List, func(i, j int) bool {
+		return natsort.Compare(c.List[i].Name, c.List[j].Name)
+	})
+}
+
+// ClusterRes is a single resource as seen by every node of a Cluster.
+type ClusterRes struct {
+	Name string
+	// What each node knows about the resource, nodes that don't know it are missing.
+	Nodes         map[string]*NodeView
+	Disagreements []Disagreement
+}
+
+// Disagreeing reports whether node is part of any of the disagreements.
+func (cr *ClusterRes) Disagreeing(node string) bool {
+	for _, d := range cr.Disagreements {
+		for _, n := range d.Nodes {
+			if n == node {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Disagreement describes two or more nodes having contradicting views of a resource.
+type Disagreement struct {
+	Nodes   []string
+	Message string
+}
+
+// NodeView is the part of a resource's state on one node that is compared
+// between nodes.
+type NodeView struct {
+	Role string
+	// Disk states of the local volumes by volume number.
+	Disks map[string]string
+	// Connection states by connection name.
+	Connections map[string]string
+	// Disk states of the peers' volumes by connection name and volume number.
+	PeerDisks map[string]map[string]string
+	Danger    uint64
+}
+
+func newNodeView(b *ByRes) *NodeView {
+	b.RLock()
+	defer b.RUnlock()
+
+	v := &NodeView{
+		Role:        b.Res.Role,
+		Disks:       make(map[string]string),
+		Connections: make(map[string]string),
+		PeerDisks:   make(map[string]map[string]string),
+		Danger:      b.Danger,
+	}
+
+	for vol, d := range b.Device.Volumes {
+		v.Disks[vol] = d.DiskState
+	}
+	for name, c := range b.Connections {
+		v.Connections[name] = c.ConnectionStatus
+	}
+	for name, p := range b.PeerDevices {
+		disks := make(map[string]string)
+		for vol, pv := range p.Volumes {
+			disks[vol] = pv.DiskState
+		}
+		v.PeerDisks[name] = disks
+	}
+	return v
+}
+
+// Volumes returns the volume numbers of the local volumes in natural order.
+func (v *NodeView) Volumes() []string {
+	var vols []string
+	for vol := range v.Disks {
+		vols = append(vols, vol)
+	}
+	sort.Slice(vols, func(i, j int) bool { return natsort.Compare(vols[i], vols[j]) })
+	return vols
+}
+
+// disagreements compares the views of all nodes. Connections are matched to
+// nodes by name, so it works best if the nodes are named like the hosts in
+// the DRBD configuration.
+func disagreements(nodes []string, views map[string]*NodeView) []Disagreement {
+	var ret []Disagreement
+
+	var primaries []string
+	for _, n := range nodes {
+		if v, ok := views[n]; ok && v.Role == "Primary" {
+			primaries = append(primaries, n)
+		}
+	}
+	if len(primaries) > 1 {
+		ret = append(ret, Disagreement{
+			Nodes:   primaries,
+			Message: fmt.Sprintf("Primary on %s", strings.Join(primaries, ", ")),
+		})
+	}
+
+	for i, n := range nodes {
+		v, ok := views[n]
+		if !ok {
+			continue
+		}
+		for _, m := range nodes {
+			state, ok := v.Connections[m]
+			if !ok {
+				continue
+			}
+			w, ok := views[m]
+			if !ok {
+				ret = append(ret, Disagreement{
+					Nodes:   []string{n, m},
+					Message: fmt.Sprintf("%s expects it on %s, which doesn't know it", n, m),
+				})
+				continue
+			}
+
+			if other, ok := w.Connections[n]; !ok {
+				ret = append(ret, Disagreement{
+					Nodes:   []string{n, m},
+					Message: fmt.Sprintf("%s sees %s %s, %s has no connection to %s", n, m, state, m, n),
+				})
+			} else if other != state && !nodeBefore(nodes, m, i) {
+				// Both sides have the connection, report it only once per pair.
+				ret = append(ret, Disagreement{
+					Nodes:   []string{n, m},
+					Message: fmt.Sprintf("%s sees %s %s, %s sees %s %s", n, m, state, m, n, other),
+				})
+			}
+
+			for _, vol := range w.Volumes() {
+				peerDisk, ok := v.PeerDisks[m][vol]
+				if !ok || peerDisk == "DUnknown" || peerDisk == w.Disks[vol] {
+					continue
+				}
+				ret = append(ret, Disagreement{
+					Nodes: []string{n, m},
+					Message: fmt.Sprintf("%s sees %s/%s %s, %s has %s",
+						n, m, vol, peerDisk, m, w.Disks[vol]),
+				})
+			}
+		}
+	}
+	return ret
+}
+
+// nodeBefore reports whether node comes before the i-th node in nodes.
+func nodeBefore(nodes []string, node string, i int) bool {
+	for _, n := range nodes[:i] {
+		if n == node {
+			return true
+		}
+	}
+	return false
+}
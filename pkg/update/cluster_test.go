@@ -0,0 +1,137 @@
+/*
+ *drbdtop - statistics for DRBD
+ *Copyright © 2017 Hayley Swimelar and Roland Kammerer
+ *
+ *This program is free software; you can redistribute it and/or modify
+ *it under the terms of the GNU General Public License as published by
+ *the Free Software Foundation; either version 2 of the License, or
+ *(at your option) any later version.
+ *
+ *This program is distributed in the hope that it will be useful,
+ *but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ *GNU General Public License for more details.
+ *
+ *You should have received a copy of the GNU General Public License
+ *along with this program; if not, see <http://www.gnu.org/licenses/>.
+ */
+
+package update
+
+import (
+	"testing"
+
+	"github.com/LINBIT/drbdtop/pkg/resource"
+)
+
+func newTestCluster(t *testing.T, events map[string][]string) *Cluster {
+	c := NewCluster(0, []string{"alpha", "beta", "gamma"}) // Turn off pruning with zero.
+	for node, lines := range events {
+		for _, l := range lines {
+			evt := newTestEvent(t, "2017-02-15T14:43:16.688437+00:00 "+l)
+			evt.Node = node
+			c.Update(evt)
+		}
+	}
+	c.UpdateList()
+	return c
+}
+
+func TestCluster(t *testing.T) {
+	c := newTestCluster(t, map[string][]string{
+		"alpha": {
+			"exists resource name:r0 role:Primary suspended:no write-ordering:flush",
+			"exists device name:r0 volume:0 minor:0 disk:UpToDate client:no",
+			"exists connection name:r0 conn-name:beta connection:Connected role:Secondary",
+			"exists peer-device name:r0 conn-name:beta volume:0 replication:Established peer-disk:UpToDate resync-suspended:no",
+			"exists resource name:r1 role:Secondary suspended:no write-ordering:flush",
+		},
+		"beta": {
+			"exists resource name:r0 role:Secondary suspended:no write-ordering:flush",
+			"exists device name:r0 volume:0 minor:0 disk:UpToDate client:no",
+			"exists connection name:r0 conn-name:alpha connection:Connected role:Primary",
+			"exists peer-device name:r0 conn-name:alpha volume:0 replication:Established peer-disk:UpToDate resync-suspended:no",
+		},
+		"delta": {
+			"exists resource name:r2 role:Secondary suspended:no write-ordering:flush",
+		},
+	})
+
+	if len(c.List) != 2 || c.List[0].Name != "r0" || c.List[1].Name != "r1" {
+		t.Fatalf("TestCluster: Expected r0 and r1 from the known nodes, got %d resources", len(c.List))
+	}
+
+	r0 := c.List[0]
+	if len(r0.Nodes) != 2 || r0.Nodes["alpha"].Role != "Primary" || r0.Nodes["beta"].Disks["0"] != "UpToDate" {
+		t.Errorf("TestCluster: Unexpected view of r0: %+v", r0.Nodes)
+	}
+	if len(r0.Disagreements) != 0 {
+		t.Errorf("TestCluster: Expected alpha and beta to agree on r0, got %+v", r0.Disagreements)
+	}
+	if _, ok := c.List[1].Nodes["beta"]; ok {
+		t.Error("TestCluster: Expected r1 to be unknown to beta")
+	}
+
+	c.Update(resource.NewResetEvent())
+	c.UpdateList()
+	if len(c.List) != 0 {
+		t.Errorf("TestCluster: Expected no resources after a reset, got %d", len(c.List))
+	}
+}
+
+func TestClusterDisagreements(t *testing.T) {
+	c := newTestCluster(t, map[string][]string{
+		"alpha": {
+			"exists resource name:r0 role:Primary suspended:no write-ordering:flush",
+			"exists device name:r0 volume:0 minor:0 disk:UpToDate client:no",
+			"exists connection name:r0 conn-name:beta connection:StandAlone role:Unknown",
+			"exists connection name:r0 conn-name:gamma connection:Connected role:Secondary",
+			"exists peer-device name:r0 conn-name:gamma volume:0 replication:Established peer-disk:Outdated resync-suspended:no",
+			"exists connection name:r0 conn-name:delta connection:Connecting role:Unknown",
+		},
+		"beta": {
+			"exists resource name:r0 role:Primary suspended:no write-ordering:flush",
+			"exists connection name:r0 conn-name:alpha connection:Connecting role:Unknown",
+		},
+		"gamma": {
+			"exists resource name:r0 role:Secondary suspended:no write-ordering:flush",
+			"exists device name:r0 volume:0 minor:0 disk:UpToDate client:no",
+			"exists connection name:r0 conn-name:alpha connection:Connected role:Primary",
+			"exists connection name:r0 conn-name:beta connection:Connecting role:Unknown",
+			"exists resource name:r1 role:Secondary suspended:no write-ordering:flush",
+			"exists connection name:r1 conn-name:alpha connection:Connecting role:Unknown",
+		},
+	})
+
+	expected := map[string][]string{
+		"r0": {
+			"Primary on alpha, beta",
+			"alpha sees beta StandAlone, beta sees alpha Connecting",
+			"alpha sees gamma/0 Outdated, gamma has UpToDate",
+			"gamma sees beta Connecting, beta has no connection to gamma",
+		},
+		"r1": {
+			"gamma expects it on alpha, which doesn't know it",
+		},
+	}
+
+	for _, cr := range c.List {
+		var got []string
+		for _, d := range cr.Disagreements {
+			got = append(got, d.Message)
+		}
+		if len(got) != len(expected[cr.Name]) {
+			t.Errorf("TestClusterDisagreements: Expected %q for %s, got %q", expected[cr.Name], cr.Name, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != expected[cr.Name][i] {
+				t.Errorf("TestClusterDisagreements: Expected %q for %s, got %q", expected[cr.Name][i], cr.Name, got[i])
+			}
+		}
+	}
+
+	if !c.List[0].Disagreeing("gamma") || !c.List[1].Disagreeing("alpha") {
+		t.Error("TestClusterDisagreements: Expected gamma to disagree on r0 and alpha on r1")
+	}
+}
@@ -36,6 +36,8 @@ type ByRes struct {
 	PeerDevices map[string]*resource.PeerDevice
 	// Aggregate danger score from all connections, peer devices, and the local device.
 	Danger uint64
+	// Time of the last change of a role, disk, connection or replication state.
+	StateChanged time.Time
 }
 
 // NewByRes returns an empty ByRes that's ready to be Updated.
@@ -53,6 +55,17 @@ func (b *ByRes) Update(evt resource.Event) {
 	b.Lock()
 	defer b.Unlock()
 
+	if evt.EventType == "destroy" {
+		b.destroy(evt)
+		b.setDanger()
+		b.StateChanged = evt.TimeStamp
+		return
+	}
+
+	if b.changesState(evt) {
+		b.StateChanged = evt.TimeStamp
+	}
+
 	switch evt.Target {
 	case "resource":
 		b.Res.Update(evt)
@@ -75,6 +88,21 @@ func (b *ByRes) Update(evt resource.Event) {
 			b.PeerDevices[conn] = resource.NewPeerDevice()
 		}
 		b.PeerDevices[conn].Update(evt)
+
+	case "path":
+		// Paths follow their connection, one created from a path would have
+		// no state and be pruned as stale.
+		if c, ok := b.Connections[evt.Fields[resource.PathKeys.ConnName]]; ok {
+			c.UpdatePath(evt)
+		}
+
+	case "helper", "split-brain":
+		if !resource.IsSplitBrain(evt) {
+			break
+		}
+		if c, ok := b.Connections[evt.Fields[resource.HelperKeys.ConnName]]; ok {
+			c.SetSplitBrain(evt)
+		}
 	default:
 		// Unknown event target, ignore it.
 		_ = evt
@@ -83,6 +111,66 @@ func (b *ByRes) Update(evt resource.Event) {
 	b.setDanger()
 }
 
+// changesState reports whether evt changes a role, disk, connection or
+// replication state, rather than only statistics.
+func (b *ByRes) changesState(evt resource.Event) bool {
+	f := evt.Fields
+	switch evt.Target {
+	case "resource":
+		return b.Res.Role != f[resource.ResKeys.Role] || b.Res.Suspended != f[resource.ResKeys.Suspended]
+
+	case "device":
+		v, ok := b.Device.Volumes[f[resource.DevKeys.Volume]]
+		return !ok || v.DiskState != f[resource.DevKeys.Disk] || v.Quorum != f[resource.DevKeys.Quorum]
+
+	case "connection":
+		c, ok := b.Connections[f[resource.ConnKeys.ConnName]]
+		return !ok || c.ConnectionStatus != f[resource.ConnKeys.Connection] || c.Role != f[resource.ConnKeys.Role]
+
+	case "peer-device":
+		p, ok := b.PeerDevices[f[resource.PeerDevKeys.ConnName]]
+		if !ok {
+			return true
+		}
+		v, ok := p.Volumes[f[resource.PeerDevKeys.Volume]]
+		return !ok || v.ReplicationStatus != f[resource.PeerDevKeys.Replication] || v.DiskState != f[resource.PeerDevKeys.PeerDisk]
+	}
+	return false
+}
+
+// Remove the object an Event with the EventType "destroy" refers to.
+// Destroying the resource itself is handled by the ResourceCollection.
+func (b *ByRes) destroy(evt resource.Event) {
+	switch evt.Target {
+	case "device":
+		b.Device.Destroy(evt)
+
+	case "connection":
+		conn := evt.Fields[resource.ConnKeys.ConnName]
+
+		// Peer devices can't outlive the connection they belong to.
+		delete(b.Connections, conn)
+		delete(b.PeerDevices, conn)
+
+	case "path":
+		conn := evt.Fields[resource.PathKeys.ConnName]
+
+		if c, ok := b.Connections[conn]; ok {
+			c.DestroyPath(evt)
+		}
+
+	case "peer-device":
+		conn := evt.Fields[resource.PeerDevKeys.ConnName]
+
+		if p, ok := b.PeerDevices[conn]; ok {
+			p.Destroy(evt)
+			if len(p.Volumes) == 0 {
+				delete(b.PeerDevices, conn)
+			}
+		}
+	}
+}
+
 func (b *ByRes) setDanger() {
 	var dangerScore uint64
 
@@ -107,6 +195,8 @@ func (b *ByRes) prune(t time.Time) {
 	for k, c := range b.Connections {
 		if c.CurrentTime.Before(t) {
 			delete(b.Connections, k)
+		} else {
+			c.PrunePaths(t)
 		}
 	}
 	for k, v := range b.Device.Volumes {
@@ -127,6 +217,16 @@ func (b *ByRes) prune(t time.Time) {
 	}
 }
 
+func (b *ByRes) resetStats() {
+	b.Lock()
+	defer b.Unlock()
+
+	b.Device.ResetStats()
+	for _, p := range b.PeerDevices {
+		p.ResetStats()
+	}
+}
+
 // ResourceCollection is a collection of stats collected organized under their respective resource names.
 // Implements the Sort interface, sorting the *ByRes within List.
 type ResourceCollection struct {
@@ -162,15 +262,36 @@ func (rc *ResourceCollection) Update(e resource.Event) {
 	rc.Lock()
 	defer rc.Unlock()
 
+	if e.Target == resource.ResetEvent {
+		rc.Map = make(map[string]*ByRes)
+		return
+	}
+
 	resName := e.Fields[resource.ResKeys.Name]
-	if resName != "" {
-		resource, ok := rc.Map[resName]
-		if !ok {
-			resource = NewByRes()
-			rc.Map[resName] = resource
+	if resName == "" {
+		return
+	}
+
+	resource, ok := rc.Map[resName]
+	if e.EventType == "destroy" {
+		if e.Target == "resource" {
+			delete(rc.Map, resName)
+		} else if ok {
+			resource.Update(e)
 		}
-		resource.Update(e)
+		return
 	}
+
+	if !ok {
+		if e.Target == "helper" || e.Target == "split-brain" {
+			// Nothing to report on, e.g. the kernel log mentions a
+			// resource that is long gone.
+			return
+		}
+		resource = NewByRes()
+		rc.Map[resName] = resource
+	}
+	resource.Update(e)
 }
 
 func (rc *ResourceCollection) UpdateList() {
@@ -186,6 +307,17 @@ func (rc *ResourceCollection) UpdateList() {
 	rc.sort()
 }
 
+// ResetStats starts the statistics of all resources over, e.g. to get rid of
+// a spike that is not of interest anymore.
+func (rc *ResourceCollection) ResetStats() {
+	rc.Lock()
+	defer rc.Unlock()
+
+	for _, b := range rc.Map {
+		b.resetStats()
+	}
+}
+
 // Remove old fields that haven't been updated since time.
 func (rc *ResourceCollection) pruneImpl(t time.Time) {
 	for k, v := range rc.Map {
@@ -298,3 +430,144 @@ func Danger(r1, r2 *ByRes) bool {
 func DangerReverse(r1, r2 *ByRes) bool {
 	return r1.Danger > r2.Danger
 }
+
+// Role sorts Primary resources before Secondary ones, and those before the rest.
+func Role(r1, r2 *ByRes) bool {
+	return roleRank(r1) < roleRank(r2)
+}
+
+// RoleReverse sorts resources by role in reverse order.
+func RoleReverse(r1, r2 *ByRes) bool {
+	return roleRank(r1) > roleRank(r2)
+}
+
+func roleRank(b *ByRes) int {
+	switch b.Res.Role {
+	case "Primary":
+		return 0
+	case "Secondary":
+		return 1
+	}
+	return 2
+}
+
+// WriteRate sorts resources by the rate data is written to their local volumes.
+func WriteRate(r1, r2 *ByRes) bool {
+	return writeRate(r1) < writeRate(r2)
+}
+
+// WriteRateReverse sorts resources by write rate in reverse order.
+func WriteRateReverse(r1, r2 *ByRes) bool {
+	return writeRate(r1) > writeRate(r2)
+}
+
+func writeRate(b *ByRes) float64 {
+	var rate float64
+	for _, v := range b.Device.Volumes {
+		rate += v.WrittenKiB.PerSecond
+	}
+	return rate
+}
+
+// ReadRate sorts resources by the rate data is read from their local volumes.
+func ReadRate(r1, r2 *ByRes) bool {
+	return readRate(r1) < readRate(r2)
+}
+
+// ReadRateReverse sorts resources by read rate in reverse order.
+func ReadRateReverse(r1, r2 *ByRes) bool {
+	return readRate(r1) > readRate(r2)
+}
+
+func readRate(b *ByRes) float64 {
+	var rate float64
+	for _, v := range b.Device.Volumes {
+		rate += v.ReadKiB.PerSecond
+	}
+	return rate
+}
+
+// OutOfSync sorts resources by the data out of sync with all their peers.
+func OutOfSync(r1, r2 *ByRes) bool {
+	return outOfSync(r1) < outOfSync(r2)
+}
+
+// OutOfSyncReverse sorts resources by out of sync data in reverse order.
+func OutOfSyncReverse(r1, r2 *ByRes) bool {
+	return outOfSync(r1) > outOfSync(r2)
+}
+
+func outOfSync(b *ByRes) uint64 {
+	var kib uint64
+	for _, p := range b.PeerDevices {
+		for _, v := range p.Volumes {
+			kib += v.OutOfSyncKiB.Current
+		}
+	}
+	return kib
+}
+
+// Pending sorts resources by the most writes pending on a peer volume.
+func Pending(r1, r2 *ByRes) bool {
+	return maxPending(r1) < maxPending(r2)
+}
+
+// PendingReverse sorts resources by pending writes in reverse order.
+func PendingReverse(r1, r2 *ByRes) bool {
+	return maxPending(r1) > maxPending(r2)
+}
+
+func maxPending(b *ByRes) uint64 {
+	var max uint64
+	for _, p := range b.PeerDevices {
+		for _, v := range p.Volumes {
+			if v.PendingWrites.Current > max {
+				max = v.PendingWrites.Current
+			}
+		}
+	}
+	return max
+}
+
+// Unacked sorts resources by the most unacknowledged writes on a peer volume.
+func Unacked(r1, r2 *ByRes) bool {
+	return maxUnacked(r1) < maxUnacked(r2)
+}
+
+// UnackedReverse sorts resources by unacknowledged writes in reverse order.
+func UnackedReverse(r1, r2 *ByRes) bool {
+	return maxUnacked(r1) > maxUnacked(r2)
+}
+
+func maxUnacked(b *ByRes) uint64 {
+	var max uint64
+	for _, p := range b.PeerDevices {
+		for _, v := range p.Volumes {
+			if v.UnackedWrites.Current > max {
+				max = v.UnackedWrites.Current
+			}
+		}
+	}
+	return max
+}
+
+// Peers sorts resources by their number of connections.
+func Peers(r1, r2 *ByRes) bool {
+	return len(r1.Connections) < len(r2.Connections)
+}
+
+// PeersReverse sorts resources by their number of connections in reverse order.
+func PeersReverse(r1, r2 *ByRes) bool {
+	return len(r1.Connections) > len(r2.Connections)
+}
+
+// StateChanged sorts resources by the time of their last state change.
+func StateChanged(r1, r2 *ByRes) bool {
+	return r1.StateChanged.Before(r2.StateChanged)
+}
+
+// StateChangedReverse sorts resources by the time of their last state
+// change in reverse order, the latest first.
+func StateChangedReverse(r1, r2 *ByRes) bool {
+	return r1.StateChanged.After(r2.StateChanged)
+}
@@ -73,6 +73,29 @@ func TestByRes(t *testing.T) {
 		t.Errorf("TestByRes: Expected devices volume 0's disk state to be %q got %q", "UpToDate", br.Device.Volumes["0"].DiskState)
 	}
 
+	evt, err = resource.NewEvent("2017-02-15T14:43:16.688437+00:00 exists path " +
+		"name:test0 peer-node-id:1 conn-name:peer local:ipv4:10.0.0.1:7000 peer:ipv4:10.0.0.2:7000 established:yes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.Update(evt)
+
+	if len(br.Connections["peer"].Paths) != 1 {
+		t.Errorf("TestByRes: Expected connection to peer to have %d path, got %d", 1, len(br.Connections["peer"].Paths))
+	}
+
+	// Paths of unknown connections are ignored.
+	evt, err = resource.NewEvent("2017-02-15T14:43:16.688437+00:00 exists path " +
+		"name:test0 peer-node-id:2 conn-name:other local:ipv4:10.0.0.1:7001 peer:ipv4:10.0.0.3:7001 established:no")
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.Update(evt)
+
+	if _, ok := br.Connections["other"]; ok {
+		t.Error("TestByRes: Expected no connection to be created by a path")
+	}
+
 	br.prune(time.Now())
 
 	if _, ok := br.Connections["peer"]; ok {
@@ -110,6 +133,164 @@ func TestResourceCollection(t *testing.T) {
 	}
 }
 
+func newTestEvent(t *testing.T, s string) resource.Event {
+	evt, err := resource.NewEvent(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return evt
+}
+
+func newDestroyTestByRes(t *testing.T) *ByRes {
+	br := NewByRes()
+	for _, s := range []string{
+		"2017-02-15T14:43:16.688437+00:00 exists resource name:test0 role:Primary suspended:no write-ordering:flush",
+		"2017-02-15T14:43:16.688437+00:00 exists connection name:test0 conn-name:peer connection:StandAlone role:Unknown congested:no",
+		"2017-02-15T14:43:16.688437+00:00 exists connection name:test0 conn-name:other connection:Connected role:Secondary congested:no",
+		"2017-02-15T14:43:16.688437+00:00 exists device name:test0 volume:0 minor:0 disk:UpToDate client:no size:4056 read:1340 written:16 al-writes:1 bm-writes:0 upper-pending:0 lower-pending:0 al-suspended:no blocked:no",
+		"2017-02-15T14:43:16.688437+00:00 exists device name:test0 volume:1 minor:1 disk:Outdated client:no size:4056 read:1340 written:16 al-writes:1 bm-writes:0 upper-pending:0 lower-pending:0 al-suspended:no blocked:no",
+		"2017-02-15T14:43:16.688437+00:00 exists peer-device name:test0 conn-name:peer volume:0 replication:Off peer-disk:DUnknown resync-suspended:no received:0 sent:0 out-of-sync:0 pending:0 unacked:0",
+		"2017-02-15T14:43:16.688437+00:00 exists peer-device name:test0 conn-name:other volume:0 replication:Established peer-disk:UpToDate resync-suspended:no received:0 sent:0 out-of-sync:0 pending:0 unacked:0",
+		"2017-02-15T14:43:16.688437+00:00 exists peer-device name:test0 conn-name:other volume:1 replication:Established peer-disk:Outdated resync-suspended:no received:0 sent:0 out-of-sync:0 pending:0 unacked:0",
+	} {
+		br.Update(newTestEvent(t, s))
+	}
+	return br
+}
+
+func TestByResDestroy(t *testing.T) {
+	br := newDestroyTestByRes(t)
+	danger := br.Danger
+
+	br.Update(newTestEvent(t, "2017-02-15T14:43:17.688437+00:00 destroy peer-device name:test0 conn-name:other volume:1"))
+
+	if _, ok := br.PeerDevices["other"].Volumes["1"]; ok {
+		t.Error("TestByResDestroy: Expected peer device volume 1 of other to be removed")
+	}
+	if _, ok := br.PeerDevices["other"].Volumes["0"]; !ok {
+		t.Error("TestByResDestroy: Expected peer device volume 0 of other to remain")
+	}
+	if br.Danger >= danger {
+		t.Errorf("TestByResDestroy: Expected danger to drop below %d after destroying an Outdated peer disk, got %d", danger, br.Danger)
+	}
+	danger = br.Danger
+
+	br.Update(newTestEvent(t, "2017-02-15T14:43:17.688437+00:00 destroy peer-device name:test0 conn-name:other volume:0"))
+
+	if _, ok := br.PeerDevices["other"]; ok {
+		t.Error("TestByResDestroy: Expected peer device other without volumes to be removed")
+	}
+
+	br.Update(newTestEvent(t, "2017-02-15T14:43:17.688437+00:00 destroy connection name:test0 conn-name:peer"))
+
+	if _, ok := br.Connections["peer"]; ok {
+		t.Error("TestByResDestroy: Expected connection to peer to be removed")
+	}
+	if _, ok := br.PeerDevices["peer"]; ok {
+		t.Error("TestByResDestroy: Expected peer devices of peer to be removed with the connection")
+	}
+	if _, ok := br.Connections["other"]; !ok {
+		t.Error("TestByResDestroy: Expected connection to other to remain")
+	}
+	if br.Danger >= danger {
+		t.Errorf("TestByResDestroy: Expected danger to drop below %d after destroying a StandAlone connection, got %d", danger, br.Danger)
+	}
+
+	br.Update(newTestEvent(t, "2017-02-15T14:43:17.688437+00:00 destroy device name:test0 volume:1 minor:1"))
+
+	if _, ok := br.Device.Volumes["1"]; ok {
+		t.Error("TestByResDestroy: Expected device volume 1 to be removed")
+	}
+	if br.Device.Danger != 0 {
+		t.Errorf("TestByResDestroy: Expected device danger to be %d, got %d", 0, br.Device.Danger)
+	}
+	if br.Danger != 0 {
+		t.Errorf("TestByResDestroy: Expected overall danger to be %d, got %d", 0, br.Danger)
+	}
+}
+
+func TestResourceCollectionDestroy(t *testing.T) {
+	rc := NewResourceCollection(0) // Turn off pruning with zero.
+
+	rc.Update(newTestEvent(t, "2017-02-15T14:43:16.688437+00:00 exists resource name:test0 role:Primary suspended:no write-ordering:flush"))
+	rc.Update(newTestEvent(t, "2017-02-15T14:43:16.688437+00:00 exists resource name:test1 role:Primary suspended:no write-ordering:flush"))
+
+	// Destroying parts of an unknown resource must not create it.
+	rc.Update(newTestEvent(t, "2017-02-15T14:43:17.688437+00:00 destroy connection name:test2 conn-name:peer"))
+	if _, ok := rc.Map["test2"]; ok {
+		t.Error("TestResourceCollectionDestroy: Expected test2 not to be created by a destroy event")
+	}
+
+	rc.Update(newTestEvent(t, "2017-02-15T14:43:17.688437+00:00 destroy resource name:test0"))
+	rc.UpdateList()
+
+	if _, ok := rc.Map["test0"]; ok {
+		t.Error("TestResourceCollectionDestroy: Expected test0 to be removed")
+	}
+	if len(rc.List) != 1 || rc.List[0].Res.Name != "test1" {
+		t.Errorf("TestResourceCollectionDestroy: Expected only test1 to be listed, got %d resources", len(rc.List))
+	}
+}
+
+func TestResourceCollectionReset(t *testing.T) {
+	rc := NewResourceCollection(0) // Turn off pruning with zero.
+
+	rc.Update(newTestEvent(t, "2017-02-15T14:43:16.688437+00:00 exists resource name:test0 role:Primary suspended:no write-ordering:flush"))
+	rc.Update(resource.NewResetEvent())
+	rc.UpdateList()
+
+	if len(rc.Map) != 0 || len(rc.List) != 0 {
+		t.Errorf("TestResourceCollectionReset: Expected no resources after a reset, got %d", len(rc.Map))
+	}
+}
+
+func TestResourceCollectionResetStats(t *testing.T) {
+	rc := NewResourceCollection(0) // Turn off pruning with zero.
+
+	rc.Update(newTestEvent(t, "2017-02-15T14:43:16.688437+00:00 exists peer-device name:test0 conn-name:peer volume:0 replication:Established peer-disk:UpToDate resync-suspended:no received:0 sent:0 out-of-sync:0 pending:0 unacked:100"))
+	rc.Update(newTestEvent(t, "2017-02-15T14:43:17.688437+00:00 exists peer-device name:test0 conn-name:peer volume:0 replication:Established peer-disk:UpToDate resync-suspended:no received:0 sent:0 out-of-sync:0 pending:0 unacked:2"))
+	rc.ResetStats()
+
+	unacked := rc.Map["test0"].PeerDevices["peer"].Volumes["0"].UnackedWrites
+	if unacked.Max != 2 || unacked.Min != 2 || unacked.Current != 2 {
+		t.Errorf("TestResourceCollectionResetStats: Expected only the current value 2 to be left, got min %d max %d current %d",
+			unacked.Min, unacked.Max, unacked.Current)
+	}
+}
+
+func TestByResSplitBrain(t *testing.T) {
+	br := NewByRes()
+	br.Update(newTestEvent(t, "2017-02-15T14:43:16.688437+00:00 exists connection name:test0 conn-name:peer connection:Connecting role:Unknown congested:no"))
+	br.Update(newTestEvent(t, "2017-02-15T14:43:17.688437+00:00 change connection name:test0 conn-name:peer connection:StandAlone"))
+	danger := br.Danger
+
+	br.Update(newTestEvent(t, "2017-02-15T14:43:17.688437+00:00 call helper name:test0 peer-node-id:1 conn-name:peer volume:0 helper:split-brain"))
+
+	sb := br.Connections["peer"].SplitBrain
+	if sb == nil || !sb.Confirmed {
+		t.Fatalf("TestByResSplitBrain: Expected a confirmed split brain, got %v", sb)
+	}
+	if br.Danger <= danger {
+		t.Errorf("TestByResSplitBrain: Expected danger to rise above %d, got %d", danger, br.Danger)
+	}
+
+	// Reports about unknown connections are ignored.
+	br.Update(newTestEvent(t, "2017-02-15T14:43:17.688437+00:00 call helper name:test0 peer-node-id:2 conn-name:other volume:0 helper:split-brain"))
+	if _, ok := br.Connections["other"]; ok {
+		t.Error("TestByResSplitBrain: Expected no connection to be created by a helper call")
+	}
+}
+
+func TestResourceCollectionSplitBrainUnknown(t *testing.T) {
+	rc := NewResourceCollection(0) // Turn off pruning with zero.
+
+	rc.Update(resource.NewSplitBrainEvent("gone", "peer", resource.SplitBrainKernel))
+	rc.Update(newTestEvent(t, "2017-02-15T14:43:17.688437+00:00 call helper name:gone peer-node-id:1 conn-name:peer volume:0 helper:split-brain"))
+	if len(rc.Map) != 0 {
+		t.Errorf("TestResourceCollectionSplitBrainUnknown: Expected no resource to be created, got %d", len(rc.Map))
+	}
+}
+
 func TestName(t *testing.T) {
 	var nameTests = []struct {
 		n1  *ByRes
@@ -133,3 +314,75 @@ func TestName(t *testing.T) {
 		}
 	}
 }
+
+func TestByResStateChanged(t *testing.T) {
+	br := NewByRes()
+	br.Update(newTestEvent(t, "2017-02-15T14:43:16.688437+00:00 exists peer-device name:test0 conn-name:peer volume:0 replication:Established peer-disk:UpToDate out-of-sync:0"))
+	first := br.StateChanged
+	if first.IsZero() {
+		t.Fatal("Expected a new peer device to change the state")
+	}
+
+	// Only statistics.
+	br.Update(newTestEvent(t, "2017-02-15T14:43:17.688437+00:00 exists peer-device name:test0 conn-name:peer volume:0 replication:Established peer-disk:UpToDate out-of-sync:100"))
+	if !br.StateChanged.Equal(first) {
+		t.Errorf("Expected the state change at %s, got %s", first, br.StateChanged)
+	}
+
+	br.Update(newTestEvent(t, "2017-02-15T14:43:18.688437+00:00 change peer-device name:test0 conn-name:peer volume:0 replication:SyncSource peer-disk:Inconsistent out-of-sync:100"))
+	if !br.StateChanged.After(first) {
+		t.Errorf("Expected a state change after %s, got %s", first, br.StateChanged)
+	}
+}
+
+func TestSortBy(t *testing.T) {
+	newRes := func(name string, events ...string) *ByRes {
+		br := NewByRes()
+		for _, e := range events {
+			br.Update(newTestEvent(t, e))
+		}
+		br.Res.Name = name
+		return br
+	}
+
+	// busy is Primary, writes and reads more, is further behind its peers,
+	// has more peers and changed its state last.
+	busy := newRes("busy",
+		"2017-02-15T14:43:16.688437+00:00 exists resource name:busy role:Primary",
+		"2017-02-15T14:43:16.688437+00:00 exists device name:busy volume:0 disk:UpToDate read:0 written:0",
+		"2017-02-15T14:43:17.688437+00:00 exists device name:busy volume:0 disk:UpToDate read:1000 written:2000",
+		"2017-02-15T14:43:16.688437+00:00 exists connection name:busy conn-name:a connection:Connected role:Secondary",
+		"2017-02-15T14:43:16.688437+00:00 exists connection name:busy conn-name:b connection:Connected role:Secondary",
+		"2017-02-15T14:43:18.688437+00:00 exists peer-device name:busy conn-name:a volume:0 replication:SyncSource peer-disk:Inconsistent out-of-sync:500 pending:3 unacked:4",
+	)
+	idle := newRes("idle",
+		"2017-02-15T14:43:16.688437+00:00 exists resource name:idle role:Secondary",
+		"2017-02-15T14:43:16.688437+00:00 exists device name:idle volume:0 disk:UpToDate read:0 written:0",
+		"2017-02-15T14:43:17.688437+00:00 exists device name:idle volume:0 disk:UpToDate read:10 written:20",
+		"2017-02-15T14:43:16.688437+00:00 exists connection name:idle conn-name:a connection:Connected role:Secondary",
+		"2017-02-15T14:43:16.688437+00:00 exists peer-device name:idle conn-name:a volume:0 replication:Established peer-disk:UpToDate out-of-sync:0 pending:0 unacked:0",
+	)
+
+	var sortTests = []struct {
+		name      string
+		busyFirst LessFunc
+		idleFirst LessFunc
+	}{
+		{"Role", Role, RoleReverse},
+		{"WriteRate", WriteRateReverse, WriteRate},
+		{"ReadRate", ReadRateReverse, ReadRate},
+		{"OutOfSync", OutOfSyncReverse, OutOfSync},
+		{"Pending", PendingReverse, Pending},
+		{"Unacked", UnackedReverse, Unacked},
+		{"Peers", PeersReverse, Peers},
+		{"StateChanged", StateChangedReverse, StateChanged},
+	}
+	for _, tt := range sortTests {
+		if !tt.busyFirst(busy, idle) || tt.busyFirst(idle, busy) {
+			t.Errorf("%s: Expected busy before idle", tt.name)
+		}
+		if tt.idleFirst(busy, idle) || !tt.idleFirst(idle, busy) {
+			t.Errorf("%s: Expected idle before busy", tt.name)
+		}
+	}
+}